@@ -1,115 +1,198 @@
 package api
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gopkg.in/yaml.v3"
+
+	"rag-server/internal/rag"
+	rconfig "rag-server/internal/rag/config"
+	"rag-server/internal/rag/generate"
 )
 
 // askFn performs the chat completion request. It is replaceable in tests.
 var askFn = callLLM
 
-// registerAskAIRoutes wires the /api/askai endpoint.
+// registerAskAIRoutes wires the /api/askai endpoint and the conversation
+// history endpoints.
 func registerAskAIRoutes(r *gin.RouterGroup) {
-	r.POST("/askai", func(c *gin.Context) {
-		var req struct {
-			Question string `json:"question"`
-		}
-		if err := c.BindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
+	r.POST("/askai", handleAskAI)
+	registerConversationRoutes(r.Group("/askai/conversations"))
+}
 
-		var chunks any
-		if ragSvc != nil {
-			docs, _ := ragSvc.Query(c.Request.Context(), req.Question, 5)
-			chunks = docs
-		}
+// askTurn carries the state of a single AskAI question through generation.
+type askTurn struct {
+	question       string
+	conversationID string
+	docs           []rag.Document
+	messages       []chatMessage
+	sources        []citation
+}
+
+func handleAskAI(c *gin.Context) {
+	var req struct {
+		Question        string `json:"question"`
+		ConversationID  string `json:"conversation_id"`
+		NewConversation bool   `json:"new_conversation"`
+		Stream          bool   `json:"stream"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+
+	conversationID, history, ok := resolveConversation(c, req.ConversationID, req.NewConversation, req.Question)
+	if !ok {
+		return
+	}
+
+	query := req.Question
+	if len(history) > 0 {
+		query = condenseQuestion(ctx, history, req.Question)
+	}
 
-		answer, err := askFn(req.Question)
+	var docs []rag.Document
+	if svc := getRAG(); svc != nil {
+		var err error
+		docs, err = svc.Query(ctx, query, rag.QueryOptions{})
 		if err != nil {
-			_, _, endpoint, timeout, retries := loadConfig()
-			slog.Error("askai request failed",
-				"question", req.Question,
-				"endpoint", endpoint,
-				"err", err,
-			)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-				"config": gin.H{
-					"timeout": timeout.Seconds(),
-					"retries": retries,
-				},
-			})
-			return
+			slog.Warn("askai retrieval failed", "question", req.Question, "query", query, "err", err)
 		}
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"answer": answer,
-			"chunks": chunks,
+	messages, sources := buildGroundedMessages(req.Question, docs)
+	turn := askTurn{
+		question:       req.Question,
+		conversationID: conversationID,
+		docs:           docs,
+		messages:       withHistory(messages, history),
+		sources:        sources,
+	}
+	if req.Stream {
+		streamAskAI(c, turn)
+		return
+	}
+	answer, err := askFn(ctx, turn.messages)
+	if err != nil {
+		_, _, endpoint, timeout, retries := loadConfig()
+		slog.Error("askai request failed",
+			"question", req.Question,
+			"endpoint", endpoint,
+			"err", err,
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+			"config": gin.H{
+				"timeout": timeout.Seconds(),
+				"retries": retries,
+			},
 		})
-	})
+		return
+	}
+
+	cited := extractCitations(answer, sources)
+	saveTurn(ctx, turn, answer, cited)
+	resp := gin.H{
+		"answer":    answer,
+		"chunks":    docs,
+		"citations": cited,
+	}
+	if conversationID != "" {
+		resp["conversation_id"] = conversationID
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
+// defaultHistoryWindow is the number of previous messages replayed to the
+// generator when api.askai.history_window is not configured.
+const defaultHistoryWindow = 10
+
 // ConfigPath points to the server configuration file.
 var ConfigPath = filepath.Join("config", "rag-server.yaml")
 
 type serverConfig struct {
 	Models struct {
+		Embedder struct {
+			Models []string `yaml:"models"`
+		} `yaml:"embedder"`
 		Generator struct {
-			Models   []string `yaml:"models"`
-			Endpoint string   `yaml:"endpoint"`
-			Token    string   `yaml:"token"`
+			Provider  string              `yaml:"provider"`
+			Models    []string            `yaml:"models"`
+			Endpoint  string              `yaml:"endpoint"`
+			Endpoints rconfig.StringSlice `yaml:"endpoints"`
+			Token     string              `yaml:"token"`
+			Timeout   rconfig.Duration    `yaml:"timeout"`
 		} `yaml:"generator"`
 	} `yaml:"models"`
 	API struct {
 		AskAI struct {
-			Timeout int `yaml:"timeout"` // seconds
-			Retries int `yaml:"retries"`
+			Timeout       int `yaml:"timeout"` // seconds
+			Retries       int `yaml:"retries"`
+			HistoryWindow int `yaml:"history_window"` // messages
 		} `yaml:"askai"`
 	} `yaml:"api"`
 }
 
-// loadConfig reads model, endpoint, timeout and retries from ConfigPath
-// and environment variables.
+// readServerConfig parses ConfigPath after expanding environment variables.
+func readServerConfig() (serverConfig, error) {
+	var cfg serverConfig
+	data, err := os.ReadFile(ConfigPath)
+	if err != nil {
+		return cfg, err
+	}
+	expanded := os.ExpandEnv(string(data))
+	err = yaml.Unmarshal([]byte(expanded), &cfg)
+	return cfg, err
+}
+
+// historyWindow returns how many previous conversation messages are sent to
+// the generator.
+func historyWindow() int {
+	cfg, err := readServerConfig()
+	if err != nil || cfg.API.AskAI.HistoryWindow == 0 {
+		return defaultHistoryWindow
+	}
+	if cfg.API.AskAI.HistoryWindow < 0 {
+		return 0
+	}
+	return cfg.API.AskAI.HistoryWindow
+}
+
+// loadConfig reads the token, primary model, endpoint, timeout and retries
+// from ConfigPath and environment variables.
 func loadConfig() (string, string, string, time.Duration, int) {
 	model := os.Getenv("CHUTES_API_MODEL")
 	endpoint := os.Getenv("CHUTES_API_URL")
 	token := ""
 	timeout := 30 * time.Second
 	retries := 3
-	data, err := os.ReadFile(ConfigPath)
-	if err == nil {
-		expanded := os.ExpandEnv(string(data))
-		var cfg serverConfig
-		if err := yaml.Unmarshal([]byte(expanded), &cfg); err == nil {
-			g := cfg.Models.Generator
-			if model == "" && len(g.Models) > 0 {
-				model = g.Models[0]
-			}
-			if endpoint == "" {
-				endpoint = g.Endpoint
-			}
-			if token == "" {
-				token = g.Token
-			}
-			if cfg.API.AskAI.Timeout > 0 {
-				timeout = time.Duration(cfg.API.AskAI.Timeout) * time.Second
-			}
-			if cfg.API.AskAI.Retries > 0 {
-				retries = cfg.API.AskAI.Retries
-			}
+	if cfg, err := readServerConfig(); err == nil {
+		g := cfg.Models.Generator
+		if model == "" && len(g.Models) > 0 {
+			model = g.Models[0]
+		}
+		if endpoint == "" {
+			endpoint = g.Endpoint
+		}
+		if token == "" {
+			token = g.Token
+		}
+		if g.Timeout.Duration > 0 {
+			timeout = g.Timeout.Duration
+		} else if cfg.API.AskAI.Timeout > 0 {
+			timeout = time.Duration(cfg.API.AskAI.Timeout) * time.Second
+		}
+		if cfg.API.AskAI.Retries > 0 {
+			retries = cfg.API.AskAI.Retries
 		}
 	}
 	// Allow custom timeout values without imposing a hard cap.
@@ -117,66 +200,48 @@ func loadConfig() (string, string, string, time.Duration, int) {
 		retries = 3
 	}
 	endpoint = strings.TrimRight(endpoint, "/")
-	if model == "" {
-		if token == "" || strings.Contains(endpoint, "127.0.0.1") || strings.Contains(endpoint, "localhost") {
-			model = "llama2:13b"
-		} else {
-			model = "moonshotai/Kimi-K2-Instruct"
-		}
-	}
 	return token, model, endpoint, timeout, retries
 }
 
-// callLLM dispatches the question to the configured endpoint.
-func callLLM(question string) (string, error) {
-	token, model, url, timeout, retries := loadConfig()
-
-	httpClient := &http.Client{Timeout: timeout}
-
-	payload := map[string]any{
-		"model":    model,
-		"messages": []map[string]string{{"role": "user", "content": question}},
-	}
-	body, _ := json.Marshal(payload)
-	var lastErr error
-	for i := 0; i <= retries; i++ {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-		if err != nil {
-			cancel()
-			return "", fmt.Errorf("build request: %w", err)
+// generatorModels returns the models to try in order. The model from
+// CHUTES_API_MODEL, when set, is tried before the configured list.
+func generatorModels(cfg serverConfig) []string {
+	var models []string
+	seen := map[string]bool{}
+	for _, m := range append([]string{os.Getenv("CHUTES_API_MODEL")}, cfg.Models.Generator.Models...) {
+		if m != "" && !seen[m] {
+			seen[m] = true
+			models = append(models, m)
 		}
-		req.Header.Set("Content-Type", "application/json")
-		if token != "" {
-			req.Header.Set("Authorization", "Bearer "+token)
-		}
-		resp, err := httpClient.Do(req)
-		if err == nil && resp != nil {
-			data, readErr := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			if readErr == nil && resp.StatusCode < 300 {
-				var out struct {
-					Choices []struct {
-						Message struct {
-							Content string `json:"content"`
-						} `json:"message"`
-					} `json:"choices"`
-				}
-				if err = json.Unmarshal(data, &out); err == nil && len(out.Choices) > 0 {
-					cancel()
-					return out.Choices[0].Message.Content, nil
-				}
-			} else if readErr != nil {
-				err = readErr
-			} else {
-				err = fmt.Errorf("%s", resp.Status)
-			}
-		}
-		cancel()
-		lastErr = err
 	}
-	if lastErr == nil {
-		lastErr = fmt.Errorf("request failed")
+	return models
+}
+
+// newGenerator builds the generator fallback chain from ConfigPath. The
+// endpoint from loadConfig is tried before models.generator.endpoints, and
+// model, when set, before the other generator models.
+func newGenerator(model string) generate.Generator {
+	token, _, endpoint, timeout, retries := loadConfig()
+	cfg, _ := readServerConfig()
+	endpoints := rconfig.ModelCfg{Endpoint: endpoint, Endpoints: cfg.Models.Generator.Endpoints}.EndpointList()
+	models := generatorModels(cfg)
+	if model != "" {
+		models = append([]string{model}, slices.DeleteFunc(models, func(m string) bool { return m == model })...)
+	}
+	return generate.New(cfg.Models.Generator.Provider, endpoints, token, models, timeout, retries)
+}
+
+// callLLM dispatches the chat messages to the configured generators.
+func callLLM(ctx context.Context, messages []chatMessage) (string, error) {
+	resp, err := completeLLM(ctx, "", messages)
+	if err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("%w (timeout=%s retries=%d)", lastErr, timeout, retries)
+	return resp.Content, nil
+}
+
+// completeLLM is callLLM returning the whole response, including the model
+// that answered and its token usage. A non-empty model is tried first.
+func completeLLM(ctx context.Context, model string, messages []chatMessage) (generate.Response, error) {
+	return newGenerator(model).Generate(ctx, generate.Request{Messages: messages})
 }
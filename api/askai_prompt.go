@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"rag-server/internal/model"
+	"rag-server/internal/rag"
+	"rag-server/internal/rag/generate"
+)
+
+// maxContextChars bounds the amount of retrieved text placed into the prompt.
+const maxContextChars = 12000
+
+const groundedSystemPrompt = `You are a support assistant for the knowledge base described by the numbered sources below.
+Answer the user's question using only these sources. After every statement that relies on a
+source, add its citation marker, for example [1] or [2][3]. Do not cite sources that are not
+listed. If the sources do not contain the answer, say that the knowledge base does not cover
+it instead of guessing.`
+
+const ungroundedSystemPrompt = `You are a support assistant for a knowledge base. No sources were
+retrieved for this question. Say that the knowledge base does not cover it, and only add general
+guidance if you are confident it is correct. Do not include citation markers.`
+
+const condenseSystemPrompt = `Rewrite the user's follow-up question as a standalone search query for a
+knowledge base, using the conversation for context. Keep the language of the question. Reply with
+the query only.`
+
+// chatMessage is a single OpenAI-style chat message.
+type chatMessage = generate.Message
+
+// citation identifies the chunk behind a numbered source in the prompt.
+type citation struct {
+	Index   int    `json:"index"`
+	Repo    string `json:"repo"`
+	Path    string `json:"path"`
+	ChunkID int    `json:"chunk_id"`
+}
+
+var citationMarker = regexp.MustCompile(`\[(\d+)\]`)
+
+// buildGroundedMessages places the retrieved documents into a system prompt as
+// numbered sources and returns the messages together with the source list.
+func buildGroundedMessages(question string, docs []rag.Document) ([]chatMessage, []citation) {
+	if len(docs) == 0 {
+		return []chatMessage{
+			{Role: "system", Content: ungroundedSystemPrompt},
+			{Role: "user", Content: question},
+		}, nil
+	}
+
+	var b strings.Builder
+	b.WriteString(groundedSystemPrompt)
+	b.WriteString("\n\nSources:\n")
+	sources := make([]citation, 0, len(docs))
+	budget := maxContextChars
+	for _, d := range docs {
+		if budget <= 0 {
+			break
+		}
+		content := strings.TrimSpace(d.Content)
+		if len(content) > budget {
+			content = truncateUTF8(content, budget)
+		}
+		budget -= len(content)
+		src := citation{Index: len(sources) + 1, Repo: d.Repo, Path: d.Path, ChunkID: d.ChunkID}
+		sources = append(sources, src)
+		fmt.Fprintf(&b, "\n[%d] %s/%s#%d\n%s\n", src.Index, d.Repo, d.Path, d.ChunkID, content)
+	}
+	return []chatMessage{
+		{Role: "system", Content: b.String()},
+		{Role: "user", Content: question},
+	}, sources
+}
+
+// buildCondenseMessages asks the generator to turn a follow-up question into a
+// standalone retrieval query.
+func buildCondenseMessages(history []model.ConversationMessage, question string) []chatMessage {
+	var b strings.Builder
+	b.WriteString("Conversation:\n")
+	for _, h := range history {
+		fmt.Fprintf(&b, "%s: %s\n", h.Role, strings.TrimSpace(h.Content))
+	}
+	fmt.Fprintf(&b, "\nFollow-up question: %s", question)
+	return []chatMessage{
+		{Role: "system", Content: condenseSystemPrompt},
+		{Role: "user", Content: b.String()},
+	}
+}
+
+// extractCitations returns the sources referenced by citation markers in the
+// answer, in order of first appearance. Markers without a matching source are
+// ignored.
+func extractCitations(answer string, sources []citation) []citation {
+	out := []citation{}
+	seen := make(map[int]struct{})
+	for _, m := range citationMarker.FindAllStringSubmatch(answer, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n < 1 || n > len(sources) {
+			continue
+		}
+		if _, ok := seen[n]; ok {
+			continue
+		}
+		seen[n] = struct{}{}
+		out = append(out, sources[n-1])
+	}
+	return out
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"rag-server/internal/rag"
+)
+
+func TestBuildGroundedMessages(t *testing.T) {
+	docs := []rag.Document{
+		{Repo: "kb", Path: "a.md", ChunkID: 1, Content: "alpha"},
+		{Repo: "kb", Path: "b.md", ChunkID: 2, Content: "beta"},
+	}
+	msgs, sources := buildGroundedMessages("q", docs)
+	if len(msgs) != 2 || msgs[0].Role != "system" || msgs[1].Content != "q" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+	if !strings.Contains(msgs[0].Content, "[1] kb/a.md#1\nalpha") || !strings.Contains(msgs[0].Content, "[2] kb/b.md#2\nbeta") {
+		t.Fatalf("sources not numbered: %q", msgs[0].Content)
+	}
+	if len(sources) != 2 || sources[1].Index != 2 || sources[1].Path != "b.md" {
+		t.Fatalf("unexpected sources: %+v", sources)
+	}
+}
+
+func TestBuildGroundedMessagesNoDocs(t *testing.T) {
+	msgs, sources := buildGroundedMessages("q", nil)
+	if len(msgs) != 2 || sources != nil {
+		t.Fatalf("unexpected result: %+v %+v", msgs, sources)
+	}
+	if strings.Contains(msgs[0].Content, "Sources:") {
+		t.Fatalf("ungrounded prompt should not list sources")
+	}
+}
+
+func TestExtractCitations(t *testing.T) {
+	sources := []citation{{Index: 1, Path: "a"}, {Index: 2, Path: "b"}, {Index: 3, Path: "c"}}
+	got := extractCitations("x [3] y [1][3] z [9]", sources)
+	if len(got) != 2 || got[0].Index != 3 || got[1].Index != 1 {
+		t.Fatalf("unexpected citations: %+v", got)
+	}
+	if got := extractCitations("no markers", sources); len(got) != 0 {
+		t.Fatalf("expected no citations, got %+v", got)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	if got := truncateUTF8("中文", 4); got != "中" {
+		t.Fatalf("unexpected truncation %q", got)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"rag-server/internal/rag/generate"
+)
+
+// streamFn performs a streaming chat completion request. It is replaceable in
+// tests.
+var streamFn = streamLLM
+
+// chatUsage reports token usage of a chat completion.
+type chatUsage = generate.Usage
+
+// streamAskAI answers an askai request as Server-Sent Events. It emits a
+// "chunks" event with the retrieved documents, one "delta" event per content
+// fragment from the generator and a final "done" event with usage and
+// citations. Failures after the stream has started are reported as an "error"
+// event because the status code has already been sent.
+func streamAskAI(c *gin.Context, turn askTurn) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	first := gin.H{"chunks": turn.docs}
+	if turn.conversationID != "" {
+		first["conversation_id"] = turn.conversationID
+	}
+	c.SSEvent("chunks", first)
+	c.Writer.Flush()
+
+	var answer strings.Builder
+	resp, err := streamFn(c.Request.Context(), "", turn.messages, func(delta string) error {
+		answer.WriteString(delta)
+		c.SSEvent("delta", gin.H{"content": delta})
+		c.Writer.Flush()
+		return c.Request.Context().Err()
+	})
+	if err != nil {
+		_, _, endpoint, _, _ := loadConfig()
+		slog.Error("askai stream failed",
+			"question", turn.question,
+			"endpoint", endpoint,
+			"err", err,
+		)
+		c.SSEvent("error", gin.H{"error": err.Error()})
+		c.Writer.Flush()
+		return
+	}
+
+	cited := extractCitations(answer.String(), turn.sources)
+	saveTurn(c.Request.Context(), turn, answer.String(), cited)
+	c.SSEvent("done", gin.H{
+		"usage":     resp.Usage,
+		"citations": cited,
+	})
+	c.Writer.Flush()
+}
+
+// streamLLM streams the chat messages from the configured generators,
+// trying a non-empty model first, and invokes onDelta for every content
+// delta. The chain falls back to the next model only until the first delta
+// has been delivered.
+func streamLLM(ctx context.Context, model string, messages []chatMessage, onDelta func(string) error) (generate.Response, error) {
+	return newGenerator(model).Stream(ctx, generate.Request{Messages: messages}, onDelta)
+}
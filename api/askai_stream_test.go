@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"rag-server/internal/rag/generate"
+	"rag-server/internal/rag/store"
+)
+
+// TestAskAI_Stream verifies that stream mode emits chunks, deltas and a final
+// done event with citations.
+func TestAskAI_Stream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+
+	oldSvc := ragSvc
+	ragSvc = &mockRAGService{dim: 2, docs: []store.DocRow{
+		{Repo: "kb", Path: "docs/a.md", ChunkID: 0, Content: "backups run nightly"},
+	}}
+	defer func() { ragSvc = oldSvc }()
+
+	old := streamFn
+	defer func() { streamFn = old }()
+	streamFn = func(ctx context.Context, model string, messages []chatMessage, onDelta func(string) error) (generate.Response, error) {
+		for _, d := range []string{"Backups run ", "nightly [1]."} {
+			if err := onDelta(d); err != nil {
+				return generate.Response{}, err
+			}
+		}
+		return generate.Response{Usage: chatUsage{PromptTokens: 10, CompletionTokens: 4, TotalTokens: 14}}, nil
+	}
+
+	register(r)
+
+	body, _ := json.Marshal(map[string]any{"question": "when do backups run?", "stream": true})
+	req := httptest.NewRequest(http.MethodPost, "/api/askai", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	authorize(t, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	out := w.Body.String()
+	var events []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "event:") {
+			events = append(events, strings.TrimPrefix(line, "event:"))
+		}
+	}
+	if strings.Join(events, ",") != "chunks,delta,delta,done" {
+		t.Fatalf("unexpected events %v in %q", events, out)
+	}
+	if !strings.Contains(out, `"total_tokens":14`) || !strings.Contains(out, `"path":"docs/a.md"`) {
+		t.Fatalf("done event missing usage or citations: %q", out)
+	}
+}
+
+func TestStreamLLM(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload["stream"] != true {
+			t.Errorf("expected stream payload, got %v (%v)", payload, err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	cfgPath := filepath.Join(t.TempDir(), "server.yaml")
+	data := []byte("models:\n  generator:\n    models: [\"m\"]\n    endpoint: " + srv.URL + "\napi:\n  askai:\n    timeout: 5\n    retries: 1\n")
+	if err := os.WriteFile(cfgPath, data, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	old := ConfigPath
+	ConfigPath = cfgPath
+	t.Cleanup(func() { ConfigPath = old })
+
+	var got strings.Builder
+	resp, err := streamLLM(context.Background(), "", []chatMessage{{Role: "user", Content: "hi"}}, func(d string) error {
+		got.WriteString(d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("streamLLM: %v", err)
+	}
+	if got.String() != "Hello" {
+		t.Fatalf("unexpected content %q", got.String())
+	}
+	if resp.Usage.TotalTokens != 5 {
+		t.Fatalf("unexpected usage %+v", resp.Usage)
+	}
+}
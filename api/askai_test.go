@@ -2,15 +2,19 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+
+	"rag-server/internal/rag/store"
 )
 
 // TestAskAI verifies the /api/askai endpoint returns an answer.
@@ -22,7 +26,7 @@ func TestAskAI(t *testing.T) {
 	// stub ask function
 	old := askFn
 	defer func() { askFn = old }()
-	askFn = func(q string) (string, error) {
+	askFn = func(ctx context.Context, messages []chatMessage) (string, error) {
 		return "stub answer", nil
 	}
 
@@ -37,12 +41,14 @@ func TestAskAI(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected status 200, got %d", w.Code)
 	}
-	var resp map[string]string
+	var resp struct {
+		Answer string `json:"answer"`
+	}
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("unmarshal response: %v", err)
 	}
-	if resp["answer"] != "stub answer" {
-		t.Fatalf("unexpected answer %q", resp["answer"])
+	if resp.Answer != "stub answer" {
+		t.Fatalf("unexpected answer %q", resp.Answer)
 	}
 }
 
@@ -55,7 +61,7 @@ func TestAskAI_BadRequest(t *testing.T) {
 	// ensure askFn is stubbed to avoid dependency
 	old := askFn
 	defer func() { askFn = old }()
-	askFn = func(q string) (string, error) {
+	askFn = func(ctx context.Context, messages []chatMessage) (string, error) {
 		return "", nil
 	}
 
@@ -79,7 +85,7 @@ func TestAskAI_ErrorLogged(t *testing.T) {
 
 	old := askFn
 	defer func() { askFn = old }()
-	askFn = func(q string) (string, error) {
+	askFn = func(ctx context.Context, messages []chatMessage) (string, error) {
 		return "", fmt.Errorf("fail")
 	}
 
@@ -98,3 +104,51 @@ func TestAskAI_ErrorLogged(t *testing.T) {
 		t.Fatalf("expected status 500, got %d", w.Code)
 	}
 }
+
+// TestAskAI_GroundedCitations verifies retrieved chunks are sent to the
+// generator as numbered sources and cited chunks are returned.
+func TestAskAI_GroundedCitations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+
+	oldSvc := ragSvc
+	ragSvc = &mockRAGService{dim: 2, docs: []store.DocRow{
+		{Repo: "kb", Path: "docs/a.md", ChunkID: 0, Content: "backups run nightly"},
+		{Repo: "kb", Path: "docs/b.md", ChunkID: 3, Content: "restores need approval"},
+	}}
+	defer func() { ragSvc = oldSvc }()
+
+	old := askFn
+	defer func() { askFn = old }()
+	var prompt string
+	askFn = func(ctx context.Context, messages []chatMessage) (string, error) {
+		prompt = messages[0].Content
+		return "Restores need approval [2].", nil
+	}
+
+	register(r)
+
+	body, _ := json.Marshal(map[string]string{"question": "how do restores work?"})
+	req := httptest.NewRequest(http.MethodPost, "/api/askai", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	authorize(t, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(prompt, "[2] kb/docs/b.md#3") || !strings.Contains(prompt, "restores need approval") {
+		t.Fatalf("sources missing from prompt: %q", prompt)
+	}
+	var resp struct {
+		Citations []citation `json:"citations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if len(resp.Citations) != 1 || resp.Citations[0].Path != "docs/b.md" || resp.Citations[0].ChunkID != 3 {
+		t.Fatalf("unexpected citations: %+v", resp.Citations)
+	}
+}
@@ -10,7 +10,7 @@ import (
 // ConfigureServiceDB configures the internal service database connection.
 func ConfigureServiceDB(db *gorm.DB) {
 	if db != nil {
-		_ = db.AutoMigrate(&model.Node{})
+		_ = db.AutoMigrate(&model.Node{}, &model.Conversation{}, &model.ConversationMessage{})
 	}
 	service.SetDB(db)
 }
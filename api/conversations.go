@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"rag-server/internal/auth"
+	"rag-server/internal/model"
+	"rag-server/internal/service"
+)
+
+// registerConversationRoutes wires the AskAI conversation history endpoints.
+// Conversations are scoped to the user returned by conversationUser.
+func registerConversationRoutes(r *gin.RouterGroup) {
+	r.GET("", listConversations)
+	r.GET("/:id", getConversation)
+	r.DELETE("/:id", deleteConversation)
+}
+
+func listConversations(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	convs, err := service.ListConversations(c.Request.Context(), userID)
+	if err != nil {
+		writeConversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": convs})
+}
+
+func getConversation(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	conv, err := service.GetConversationWithMessages(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		writeConversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, conv)
+}
+
+func deleteConversation(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	if err := service.DeleteConversation(c.Request.Context(), userID, c.Param("id")); err != nil {
+		writeConversationError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// userIDHeader names the end user an internal service acts for.
+const userIDHeader = "X-User-ID"
+
+// conversationUser returns the user that conversations belong to. Callers
+// authenticated with the service token all share auth.ServiceUserID, so they
+// name the end user in the X-User-ID header instead; without it they have no
+// conversations.
+func conversationUser(c *gin.Context) string {
+	userID := auth.GetUserID(c)
+	if userID == auth.ServiceUserID {
+		return strings.TrimSpace(c.GetHeader(userIDHeader))
+	}
+	return userID
+}
+
+func requireUserID(c *gin.Context) (string, bool) {
+	userID := conversationUser(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing user identity"})
+		return "", false
+	}
+	return userID, true
+}
+
+func writeConversationError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrConversationNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrServiceDBNotInitialized):
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// resolveConversation loads the conversation referenced by an AskAI request
+// together with its recent history, or starts a new one when create is set.
+// Without either AskAI is stateless, as it is when no service database is
+// configured. It writes an error response and returns false when the request
+// cannot proceed.
+func resolveConversation(c *gin.Context, id string, create bool, question string) (string, []model.ConversationMessage, bool) {
+	if id == "" && !create {
+		return "", nil, true
+	}
+	userID, ok := requireUserID(c)
+	if !ok {
+		return "", nil, false
+	}
+	ctx := c.Request.Context()
+	if id == "" {
+		conv, err := service.CreateConversation(ctx, userID, question)
+		if err != nil {
+			if !errors.Is(err, service.ErrServiceDBNotInitialized) {
+				slog.Warn("askai create conversation failed", "user_id", userID, "err", err)
+			}
+			return "", nil, true
+		}
+		return conv.ID, nil, true
+	}
+
+	if _, err := service.GetConversation(ctx, userID, id); err != nil {
+		writeConversationError(c, err)
+		return "", nil, false
+	}
+	history, err := service.RecentMessages(ctx, id, historyWindow())
+	if err != nil {
+		writeConversationError(c, err)
+		return "", nil, false
+	}
+	return id, history, true
+}
+
+// condenseQuestion rewrites a follow-up question into a standalone retrieval
+// query using the conversation history. It falls back to the original question
+// when the generator fails.
+func condenseQuestion(ctx context.Context, history []model.ConversationMessage, question string) string {
+	query, err := askFn(ctx, buildCondenseMessages(history, question))
+	if err != nil || query == "" {
+		if err != nil {
+			slog.Warn("askai condense question failed", "question", question, "err", err)
+		}
+		return question
+	}
+	return query
+}
+
+// withHistory inserts previous conversation messages between the grounded
+// system prompt and the current question.
+func withHistory(messages []chatMessage, history []model.ConversationMessage) []chatMessage {
+	if len(history) == 0 {
+		return messages
+	}
+	out := make([]chatMessage, 0, len(messages)+len(history))
+	out = append(out, messages[0])
+	for _, h := range history {
+		out = append(out, chatMessage{Role: h.Role, Content: h.Content})
+	}
+	return append(out, messages[1:]...)
+}
+
+// saveTurn persists the question and answer of a conversation turn. Failures
+// are logged because the answer has already been produced.
+func saveTurn(ctx context.Context, turn askTurn, answer string, cited []citation) {
+	if turn.conversationID == "" {
+		return
+	}
+	citations := make(model.MessageCitations, len(cited))
+	for i, ct := range cited {
+		citations[i] = model.MessageCitation(ct)
+	}
+	err := service.AppendMessages(ctx, turn.conversationID,
+		model.ConversationMessage{Role: "user", Content: turn.question},
+		model.ConversationMessage{Role: "assistant", Content: answer, Citations: citations},
+	)
+	if err != nil {
+		slog.Warn("askai save conversation failed", "conversation_id", turn.conversationID, "err", err)
+	}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"rag-server/internal/rag"
+	"rag-server/internal/rag/store"
+	"rag-server/internal/service"
+)
+
+// recordingRAGService remembers the retrieval queries it receives.
+type recordingRAGService struct {
+	queries []string
+}
+
+func (s *recordingRAGService) Upsert(ctx context.Context, rows []store.DocRow) (int, error) {
+	return len(rows), nil
+}
+
+func (s *recordingRAGService) Query(ctx context.Context, question string, opts rag.QueryOptions) ([]rag.Document, error) {
+	s.queries = append(s.queries, question)
+	return []rag.Document{{Repo: "kb", Path: "backup.md", Content: "use pg_dump"}}, nil
+}
+
+func (s *recordingRAGService) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	return nil, 0, nil
+}
+
+func (s *recordingRAGService) CachedEmbeddings(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error) {
+	return nil, nil
+}
+
+func (s *recordingRAGService) Reconcile(ctx context.Context, spec store.ReconcileSpec) (store.ReconcileResult, error) {
+	return store.ReconcileResult{}, nil
+}
+
+func (s *recordingRAGService) IngestedCommit(ctx context.Context, repo, pathPrefix string) (store.IngestState, error) {
+	return store.IngestState{}, nil
+}
+
+func (s *recordingRAGService) SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state store.IngestState) error {
+	return nil
+}
+
+func setupConversationTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open("file:conversations?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	ConfigureServiceDB(db)
+	t.Cleanup(func() { service.SetDB(nil) })
+
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+	register(r)
+	return r
+}
+
+func doJSON(t *testing.T, r *gin.Engine, method, path string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+	return doJSONAs(t, r, "alice", method, path, payload)
+}
+
+// doJSONAs sends payload on behalf of user, who is named in the X-User-ID
+// header unless empty.
+func doJSONAs(t *testing.T, r *gin.Engine, user, method, path string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set(userIDHeader, user)
+	}
+	authorize(t, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAskAI_Conversation(t *testing.T) {
+	r := setupConversationTestRouter(t)
+
+	oldSvc := ragSvc
+	svc := &recordingRAGService{}
+	ragSvc = svc
+	defer func() { ragSvc = oldSvc }()
+
+	old := askFn
+	defer func() { askFn = old }()
+	var calls [][]chatMessage
+	askFn = func(ctx context.Context, messages []chatMessage) (string, error) {
+		calls = append(calls, messages)
+		if messages[0].Content == condenseSystemPrompt {
+			return "how to restore a postgres backup", nil
+		}
+		return "Use pg_dump [1].", nil
+	}
+
+	w := doJSON(t, r, http.MethodPost, "/api/askai", map[string]any{"question": "how do I back up postgres?", "new_conversation": true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var first struct {
+		ConversationID string `json:"conversation_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &first); err != nil || first.ConversationID == "" {
+		t.Fatalf("expected conversation id, got %s (%v)", w.Body.String(), err)
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/askai", map[string]string{
+		"question":        "and how do I restore it?",
+		"conversation_id": first.ConversationID,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(svc.queries) != 2 || svc.queries[1] != "how to restore a postgres backup" {
+		t.Fatalf("follow-up was not condensed: %v", svc.queries)
+	}
+	last := calls[len(calls)-1]
+	if len(last) != 4 || last[1].Role != "user" || last[2].Role != "assistant" || last[3].Content != "and how do I restore it?" {
+		t.Fatalf("history not sent to generator: %+v", last)
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/askai/conversations", nil)
+	var list struct {
+		Conversations []struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"conversations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Conversations) != 1 {
+		t.Fatalf("unexpected list response %s (%v)", w.Body.String(), err)
+	}
+	if list.Conversations[0].Title != "how do I back up postgres?" {
+		t.Fatalf("unexpected title %q", list.Conversations[0].Title)
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/askai/conversations/"+first.ConversationID, nil)
+	var conv struct {
+		Messages []struct {
+			Role      string     `json:"role"`
+			Citations []citation `json:"citations"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &conv); err != nil || len(conv.Messages) != 4 {
+		t.Fatalf("unexpected conversation %s (%v)", w.Body.String(), err)
+	}
+	if len(conv.Messages[1].Citations) != 1 || conv.Messages[1].Citations[0].Path != "backup.md" {
+		t.Fatalf("citations not persisted: %+v", conv.Messages[1])
+	}
+
+	w = doJSONAs(t, r, "bob", http.MethodGet, "/api/askai/conversations/"+first.ConversationID, nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("another user got status %d for the conversation", w.Code)
+	}
+
+	w = doJSON(t, r, http.MethodDelete, "/api/askai/conversations/"+first.ConversationID, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", w.Code)
+	}
+	w = doJSON(t, r, http.MethodGet, "/api/askai/conversations/"+first.ConversationID, nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestAskAI_UnknownConversation(t *testing.T) {
+	r := setupConversationTestRouter(t)
+
+	old := askFn
+	defer func() { askFn = old }()
+	askFn = func(ctx context.Context, messages []chatMessage) (string, error) {
+		return "unused", nil
+	}
+
+	w := doJSON(t, r, http.MethodPost, "/api/askai", map[string]string{
+		"question":        "hello",
+		"conversation_id": "does-not-exist",
+	})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestAskAI_ConversationOnRequest(t *testing.T) {
+	r := setupConversationTestRouter(t)
+
+	old := askFn
+	defer func() { askFn = old }()
+	askFn = func(ctx context.Context, messages []chatMessage) (string, error) {
+		return "answer", nil
+	}
+
+	w := doJSON(t, r, http.MethodPost, "/api/askai", map[string]string{"question": "hello"})
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "conversation_id") {
+		t.Fatalf("expected a stateless answer, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, r, http.MethodGet, "/api/askai/conversations", nil)
+	if !strings.Contains(w.Body.String(), `"conversations":[]`) {
+		t.Fatalf("conversation created without being requested: %s", w.Body.String())
+	}
+
+	// The service identity is shared by every internal caller.
+	w = doJSONAs(t, r, "", http.MethodPost, "/api/askai", map[string]any{"question": "hello", "new_conversation": true})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without %s, got %d", userIDHeader, w.Code)
+	}
+	w = doJSONAs(t, r, "", http.MethodGet, "/api/askai/conversations", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without %s, got %d", userIDHeader, w.Code)
+	}
+}
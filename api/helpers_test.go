@@ -0,0 +1,16 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+const testServiceToken = "test-service-token"
+
+// authorize configures the internal service token and attaches it to req so
+// the request passes auth.InternalAuthMiddleware.
+func authorize(t *testing.T, req *http.Request) {
+	t.Helper()
+	t.Setenv("INTERNAL_SERVICE_TOKEN", testServiceToken)
+	req.Header.Set("X-Service-Token", testServiceToken)
+}
@@ -4,14 +4,14 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	rsync "rag-server/internal/rag/sync"
 	"rag-server/proxy"
 )
 
 // registerKnowledgeRoutes sets up knowledge base endpoints.
-func registerKnowledgeRoutes(r *gin.RouterGroup, _ *pgx.Conn, repoProxy string) {
+func registerKnowledgeRoutes(r *gin.RouterGroup, _ *pgxpool.Pool, repoProxy string) {
 	r.POST("/sync", func(c *gin.Context) {
 		var req struct {
 			RepoURL   string `json:"repo_url"`
@@ -22,7 +22,7 @@ func registerKnowledgeRoutes(r *gin.RouterGroup, _ *pgx.Conn, repoProxy string)
 			return
 		}
 		if err := proxy.With(repoProxy, func() error {
-			_, err := rsync.SyncRepo(c.Request.Context(), req.RepoURL, req.LocalPath)
+			_, err := rsync.SyncRepo(c.Request.Context(), req.RepoURL, req.LocalPath, rsync.Options{})
 			return err
 		}); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
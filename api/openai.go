@@ -0,0 +1,337 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag-server/internal/auth"
+	"rag-server/internal/rag"
+)
+
+// completeFn performs a non-streaming chat completion for the /v1 facade. It
+// is replaceable in tests.
+var completeFn = completeLLM
+
+// registerOpenAIRoutes wires an OpenAI-compatible facade under /v1 so that
+// off-the-shelf clients can use rag-server as a model provider. Chat
+// completions are augmented with retrieval; embeddings go through the
+// configured embedder.
+func registerOpenAIRoutes(r *gin.RouterGroup) {
+	r.Use(bearerServiceToken(), auth.InternalAuthMiddleware())
+	r.GET("/models", listOpenAIModels)
+	r.POST("/embeddings", createOpenAIEmbeddings)
+	r.POST("/chat/completions", createOpenAIChatCompletion)
+}
+
+// bearerServiceToken lets OpenAI clients, which only know how to send an
+// Authorization bearer token, authenticate with the internal service token.
+func bearerServiceToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("X-Service-Token") == "" {
+			if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+				c.Request.Header.Set("X-Service-Token", strings.TrimSpace(token))
+			}
+		}
+		c.Next()
+	}
+}
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func listOpenAIModels(c *gin.Context) {
+	data := []openAIModel{}
+	seen := map[string]struct{}{}
+	add := func(id string) {
+		if id == "" {
+			return
+		}
+		if _, ok := seen[id]; ok {
+			return
+		}
+		seen[id] = struct{}{}
+		data = append(data, openAIModel{ID: id, Object: "model", OwnedBy: "rag-server"})
+	}
+	_, model, _, _, _ := loadConfig()
+	add(model)
+	if cfg, err := readServerConfig(); err == nil {
+		for _, m := range cfg.Models.Generator.Models {
+			add(m)
+		}
+		for _, m := range cfg.Models.Embedder.Models {
+			add(m)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
+
+// openAIInput accepts either a single string or a list of strings.
+type openAIInput []string
+
+func (in *openAIInput) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*in = []string{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return errors.New("input must be a string or an array of strings")
+	}
+	*in = list
+	return nil
+}
+
+// openAIMessage is a chat message whose content may be a string or a list of
+// content parts. Only text parts are kept.
+type openAIMessage struct {
+	Role    string        `json:"role"`
+	Content openAIContent `json:"content"`
+}
+
+type openAIContent string
+
+func (oc *openAIContent) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err == nil {
+		*oc = openAIContent(text)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(b, &parts); err != nil {
+		return errors.New("content must be a string or an array of content parts")
+	}
+	var sb strings.Builder
+	for _, p := range parts {
+		if p.Type == "text" {
+			sb.WriteString(p.Text)
+		}
+	}
+	*oc = openAIContent(sb.String())
+	return nil
+}
+
+func createOpenAIEmbeddings(c *gin.Context) {
+	var req struct {
+		Model string      `json:"model"`
+		Input openAIInput `json:"input"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if len(req.Input) == 0 {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "input is required")
+		return
+	}
+	svc := getRAG()
+	if svc == nil {
+		openAIError(c, http.StatusServiceUnavailable, "server_error", rag.ErrNoEmbedder.Error())
+		return
+	}
+	vecs, tokens, err := svc.Embed(c.Request.Context(), req.Input)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, rag.ErrNoEmbedder) {
+			status = http.StatusServiceUnavailable
+		}
+		openAIError(c, status, "server_error", err.Error())
+		return
+	}
+	data := make([]gin.H, len(vecs))
+	for i, v := range vecs {
+		data[i] = gin.H{"object": "embedding", "index": i, "embedding": v}
+	}
+	model := req.Model
+	if cfg, err := readServerConfig(); err == nil && len(cfg.Models.Embedder.Models) > 0 {
+		model = cfg.Models.Embedder.Models[0]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage":  gin.H{"prompt_tokens": tokens, "total_tokens": tokens},
+	})
+}
+
+func createOpenAIChatCompletion(c *gin.Context) {
+	var req struct {
+		Model    string          `json:"model"`
+		Messages []openAIMessage `json:"messages"`
+		Stream   bool            `json:"stream"`
+		// RAG disables retrieval augmentation when explicitly set to false.
+		RAG *bool `json:"rag"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	history := make([]chatMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		history[i] = chatMessage{Role: m.Role, Content: string(m.Content)}
+	}
+	question := lastUserMessage(history)
+	if question == "" {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "messages must contain a user message")
+		return
+	}
+	model, ok := chatModel(req.Model)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": gin.H{
+			"message": fmt.Sprintf("the model %q does not exist", req.Model),
+			"type":    "invalid_request_error",
+			"code":    "model_not_found",
+		}})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Without retrieved documents the client's messages are sent as they
+	// are, so that the facade also serves plain chat.
+	messages := history
+	var sources []citation
+	if req.RAG == nil || *req.RAG {
+		var docs []rag.Document
+		if svc := getRAG(); svc != nil {
+			var err error
+			docs, err = svc.Query(ctx, question, rag.QueryOptions{})
+			if err != nil {
+				slog.Warn("chat completion retrieval failed", "question", question, "err", err)
+			}
+		}
+		if len(docs) > 0 {
+			var grounded []chatMessage
+			grounded, sources = buildGroundedMessages(question, docs)
+			messages = append([]chatMessage{grounded[0]}, history...)
+		}
+	}
+
+	id := "chatcmpl-" + randomID()
+	created := time.Now().Unix()
+
+	if req.Stream {
+		streamOpenAIChat(c, id, req.Model, model, created, messages, sources)
+		return
+	}
+
+	resp, err := completeFn(ctx, req.Model, messages)
+	if err != nil {
+		slog.Error("chat completion failed", "question", question, "err", err)
+		openAIError(c, http.StatusBadGateway, "server_error", err.Error())
+		return
+	}
+	if resp.Model != "" {
+		model = resp.Model
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []gin.H{{
+			"index":         0,
+			"message":       chatMessage{Role: "assistant", Content: resp.Content},
+			"finish_reason": "stop",
+		}},
+		"usage":     resp.Usage,
+		"citations": extractCitations(resp.Content, sources),
+	})
+}
+
+// chatModel checks a requested chat model against the configured
+// generators and returns the model to report: the requested one, or the
+// default when none was requested. Any model is accepted when no generator
+// models are configured.
+func chatModel(requested string) (string, bool) {
+	_, model, _, _, _ := loadConfig()
+	if requested == "" {
+		return model, true
+	}
+	cfg, _ := readServerConfig()
+	models := generatorModels(cfg)
+	return requested, len(models) == 0 || slices.Contains(models, requested)
+}
+
+// streamOpenAIChat forwards generator deltas as OpenAI chat.completion.chunk
+// events terminated by `data: [DONE]`. requested is tried first; the chunks
+// report model until the final one, which names the model that answered.
+func streamOpenAIChat(c *gin.Context, id, requested, model string, created int64, messages []chatMessage, sources []citation) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	write := func(v any) {
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", b)
+		c.Writer.Flush()
+	}
+	chunk := func(delta gin.H, finish any) gin.H {
+		return gin.H{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []gin.H{{"index": 0, "delta": delta, "finish_reason": finish}},
+		}
+	}
+
+	write(chunk(gin.H{"role": "assistant"}, nil))
+	var answer strings.Builder
+	resp, err := streamFn(c.Request.Context(), requested, messages, func(delta string) error {
+		answer.WriteString(delta)
+		write(chunk(gin.H{"content": delta}, nil))
+		return c.Request.Context().Err()
+	})
+	if err != nil {
+		slog.Error("chat completion stream failed", "err", err)
+		write(gin.H{"error": gin.H{"message": err.Error(), "type": "server_error"}})
+		return
+	}
+	if resp.Model != "" {
+		model = resp.Model
+	}
+	final := chunk(gin.H{}, "stop")
+	final["usage"] = resp.Usage
+	final["citations"] = extractCitations(answer.String(), sources)
+	write(final)
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+func lastUserMessage(messages []chatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" && strings.TrimSpace(messages[i].Content) != "" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+func openAIError(c *gin.Context, status int, typ, msg string) {
+	c.JSON(status, gin.H{"error": gin.H{"message": msg, "type": typ}})
+}
+
+func randomID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
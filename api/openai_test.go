@@ -0,0 +1,294 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"rag-server/internal/rag/generate"
+	"rag-server/internal/rag/store"
+)
+
+func setupOpenAITestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfgPath := filepath.Join(t.TempDir(), "server.yaml")
+	data := []byte("models:\n  embedder:\n    models: [\"embed-m\"]\n  generator:\n    models: [\"gen-a\", \"gen-b\"]\n    endpoint: http://127.0.0.1:1/v1/chat/completions\n")
+	if err := os.WriteFile(cfgPath, data, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	old := ConfigPath
+	ConfigPath = cfgPath
+	t.Cleanup(func() { ConfigPath = old })
+
+	oldSvc := ragSvc
+	ragSvc = &mockRAGService{dim: 3, docs: []store.DocRow{
+		{Repo: "kb", Path: "deploy.md", ChunkID: 2, Content: "deploy with make gcp-deploy"},
+	}}
+	t.Cleanup(func() { ragSvc = oldSvc })
+
+	r := gin.New()
+	RegisterRoutes(nil, "")(r)
+	return r
+}
+
+func doOpenAI(t *testing.T, r *gin.Engine, method, path string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	t.Setenv("INTERNAL_SERVICE_TOKEN", testServiceToken)
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testServiceToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestOpenAIModels(t *testing.T) {
+	r := setupOpenAITestRouter(t)
+	w := doOpenAI(t, r, http.MethodGet, "/v1/models", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data []openAIModel `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	var ids []string
+	for _, m := range resp.Data {
+		ids = append(ids, m.ID)
+	}
+	if strings.Join(ids, ",") != "gen-a,gen-b,embed-m" {
+		t.Fatalf("unexpected models %v", ids)
+	}
+}
+
+func TestOpenAIEmbeddings(t *testing.T) {
+	r := setupOpenAITestRouter(t)
+	w := doOpenAI(t, r, http.MethodPost, "/v1/embeddings", map[string]any{"model": "x", "input": []string{"a", "bb"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(resp.Data) != 2 || resp.Data[1].Index != 1 || len(resp.Data[1].Embedding) != 3 || resp.Data[1].Embedding[0] != 2 {
+		t.Fatalf("unexpected embeddings %+v", resp.Data)
+	}
+	if resp.Model != "embed-m" {
+		t.Fatalf("unexpected model %q", resp.Model)
+	}
+
+	w = doOpenAI(t, r, http.MethodPost, "/v1/embeddings", map[string]any{"input": "single"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for string input, got %d", w.Code)
+	}
+}
+
+func TestOpenAIChatCompletion(t *testing.T) {
+	r := setupOpenAITestRouter(t)
+
+	old := completeFn
+	defer func() { completeFn = old }()
+	var sent []chatMessage
+	completeFn = func(ctx context.Context, model string, messages []chatMessage) (generate.Response, error) {
+		sent = messages
+		return generate.Response{Content: "Run make gcp-deploy [1].", Model: "gen-b", Usage: chatUsage{PromptTokens: 40, CompletionTokens: 8, TotalTokens: 48}}, nil
+	}
+
+	w := doOpenAI(t, r, http.MethodPost, "/v1/chat/completions", map[string]any{
+		"model": "gen-a",
+		"messages": []map[string]any{
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": []map[string]string{{"type": "text", "text": "how to deploy?"}}},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(sent) != 3 || !strings.Contains(sent[0].Content, "[1] kb/deploy.md#2") || sent[1].Content != "be brief" || sent[2].Content != "how to deploy?" {
+		t.Fatalf("unexpected generator messages %+v", sent)
+	}
+	var resp struct {
+		Object  string `json:"object"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+		Usage     chatUsage  `json:"usage"`
+		Citations []citation `json:"citations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Object != "chat.completion" || len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "Run make gcp-deploy [1]." {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+	if resp.Model != "gen-b" || resp.Usage.TotalTokens != 48 || resp.Usage.PromptTokens != 40 {
+		t.Fatalf("unexpected model %q or usage %+v", resp.Model, resp.Usage)
+	}
+	if len(resp.Citations) != 1 || resp.Citations[0].Path != "deploy.md" {
+		t.Fatalf("unexpected citations %+v", resp.Citations)
+	}
+
+	w = doOpenAI(t, r, http.MethodPost, "/v1/chat/completions", map[string]any{
+		"model":    "gpt-unknown",
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "model_not_found") {
+		t.Fatalf("expected model_not_found, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOpenAIChatCompletionWithoutDocuments(t *testing.T) {
+	r := setupOpenAITestRouter(t)
+	oldSvc := ragSvc
+	ragSvc = &mockRAGService{}
+	defer func() { ragSvc = oldSvc }()
+
+	old := completeFn
+	defer func() { completeFn = old }()
+	var sent []chatMessage
+	completeFn = func(ctx context.Context, model string, messages []chatMessage) (generate.Response, error) {
+		sent = messages
+		return generate.Response{Content: "Hello!"}, nil
+	}
+
+	w := doOpenAI(t, r, http.MethodPost, "/v1/chat/completions", map[string]any{
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(sent) != 1 || sent[0].Role != "user" || sent[0].Content != "hi" {
+		t.Fatalf("messages were not forwarded unchanged: %+v", sent)
+	}
+	if !strings.Contains(w.Body.String(), `"model":"gen-a"`) {
+		t.Fatalf("default model not reported: %s", w.Body.String())
+	}
+}
+
+func TestOpenAIChatCompletionStream(t *testing.T) {
+	r := setupOpenAITestRouter(t)
+
+	old := streamFn
+	defer func() { streamFn = old }()
+	streamFn = func(ctx context.Context, model string, messages []chatMessage, onDelta func(string) error) (generate.Response, error) {
+		_ = onDelta("Hel")
+		_ = onDelta("lo")
+		return generate.Response{Usage: chatUsage{TotalTokens: 7}}, nil
+	}
+
+	w := doOpenAI(t, r, http.MethodPost, "/v1/chat/completions", map[string]any{
+		"stream":   true,
+		"rag":      false,
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var content strings.Builder
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if lines[len(lines)-1] != "data: [DONE]" {
+		t.Fatalf("stream not terminated: %q", w.Body.String())
+	}
+	for _, line := range lines[:len(lines)-1] {
+		var ev struct {
+			Object  string `json:"object"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		if ev.Object != "chat.completion.chunk" {
+			t.Fatalf("unexpected object %q", ev.Object)
+		}
+		content.WriteString(ev.Choices[0].Delta.Content)
+	}
+	if content.String() != "Hello" {
+		t.Fatalf("unexpected streamed content %q", content.String())
+	}
+}
+
+func TestOpenAIChatCompletionRequestedModel(t *testing.T) {
+	var models []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		fmt.Fprintf(w, `{"model":%q,"choices":[{"message":{"content":"hi"}}]}`, req.Model)
+	}))
+	defer srv.Close()
+	r := setupOpenAITestRouter(t)
+	cfgPath := filepath.Join(t.TempDir(), "server.yaml")
+	data := []byte("models:\n  generator:\n    models: [\"gen-a\", \"gen-b\"]\n    endpoint: " + srv.URL + "\n")
+	if err := os.WriteFile(cfgPath, data, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	ConfigPath = cfgPath
+
+	for _, stream := range []bool{false, true} {
+		w := doOpenAI(t, r, http.MethodPost, "/v1/chat/completions", map[string]any{
+			"model":    "gen-b",
+			"stream":   stream,
+			"rag":      false,
+			"messages": []map[string]string{{"role": "user", "content": "hi"}},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), `"model":"gen-b"`) || strings.Contains(w.Body.String(), `"model":"gen-a"`) {
+			t.Fatalf("unexpected model in response %s", w.Body.String())
+		}
+	}
+	if len(models) != 2 || models[0] != "gen-b" || models[1] != "gen-b" {
+		t.Fatalf("generator asked for %q, want gen-b", models)
+	}
+}
+
+func TestOpenAIUnauthorized(t *testing.T) {
+	r := setupOpenAITestRouter(t)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", testServiceToken)
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", w.Code)
+	}
+}
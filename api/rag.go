@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"rag-server/internal/rag"
 	rconfig "rag-server/internal/rag/config"
@@ -20,16 +21,29 @@ import (
 // service.
 type ragService interface {
 	Upsert(ctx context.Context, rows []store.DocRow) (int, error)
-	Query(ctx context.Context, question string, limit int) ([]rag.Document, error)
+	Query(ctx context.Context, question string, opts rag.QueryOptions) ([]rag.Document, error)
+	Embed(ctx context.Context, inputs []string) ([][]float32, int, error)
+	CachedEmbeddings(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error)
+	Reconcile(ctx context.Context, spec store.ReconcileSpec) (store.ReconcileResult, error)
+	IngestedCommit(ctx context.Context, repo, pathPrefix string) (store.IngestState, error)
+	SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state store.IngestState) error
 }
 
 // ragSvc handles RAG document storage and retrieval. It is initialized lazily
-// on demand. ragMu guards concurrent initialization attempts.
+// on demand. ragMu guards concurrent initialization attempts and ragPool.
 var (
-	ragSvc ragService
-	ragMu  sync.Mutex
+	ragSvc  ragService
+	ragMu   sync.Mutex
+	ragPool *pgxpool.Pool
 )
 
+// setRAGPool sets the connection pool used by the RAG service created next.
+func setRAGPool(pool *pgxpool.Pool) {
+	ragMu.Lock()
+	defer ragMu.Unlock()
+	ragPool = pool
+}
+
 // initRAG attempts to construct a RAG service from server configuration.
 func initRAG() ragService {
 	cfg, err := rconfig.LoadServer()
@@ -37,6 +51,9 @@ func initRAG() ragService {
 		return nil
 	}
 	proxy.Set(cfg.Proxy)
+	if ragPool != nil {
+		return rag.NewWithPool(cfg.ToConfig(), ragPool)
+	}
 	return rag.New(cfg.ToConfig())
 }
 
@@ -50,7 +67,8 @@ func getRAG() ragService {
 	return ragSvc
 }
 
-// registerRAGRoutes wires the /api/rag upsert and query endpoints.
+// registerRAGRoutes wires the /api/rag upsert, reconcile, ingest commit,
+// embedding cache and query endpoints.
 func registerRAGRoutes(r *gin.RouterGroup) {
 	r.POST("/rag/upsert", func(c *gin.Context) {
 		svc := getRAG()
@@ -73,9 +91,116 @@ func registerRAGRoutes(r *gin.RouterGroup) {
 		c.JSON(http.StatusOK, gin.H{"rows": n})
 	})
 
+	r.POST("/rag/reconcile", func(c *gin.Context) {
+		var spec store.ReconcileSpec
+		if err := c.BindJSON(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if spec.Repo == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "repo is required"})
+			return
+		}
+		svc := getRAG()
+		if svc == nil {
+			c.JSON(http.StatusOK, store.ReconcileResult{})
+			return
+		}
+		res, err := svc.Reconcile(c.Request.Context(), spec)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/rag/ingest/commit", func(c *gin.Context) {
+		repo := c.Query("repo")
+		if repo == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "repo is required"})
+			return
+		}
+		svc := getRAG()
+		if svc == nil {
+			c.JSON(http.StatusOK, store.IngestState{})
+			return
+		}
+		state, err := svc.IngestedCommit(c.Request.Context(), repo, c.Query("path_prefix"))
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, state)
+	})
+
+	r.PUT("/rag/ingest/commit", func(c *gin.Context) {
+		var req struct {
+			Repo       string `json:"repo"`
+			PathPrefix string `json:"path_prefix"`
+			store.IngestState
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Repo == "" || req.Commit == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "repo and commit are required"})
+			return
+		}
+		svc := getRAG()
+		if svc == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "rag service unavailable"})
+			return
+		}
+		if err := svc.SetIngestedCommit(c.Request.Context(), req.Repo, req.PathPrefix, req.IngestState); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, req.IngestState)
+	})
+
+	r.POST("/rag/embeddings/cached", func(c *gin.Context) {
+		var req struct {
+			Model string               `json:"model"`
+			Keys  []store.EmbeddingKey `json:"keys"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		svc := getRAG()
+		if svc == nil {
+			c.JSON(http.StatusOK, gin.H{"embeddings": gin.H{}})
+			return
+		}
+		vecs, err := svc.CachedEmbeddings(c.Request.Context(), req.Model, req.Keys)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if vecs == nil {
+			vecs = map[string][]float32{}
+		}
+		c.JSON(http.StatusOK, gin.H{"embeddings": vecs})
+	})
+
 	r.POST("/rag/query", func(c *gin.Context) {
 		var req struct {
-			Question string `json:"question"`
+			Question   string        `json:"question"`
+			K          int           `json:"k"`
+			Alpha      *float64      `json:"alpha"`
+			Candidates int           `json:"candidates"`
+			Rerank     *bool         `json:"rerank"`
+			RepoPrefix string        `json:"repo_prefix"`
+			PathPrefix string        `json:"path_prefix"`
+			Filter     *store.Filter `json:"filter"`
+			MMR        *bool         `json:"mmr"`
+			MMRLambda  *float64      `json:"mmr_lambda"`
+			MaxPerPath int           `json:"max_per_path"`
+			Expand     string        `json:"expand"`
+			ExpandMax  int           `json:"expand_tokens"`
+			QueryMode  string        `json:"query_mode"`
+			Debug      bool          `json:"debug"`
 		}
 		if err := c.BindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -86,16 +211,42 @@ func registerRAGRoutes(r *gin.RouterGroup) {
 			c.JSON(http.StatusOK, gin.H{"chunks": nil})
 			return
 		}
-		docs, err := svc.Query(c.Request.Context(), req.Question, 5)
+		opts := rag.QueryOptions{
+			Limit:        req.K,
+			Alpha:        req.Alpha,
+			Candidates:   req.Candidates,
+			Rerank:       req.Rerank,
+			RepoPrefix:   req.RepoPrefix,
+			PathPrefix:   req.PathPrefix,
+			Filter:       req.Filter,
+			MMR:          req.MMR,
+			MMRLambda:    req.MMRLambda,
+			MaxPerPath:   req.MaxPerPath,
+			Expand:       req.Expand,
+			ExpandTokens: req.ExpandMax,
+			QueryMode:    req.QueryMode,
+		}
+		if req.Debug {
+			opts.Trace = &rag.Trace{}
+		}
+		docs, err := svc.Query(c.Request.Context(), req.Question, opts)
 		if err != nil {
 			var httpErr *ragembed.HTTPError
-			if errors.As(err, &httpErr) {
+			if errors.Is(err, rag.ErrInvalidQuery) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else if errors.Is(err, rag.ErrNoEmbedder) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			} else if errors.As(err, &httpErr) {
 				c.JSON(httpErr.Code, gin.H{"error": httpErr.Error()})
 			} else {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			}
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"chunks": docs})
+		resp := gin.H{"chunks": docs}
+		if opts.Trace != nil {
+			resp["debug"] = opts.Trace
+		}
+		c.JSON(http.StatusOK, resp)
 	})
 }
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -16,8 +17,10 @@ import (
 )
 
 type mockRAGService struct {
-	dim  int
-	docs []store.DocRow
+	dim     int
+	docs    []store.DocRow
+	opts    rag.QueryOptions
+	commits map[string]store.IngestState
 }
 
 func (m *mockRAGService) Upsert(ctx context.Context, rows []store.DocRow) (int, error) {
@@ -30,7 +33,15 @@ func (m *mockRAGService) Upsert(ctx context.Context, rows []store.DocRow) (int,
 	return len(rows), nil
 }
 
-func (m *mockRAGService) Query(ctx context.Context, question string, limit int) ([]rag.Document, error) {
+func (m *mockRAGService) Query(ctx context.Context, question string, opts rag.QueryOptions) ([]rag.Document, error) {
+	m.opts = opts
+	if opts.Trace != nil {
+		opts.Trace.Alpha = 0.5
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = rag.DefaultLimit
+	}
 	docs := make([]rag.Document, len(m.docs))
 	for i, d := range m.docs {
 		docs[i] = rag.Document{
@@ -47,6 +58,59 @@ func (m *mockRAGService) Query(ctx context.Context, question string, limit int)
 	return docs, nil
 }
 
+func (m *mockRAGService) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	vecs := make([][]float32, len(inputs))
+	for i, in := range inputs {
+		vecs[i] = make([]float32, m.dim)
+		if m.dim > 0 {
+			vecs[i][0] = float32(len(in))
+		}
+	}
+	return vecs, len(inputs), nil
+}
+
+func (m *mockRAGService) Reconcile(ctx context.Context, spec store.ReconcileSpec) (store.ReconcileResult, error) {
+	keep := map[string]bool{}
+	for _, k := range spec.Keep {
+		keep[k] = true
+	}
+	var res store.ReconcileResult
+	kept := m.docs[:0]
+	for _, d := range m.docs {
+		if d.Repo == spec.Repo && spec.InScope(d.Path) && !keep[store.DocKey(d.Repo, d.Path, d.ChunkID)] {
+			res.Rows++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	m.docs = kept
+	return res, nil
+}
+
+func (m *mockRAGService) IngestedCommit(ctx context.Context, repo, pathPrefix string) (store.IngestState, error) {
+	return m.commits[repo+"|"+pathPrefix], nil
+}
+
+func (m *mockRAGService) SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state store.IngestState) error {
+	if m.commits == nil {
+		m.commits = map[string]store.IngestState{}
+	}
+	m.commits[repo+"|"+pathPrefix] = state
+	return nil
+}
+
+func (m *mockRAGService) CachedEmbeddings(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error) {
+	out := map[string][]float32{}
+	for _, k := range keys {
+		for _, d := range m.docs {
+			if d.EmbeddingModel == model && d.ContentSHA == k.ContentSHA {
+				out[k.ContentSHA] = d.Embedding
+			}
+		}
+	}
+	return out, nil
+}
+
 // TestRAGUpsertAndQuery verifies that a 1024-dimensional vector can be stored
 // and retrieved through the RAG API.
 func TestRAGUpsertAndQuery(t *testing.T) {
@@ -124,3 +188,199 @@ func TestRAGUpsert_DimensionMismatch(t *testing.T) {
 		t.Fatalf("expected status 503, got %d", w.Code)
 	}
 }
+
+// TestRAGQuery_Options verifies retrieval parameters are passed down to the
+// service.
+func TestRAGQuery_Options(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+
+	old := ragSvc
+	mock := &mockRAGService{dim: 2}
+	ragSvc = mock
+	defer func() { ragSvc = old }()
+
+	register(r)
+
+	body := []byte(`{"question":"q","k":8,"alpha":0.2,"candidates":100,"rerank":false,"repo_prefix":"kb","path_prefix":"docs/","mmr":true,"mmr_lambda":0.6,"max_per_path":2,"query_mode":"hyde"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/rag/query", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	authorize(t, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	o := mock.opts
+	if o.Limit != 8 || o.Alpha == nil || *o.Alpha != 0.2 || o.Candidates != 100 ||
+		o.Rerank == nil || *o.Rerank || o.RepoPrefix != "kb" || o.PathPrefix != "docs/" ||
+		o.MMR == nil || !*o.MMR || o.MMRLambda == nil || *o.MMRLambda != 0.6 || o.MaxPerPath != 2 ||
+		o.QueryMode != "hyde" {
+		t.Fatalf("unexpected options %+v", o)
+	}
+}
+
+// TestRAGQuery_Debug verifies the query trace is returned only on request.
+func TestRAGQuery_Debug(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+
+	old := ragSvc
+	ragSvc = &mockRAGService{dim: 2}
+	defer func() { ragSvc = old }()
+
+	register(r)
+
+	for _, debug := range []bool{false, true} {
+		body, _ := json.Marshal(map[string]any{"question": "q", "debug": debug})
+		req := httptest.NewRequest(http.MethodPost, "/api/rag/query", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		authorize(t, req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		var resp struct {
+			Debug *rag.Trace `json:"debug"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if debug && (resp.Debug == nil || resp.Debug.Alpha != 0.5) {
+			t.Fatalf("expected trace, got %s", w.Body.String())
+		}
+		if !debug && resp.Debug != nil {
+			t.Fatalf("unexpected trace %s", w.Body.String())
+		}
+	}
+}
+
+// TestRAGCachedEmbeddings verifies that embeddings stored through upsert can
+// be looked up by content SHA and model.
+func TestRAGCachedEmbeddings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+
+	old := ragSvc
+	ragSvc = &mockRAGService{dim: 2, docs: []store.DocRow{
+		{Repo: "repo", Path: "a.md", ChunkID: 0, ContentSHA: "sha1", Embedding: []float32{1, 2}, EmbeddingModel: "ollama:bge-m3"},
+	}}
+	defer func() { ragSvc = old }()
+
+	register(r)
+
+	body, _ := json.Marshal(map[string]any{
+		"model": "ollama:bge-m3",
+		"keys": []store.EmbeddingKey{
+			{DocKey: "other:b.md:3", ContentSHA: "sha1"},
+			{DocKey: "repo:a.md:1", ContentSHA: "sha2"},
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/rag/embeddings/cached", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	authorize(t, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Embeddings map[string][]float32 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if len(resp.Embeddings) != 1 || len(resp.Embeddings["sha1"]) != 2 {
+		t.Fatalf("unexpected embeddings: %+v", resp.Embeddings)
+	}
+}
+
+// TestRAGReconcile verifies that rows missing from the keep list are deleted.
+func TestRAGReconcile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+
+	old := ragSvc
+	mock := &mockRAGService{dim: 2, docs: []store.DocRow{
+		{Repo: "repo", Path: "docs/a.md", ChunkID: 0},
+		{Repo: "repo", Path: "docs/a.md", ChunkID: 1},
+		{Repo: "repo", Path: "README.md", ChunkID: 0},
+	}}
+	ragSvc = mock
+	defer func() { ragSvc = old }()
+
+	register(r)
+
+	post := func(body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/rag/reconcile", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		authorize(t, req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := post(map[string]any{"keep": []string{}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without repo, got %d", w.Code)
+	}
+	w := post(store.ReconcileSpec{Repo: "repo", PathPrefix: "docs", Keep: []string{"repo:docs/a.md:0"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res store.ReconcileResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if res.Rows != 1 || len(mock.docs) != 2 {
+		t.Fatalf("unexpected result %+v, remaining %+v", res, mock.docs)
+	}
+}
+
+// TestRAGIngestCommit verifies that the ingested commit of a datasource can
+// be recorded and read back.
+func TestRAGIngestCommit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	register := RegisterRoutes(nil, "")
+
+	old := ragSvc
+	ragSvc = &mockRAGService{dim: 2}
+	defer func() { ragSvc = old }()
+
+	register(r)
+
+	do := func(method, target string, body any) *httptest.ResponseRecorder {
+		var rd io.Reader
+		if body != nil {
+			b, _ := json.Marshal(body)
+			rd = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, target, rd)
+		req.Header.Set("Content-Type", "application/json")
+		authorize(t, req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := do(http.MethodPut, "/api/rag/ingest/commit", map[string]string{"repo": "repo"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without commit, got %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/api/rag/ingest/commit", map[string]string{"repo": "repo", "path_prefix": "docs", "commit": "abc", "config": "f00"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for target, want := range map[string]store.IngestState{
+		"/api/rag/ingest/commit?repo=repo&path_prefix=docs": {Commit: "abc", Config: "f00"},
+		"/api/rag/ingest/commit?repo=repo":                  {},
+	} {
+		w := do(http.MethodGet, target, nil)
+		var resp store.IngestState
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp != want {
+			t.Fatalf("GET %s = %d %s, want %+v", target, w.Code, w.Body.String(), want)
+		}
+	}
+}
@@ -5,13 +5,17 @@ import (
 	"rag-server/internal/auth"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // RegisterRoutes returns a server.Registrar that registers all API routes.
-// It wires user, node, and knowledge base handlers under /api.
-func RegisterRoutes(conn *pgx.Conn, repoProxy string) server.Registrar {
+// It wires user, node, and knowledge base handlers under /api and the
+// OpenAI-compatible facade under /v1. pool, when not nil, is shared with the
+// RAG service.
+func RegisterRoutes(pool *pgxpool.Pool, repoProxy string) server.Registrar {
 	return func(r *gin.Engine) {
+		setRAGPool(pool)
+
 		api := r.Group("/api")
 
 		// Apply internal service authentication
@@ -19,9 +23,11 @@ func RegisterRoutes(conn *pgx.Conn, repoProxy string) server.Registrar {
 
 		registerUserRoutes(api)
 		registerNodeRoutes(api.Group("/agent"))
-		registerKnowledgeRoutes(api, conn, repoProxy)
+		registerKnowledgeRoutes(api, pool, repoProxy)
 		registerRAGRoutes(api)
 		registerAskAIRoutes(api)
 		registerAdminSettingRoutes(api)
+
+		registerOpenAIRoutes(r.Group("/v1"))
 	}
 }
@@ -18,6 +18,7 @@ func main() {
 	maxFiles := flag.Int("max-files", 0, "limit number of files")
 	migrateDim := flag.Bool("migrate-dim", false, "auto migrate embedding dimension")
 	concurrency := flag.Int("concurrency", runtime.NumCPU()*2, "concurrent workers")
+	full := flag.Bool("full", false, "ingest every file instead of the files changed since the last ingested commit")
 	flag.Parse()
 
 	cfg, err := cfgpkg.Load(*configPath)
@@ -27,16 +28,20 @@ func main() {
 	proxy.Set(cfg.Global.Proxy)
 
 	ctx := context.Background()
-	opt := ingest.Options{MaxFiles: *maxFiles, DryRun: *dryRun, MigrateDim: *migrateDim, Concurrency: *concurrency}
-
 	for _, ds := range cfg.Global.Datasources {
 		if *onlyRepo != "" && ds.Name != *onlyRepo {
 			continue
 		}
+		opt := ingest.Options{MaxFiles: *maxFiles, DryRun: *dryRun, MigrateDim: *migrateDim, Full: *full, Concurrency: *concurrency, Progress: ingest.LogProgress(ds.Name)}
 		st, err := ingest.IngestRepo(ctx, cfg, ds, opt)
 		if err != nil {
 			log.Printf("ingest %s error: %v", ds.Name, err)
 		}
-		log.Printf("%s: files_scanned=%d chunks_built=%d embeddings_created=%d rows_upserted=%d elapsed=%s", ds.Name, st.FilesScanned, st.ChunksBuilt, st.EmbeddingsCreated, st.RowsUpserted, st.Elapsed)
+		for _, e := range st.Errors {
+			if e != err {
+				log.Printf("ingest %s: %v", ds.Name, e)
+			}
+		}
+		log.Printf("%s: incremental=%t commit=%s files_scanned=%d chunks_built=%d embeddings_created=%d cache_hits=%d rows_upserted=%d rows_deleted=%d files_deleted=%d rate_limit_wait=%s elapsed=%s", ds.Name, st.Incremental, st.Commit, st.FilesScanned, st.ChunksBuilt, st.EmbeddingsCreated, st.CacheHits, st.RowsUpserted, st.RowsDeleted, st.FilesDeleted, st.RateLimitWait, st.Elapsed)
 	}
 }
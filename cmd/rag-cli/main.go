@@ -8,8 +8,10 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -26,9 +28,11 @@ import (
 )
 
 var (
-	configPath string
-	filePath   string
-	logLevel   string
+	configPath  string
+	filePath    string
+	logLevel    string
+	concurrency int
+	full        bool
 )
 
 var rootCmd = &cobra.Command{
@@ -67,18 +71,10 @@ var rootCmd = &cobra.Command{
 		embCfg := cfg.ResolveEmbedding()
 		chunkCfg := cfg.ResolveChunking()
 
-		var embedder embed.Embedder
-		switch embCfg.Provider {
-		case "ollama":
-			embedder = embed.NewOllama(embCfg.Endpoint, embCfg.Model, embCfg.Dimension)
-		case "chutes":
-			embedder = embed.NewChutes(embCfg.Endpoint, embCfg.APIKey, embCfg.Dimension)
-		default:
-			if embCfg.Model != "" {
-				embedder = embed.NewOpenAI(embCfg.Endpoint, embCfg.APIKey, embCfg.Model, embCfg.Dimension)
-			} else {
-				embedder = embed.NewBGE(embCfg.Endpoint, embCfg.APIKey, embCfg.Dimension)
-			}
+		embedder, err := embed.New(embCfg)
+		if err != nil {
+			slog.Error("create embedder", "err", err)
+			os.Exit(1)
 		}
 
 		baseURL := strings.TrimRight(os.Getenv("SERVER_URL"), "/")
@@ -93,38 +89,77 @@ var rootCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 		defer cancel()
 
+		pipeline := ingest.Pipeline{
+			Chunking:         chunkCfg,
+			Embedder:         embedder,
+			Model:            embed.ModelID(embCfg),
+			Lookup:           cachedEmbeddings(baseURL),
+			Upsert:           upsertRows(baseURL),
+			MaxBatch:         embCfg.MaxBatch,
+			Concurrency:      concurrency,
+			EmbedConcurrency: embCfg.Concurrency,
+		}
 		if filePath != "" {
-			if err := ingestFile(ctx, cfg, chunkCfg, embedder, baseURL, filePath); err != nil {
+			ds, workdir := datasourceFor(cfg, filePath)
+			if ds == nil {
+				slog.Error("ingest file", "err", fmt.Errorf("file %s not under any datasource", filePath))
+				os.Exit(1)
+			}
+			pipeline.Repo, pipeline.Root = ds.Repo, workdir
+			st, err := pipeline.Run(ctx, []string{filePath})
+			if err == nil && len(st.Errors) > 0 {
+				err = st.Errors[0]
+			}
+			if err != nil {
 				slog.Error("ingest file", "err", err)
 				os.Exit(1)
 			}
+			slog.Info("ingested chunks", "count", st.RowsUpserted, "cache_hits", st.CacheHits, "file", filePath)
 			return
 		}
 
+		var total ingest.Stats
 		var syncErrs []string
 		for _, ds := range cfg.Global.Datasources {
 			workdir := filepath.Join(os.TempDir(), "xcontrol", ds.Name)
-			err := proxy.With(cfg.Sync.Repo.Proxy, func() error {
-				_, err := rsync.SyncRepo(ctx, ds.Repo, workdir)
-				return err
-			})
+			syncOpt, err := rsync.OptionsFor(ds)
+			var head string
+			if err == nil {
+				err = proxy.With(cfg.Sync.Repo.Proxy, func() error {
+					var err error
+					head, err = rsync.SyncRepo(ctx, ds.Repo, workdir, syncOpt)
+					return err
+				})
+			}
 			if err != nil {
 				slog.Warn("sync repo", "repo", ds.Name, "err", err)
 				syncErrs = append(syncErrs, ds.Name)
 				continue
 			}
-			root := filepath.Join(workdir, ds.Path)
-			files, err := ingest.ListMarkdown(root, chunkCfg.IncludeExts, chunkCfg.IgnoreDirs, 0)
+			pipeline.Progress = ingest.LogProgress(ds.Name)
+			pipeline.Reconcile = reconcileRows(baseURL)
+			src := ingest.Source{DataSource: ds, Workdir: workdir, Head: head}
+			st, plan, err := pipeline.RunSource(ctx, src, apiCommits{baseURL: baseURL}, ingest.SourceOptions{Full: full})
+			if plan.Fallback != nil {
+				slog.Info("cannot ingest incrementally, ingesting every file", "repo", ds.Name, "reason", plan.Fallback)
+			}
+			for _, e := range st.Errors {
+				slog.Warn("ingest file", "err", e)
+			}
 			if err != nil {
-				slog.Error("list markdown", "err", err)
+				slog.Error("ingest", "repo", ds.Name, "err", err)
 				os.Exit(1)
 			}
-			for _, f := range files {
-				if err := ingestFile(ctx, cfg, chunkCfg, embedder, baseURL, f); err != nil {
-					slog.Warn("ingest file", "file", f, "err", err)
-				}
-			}
+			slog.Info("ingested repository", "repo", ds.Name, "incremental", st.Incremental, "files", st.FilesScanned, "rows_deleted", st.RowsDeleted, "commit", st.Commit)
+			total.FilesScanned += st.FilesScanned
+			total.EmbeddingsCreated += st.EmbeddingsCreated
+			total.CacheHits += st.CacheHits
+			total.RowsUpserted += st.RowsUpserted
+			total.RowsDeleted += st.RowsDeleted
+			total.FilesDeleted += st.FilesDeleted
+			total.Errors = append(total.Errors, st.Errors...)
 		}
+		slog.Info("ingest finished", "files", total.FilesScanned, "failed_files", len(total.Errors), "embeddings_created", total.EmbeddingsCreated, "cache_hits", total.CacheHits, "rows_upserted", total.RowsUpserted, "rows_deleted", total.RowsDeleted, "files_deleted", total.FilesDeleted)
 		if len(syncErrs) > 0 {
 			slog.Error("failed to sync repositories", "repos", strings.Join(syncErrs, ", "))
 			os.Exit(1)
@@ -136,6 +171,8 @@ func init() {
 	rootCmd.Flags().StringVar(&configPath, "config", "", "Path to server RAG configuration file")
 	rootCmd.Flags().StringVar(&filePath, "file", "", "Markdown file to embed and upsert")
 	rootCmd.Flags().StringVar(&logLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	rootCmd.Flags().IntVar(&concurrency, "concurrency", runtime.NumCPU(), "files parsed and chunked in parallel")
+	rootCmd.Flags().BoolVar(&full, "full", false, "ingest every file instead of the files changed since the last ingested commit")
 }
 
 func main() {
@@ -144,82 +181,179 @@ func main() {
 	}
 }
 
-func ingestFile(ctx context.Context, cfg *rconfig.Config, chunkCfg rconfig.ChunkingCfg, embedder embed.Embedder, baseURL, filePath string) error {
-	var ds *rconfig.DataSource
-	var workdir string
+// datasourceFor returns the datasource whose checkout contains file and the
+// checkout directory.
+func datasourceFor(cfg *rconfig.Config, file string) (*rconfig.DataSource, string) {
 	for i := range cfg.Global.Datasources {
 		wd := filepath.Join(os.TempDir(), "xcontrol", cfg.Global.Datasources[i].Name)
-		if strings.HasPrefix(filePath, wd) {
-			ds = &cfg.Global.Datasources[i]
-			workdir = wd
-			break
+		if strings.HasPrefix(file, wd) {
+			return &cfg.Global.Datasources[i], wd
 		}
 	}
-	if ds == nil {
-		return fmt.Errorf("file %s not under any datasource", filePath)
-	}
+	return nil, ""
+}
 
-	secs, err := ingest.ParseMarkdown(filePath)
-	if err != nil {
-		return fmt.Errorf("parse markdown: %w", err)
+// newAPIRequest builds a request to the server API authenticated with the
+// internal service token from INTERNAL_SERVICE_TOKEN. A non-nil body is sent
+// as JSON.
+func newAPIRequest(ctx context.Context, method, endpoint string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
 	}
-	chunks, err := ingest.BuildChunks(secs, chunkCfg)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, r)
 	if err != nil {
-		return fmt.Errorf("build chunks: %w", err)
+		return nil, err
 	}
-	texts := make([]string, len(chunks))
-	rows := make([]store.DocRow, len(chunks))
-	rel := strings.TrimPrefix(filePath, workdir+"/")
-	for i, ch := range chunks {
-		texts[i] = ch.Text
-		rows[i] = store.DocRow{
-			Repo:       ds.Repo,
-			Path:       rel,
-			ChunkID:    ch.ChunkID,
-			Content:    ch.Text,
-			Metadata:   ch.Meta,
-			ContentSHA: ch.SHA256,
-		}
-	}
-	vecs, _, err := embedder.Embed(ctx, texts)
-	if err != nil {
-		return fmt.Errorf("embed %s: %w", filePath, err)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	for i := range rows {
-		rows[i].Embedding = vecs[i]
+	if token := os.Getenv("INTERNAL_SERVICE_TOKEN"); token != "" {
+		req.Header.Set("X-Service-Token", token)
 	}
-	payload := struct {
-		Docs []store.DocRow `json:"docs"`
-	}{Docs: rows}
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("marshal docs: %w", err)
+	return req, nil
+}
+
+// upsertRows stores rows through the server API, retrying failed requests.
+func upsertRows(baseURL string) ingest.UpsertFunc {
+	return func(ctx context.Context, rows []store.DocRow) (int, error) {
+		payload := struct {
+			Docs []store.DocRow `json:"docs"`
+		}{Docs: rows}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return 0, fmt.Errorf("marshal docs: %w", err)
+		}
+		var resp *http.Response
+		var req *http.Request
+		for i := 0; i < 3; i++ {
+			req, err = newAPIRequest(ctx, http.MethodPost, baseURL+"/api/rag/upsert", b)
+			if err != nil {
+				return 0, fmt.Errorf("create request: %w", err)
+			}
+			resp, err = http.DefaultClient.Do(req)
+			if err == nil {
+				break
+			}
+			time.Sleep(time.Second * time.Duration(i+1))
+		}
+		if err != nil {
+			return 0, fmt.Errorf("upsert request: %w", err)
+		}
+		if resp == nil {
+			return 0, fmt.Errorf("upsert request returned no response")
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return 0, fmt.Errorf("upsert failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		var out struct {
+			Rows int `json:"rows"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return 0, fmt.Errorf("decode upsert response: %w", err)
+		}
+		return out.Rows, nil
 	}
-	var resp *http.Response
-	var req *http.Request
-	for i := 0; i < 3; i++ {
-		req, err = http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/api/rag/upsert", bytes.NewReader(b))
+}
+
+// reconcileRows deletes stale rows through the server API.
+func reconcileRows(baseURL string) ingest.ReconcileFunc {
+	return func(ctx context.Context, spec store.ReconcileSpec) (store.ReconcileResult, error) {
+		var res store.ReconcileResult
+		b, err := json.Marshal(spec)
 		if err != nil {
-			return fmt.Errorf("create request: %w", err)
+			return res, err
 		}
-		req.Header.Set("Content-Type", "application/json")
-		resp, err = http.DefaultClient.Do(req)
-		if err == nil {
-			break
+		req, err := newAPIRequest(ctx, http.MethodPost, baseURL+"/api/rag/reconcile", b)
+		if err != nil {
+			return res, err
 		}
-		time.Sleep(time.Second * time.Duration(i+1))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return res, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return res, fmt.Errorf("reconcile failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		return res, err
+	}
+}
+
+// apiCommits reads and records ingested commits through the server API.
+type apiCommits struct {
+	baseURL string
+}
+
+func (a apiCommits) IngestedCommit(ctx context.Context, repo, pathPrefix string) (store.IngestState, error) {
+	var out store.IngestState
+	q := url.Values{"repo": {repo}, "path_prefix": {pathPrefix}}
+	req, err := newAPIRequest(ctx, http.MethodGet, a.baseURL+"/api/rag/ingest/commit?"+q.Encode(), nil)
+	if err != nil {
+		return out, err
+	}
+	err = doJSON(req, &out)
+	return out, err
+}
+
+func (a apiCommits) SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state store.IngestState) error {
+	b, err := json.Marshal(map[string]string{"repo": repo, "path_prefix": pathPrefix, "commit": state.Commit, "config": state.Config})
+	if err != nil {
+		return err
 	}
+	req, err := newAPIRequest(ctx, http.MethodPut, a.baseURL+"/api/rag/ingest/commit", b)
 	if err != nil {
-		return fmt.Errorf("upsert request: %w", err)
+		return err
 	}
-	if resp == nil {
-		return fmt.Errorf("upsert request returned no response")
+	return doJSON(req, nil)
+}
+
+// doJSON sends req and decodes a 200 response into out when it is not nil.
+func doJSON(req *http.Request, out any) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upsert failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// cachedEmbeddings looks up reusable embeddings through the server API.
+func cachedEmbeddings(baseURL string) ingest.LookupFunc {
+	return func(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error) {
+		b, err := json.Marshal(map[string]any{"model": model, "keys": keys})
+		if err != nil {
+			return nil, err
+		}
+		req, err := newAPIRequest(ctx, http.MethodPost, baseURL+"/api/rag/embeddings/cached", b)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("embedding cache lookup failed: %s", resp.Status)
+		}
+		var out struct {
+			Embeddings map[string][]float32 `json:"embeddings"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return nil, err
+		}
+		return out.Embeddings, nil
 	}
-	slog.Info("ingested chunks", "count", len(rows), "file", rel)
-	return nil
 }
@@ -11,7 +11,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spf13/cobra"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -23,6 +23,7 @@ import (
 	"rag-server/config"
 	"rag-server/internal/auth"
 	"rag-server/internal/cache"
+	"rag-server/internal/rag/breaker"
 	rconfig "rag-server/internal/rag/config"
 	"rag-server/proxy"
 )
@@ -84,18 +85,21 @@ var rootCmd = &cobra.Command{
 		api.ConfigureServiceDB(nil)
 		dsn := cfg.Global.VectorDB.DSN()
 		var (
-			conn  *pgx.Conn
+			pool  *pgxpool.Pool
 			sqlDB *sql.DB
 		)
 		if dsn != "" {
 			logger.Debug("connecting to postgres", "dsn", dsn)
-			connectCtx, connectCancel := context.WithTimeout(context.Background(), 5*time.Second)
-			conn, err = pgx.Connect(connectCtx, dsn)
-			connectCancel()
+			vdb := rconfig.VectorDB{PGURL: dsn}
+			if rt, rerr := rconfig.LoadServer(); rerr == nil {
+				vdb.Pool = rt.VectorDB.Pool
+			}
+			pool, err = openPool(vdb)
 			if err != nil {
 				logger.Error("postgres connect error", "err", err)
 			} else {
-				logger.Info("postgres connected")
+				defer pool.Close()
+				logger.Info("postgres connected", "max_conns", pool.Stat().MaxConns())
 			}
 
 			gormDB, gormErr := gorm.Open(postgres.Open(dsn), &gorm.Config{})
@@ -121,8 +125,8 @@ var rootCmd = &cobra.Command{
 		}
 
 		var tokenCache *auth.TokenCache
-		if conn != nil {
-			cacheStore := cache.NewPostgresStore(conn, cache.Options{
+		if pool != nil {
+			cacheStore := cache.NewPostgresStore(pool, cache.Options{
 				Table:      cfg.Global.Cache.Table,
 				DefaultTTL: cfg.Global.Cache.DefaultTTL.Duration,
 			})
@@ -141,7 +145,7 @@ var rootCmd = &cobra.Command{
 		}
 
 		r := server.New(
-			api.RegisterRoutes(conn, cfg.Sync.Repo.Proxy),
+			api.RegisterRoutes(pool, cfg.Sync.Repo.Proxy),
 		)
 
 		// 启用认证中间件
@@ -169,8 +173,8 @@ var rootCmd = &cobra.Command{
 			r.Use(auth.VerifyTokenMiddleware(middlewareConfig))
 
 			// 添加健康检查路由
-			r.GET("/health", auth.HealthCheckHandler(authClient))
-			r.GET("/healthz", auth.HealthCheckHandler(authClient))
+			r.GET("/health", healthHandler("enabled"))
+			r.GET("/healthz", healthHandler("enabled"))
 			r.GET("/ping", auth.HealthCheckHandler(authClient))
 
 			logger.Info("authentication middleware enabled",
@@ -179,14 +183,8 @@ var rootCmd = &cobra.Command{
 			)
 		} else {
 			logger.Warn("authentication is disabled")
-			healthHandler := func(c *gin.Context) {
-				c.JSON(http.StatusOK, gin.H{
-					"status": "ok",
-					"auth":   "disabled",
-				})
-			}
-			r.GET("/health", healthHandler)
-			r.GET("/healthz", healthHandler)
+			r.GET("/health", healthHandler("disabled"))
+			r.GET("/healthz", healthHandler("disabled"))
 		}
 
 		server.UseCORS(r, logger, cfg.Server)
@@ -217,8 +215,49 @@ func init() {
 	rootCmd.Flags().StringVar(&logLevel, "log-level", "", "log level (debug, info, warn, error)")
 }
 
+// openPool creates the shared Postgres pool and verifies that the database is
+// reachable.
+func openPool(vdb rconfig.VectorDB) (*pgxpool.Pool, error) {
+	pc, err := vdb.PoolConfig()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pool, err := pgxpool.NewWithConfig(context.Background(), pc)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
+
+// healthHandler reports the auth mode and the model provider circuit
+// breakers. The status is "degraded" while any breaker is not closed; the
+// server keeps answering with 200 because the other endpoints may still work.
+func healthHandler(authMode string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		breakers := breaker.Snapshot()
+		status := "ok"
+		for _, b := range breakers {
+			if b.State != breaker.Closed {
+				status = "degraded"
+				break
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status":   status,
+			"auth":     authMode,
+			"breakers": breakers,
+		})
+	}
+}
@@ -117,8 +117,9 @@ type ChunkingCfg struct {
 
 type API struct {
 	AskAI struct {
-		Timeout int `yaml:"timeout"`
-		Retries int `yaml:"retries"`
+		Timeout       int `yaml:"timeout"`
+		Retries       int `yaml:"retries"`
+		HistoryWindow int `yaml:"history_window"`
 	} `yaml:"askai"`
 }
 
@@ -21,6 +21,10 @@ const (
 	bearerPrefix            = "Bearer "
 )
 
+// ServiceUserID is the user ID InternalAuthMiddleware assigns to every caller
+// authenticated with the shared service token.
+const ServiceUserID = "system"
+
 // AuthMiddleware is a middleware that validates JWT access tokens
 func (s *TokenService) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -99,7 +103,7 @@ func InternalAuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Set internal service context
-		ctx := context.WithValue(c.Request.Context(), userIDKey, "system")
+		ctx := context.WithValue(c.Request.Context(), userIDKey, ServiceUserID)
 		ctx = context.WithValue(ctx, emailKey, "internal@system.service")
 		ctx = context.WithValue(ctx, rolesKey, []string{"internal_service"})
 		ctx = context.WithValue(ctx, serviceKey, "rag-server")
@@ -9,6 +9,7 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
@@ -18,17 +19,18 @@ const (
 
 var identifierPattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
 
-// PostgresStore implements Store using an unlogged hstore table.
+// PostgresStore implements Store using an unlogged hstore table. It is safe
+// for concurrent use.
 type PostgresStore struct {
-	conn       *pgx.Conn
+	pool       *pgxpool.Pool
 	table      string
 	defaultTTL time.Duration
 }
 
-// NewPostgresStore constructs a Postgres-backed cache store.
-func NewPostgresStore(conn *pgx.Conn, opts Options) *PostgresStore {
+// NewPostgresStore constructs a Postgres-backed cache store on pool.
+func NewPostgresStore(pool *pgxpool.Pool, opts Options) *PostgresStore {
 	return &PostgresStore{
-		conn:       conn,
+		pool:       pool,
 		table:      sanitizeIdentifier(opts.Table),
 		defaultTTL: resolveDefaultTTL(opts.DefaultTTL),
 	}
@@ -36,8 +38,8 @@ func NewPostgresStore(conn *pgx.Conn, opts Options) *PostgresStore {
 
 // EnsureSchema creates the required extension and unlogged cache table.
 func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
-	if s.conn == nil {
-		return errors.New("postgres cache store requires a connection")
+	if s.pool == nil {
+		return errors.New("postgres cache store requires a connection pool")
 	}
 	table := s.table
 	if table == "" {
@@ -54,7 +56,7 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
 		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_expires_at_idx ON %s (expires_at)", table, table),
 	}
 	for _, statement := range statements {
-		if _, err := s.conn.Exec(ctx, statement); err != nil {
+		if _, err := s.pool.Exec(ctx, statement); err != nil {
 			return err
 		}
 	}
@@ -63,15 +65,15 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
 
 // Get returns the cached value for the key when present and not expired.
 func (s *PostgresStore) Get(ctx context.Context, key string) (string, bool, error) {
-	if s.conn == nil {
-		return "", false, errors.New("postgres cache store requires a connection")
+	if s.pool == nil {
+		return "", false, errors.New("postgres cache store requires a connection pool")
 	}
 	var (
 		payload   pgtype.Hstore
 		expiresAt time.Time
 	)
 	query := fmt.Sprintf("SELECT value, expires_at FROM %s WHERE key = $1", s.tableName())
-	row := s.conn.QueryRow(ctx, query, key)
+	row := s.pool.QueryRow(ctx, query, key)
 	if err := row.Scan(&payload, &expiresAt); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return "", false, nil
@@ -94,8 +96,8 @@ func (s *PostgresStore) Get(ctx context.Context, key string) (string, bool, erro
 
 // Set writes the value to the cache with the provided TTL.
 func (s *PostgresStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
-	if s.conn == nil {
-		return errors.New("postgres cache store requires a connection")
+	if s.pool == nil {
+		return errors.New("postgres cache store requires a connection pool")
 	}
 	if ttl <= 0 {
 		ttl = s.defaultTTL
@@ -105,27 +107,27 @@ func (s *PostgresStore) Set(ctx context.Context, key, value string, ttl time.Dur
 VALUES ($1, $2, $3)
 ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at, updated_at = now()`, s.tableName())
 	payload := pgtype.Hstore{"value": &value}
-	_, err := s.conn.Exec(ctx, query, key, payload, expiresAt)
+	_, err := s.pool.Exec(ctx, query, key, payload, expiresAt)
 	return err
 }
 
 // Delete removes a cached key.
 func (s *PostgresStore) Delete(ctx context.Context, key string) error {
-	if s.conn == nil {
-		return errors.New("postgres cache store requires a connection")
+	if s.pool == nil {
+		return errors.New("postgres cache store requires a connection pool")
 	}
 	query := fmt.Sprintf("DELETE FROM %s WHERE key = $1", s.tableName())
-	_, err := s.conn.Exec(ctx, query, key)
+	_, err := s.pool.Exec(ctx, query, key)
 	return err
 }
 
 // PurgeExpired removes expired cache entries.
 func (s *PostgresStore) PurgeExpired(ctx context.Context) (int64, error) {
-	if s.conn == nil {
-		return 0, errors.New("postgres cache store requires a connection")
+	if s.pool == nil {
+		return 0, errors.New("postgres cache store requires a connection pool")
 	}
 	query := fmt.Sprintf("DELETE FROM %s WHERE expires_at <= now()", s.tableName())
-	res, err := s.conn.Exec(ctx, query)
+	res, err := s.pool.Exec(ctx, query)
 	if err != nil {
 		return 0, err
 	}
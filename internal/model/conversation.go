@@ -0,0 +1,83 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Conversation groups the AskAI messages exchanged with a single user.
+type Conversation struct {
+	ID        string    `gorm:"size:36;primaryKey" json:"id"`
+	UserID    string    `gorm:"size:128;not null;index" json:"user_id"`
+	Title     string    `gorm:"size:256" json:"title"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"not null;index" json:"updated_at"`
+}
+
+// TableName sets the table name for Conversation.
+func (Conversation) TableName() string { return "conversations" }
+
+// ConversationMessage is a single user or assistant turn in a conversation.
+type ConversationMessage struct {
+	ID             uint             `gorm:"primaryKey" json:"id"`
+	ConversationID string           `gorm:"size:36;not null;index" json:"conversation_id"`
+	Role           string           `gorm:"size:16;not null" json:"role"`
+	Content        string           `gorm:"type:text;not null" json:"content"`
+	Citations      MessageCitations `gorm:"type:jsonb" json:"citations,omitempty"`
+	CreatedAt      time.Time        `gorm:"not null" json:"created_at"`
+}
+
+// TableName sets the table name for ConversationMessage.
+func (ConversationMessage) TableName() string { return "conversation_messages" }
+
+// MessageCitation points an assistant answer at a retrieved chunk.
+type MessageCitation struct {
+	Index   int    `json:"index"`
+	Repo    string `json:"repo"`
+	Path    string `json:"path"`
+	ChunkID int    `json:"chunk_id"`
+}
+
+// MessageCitations is stored as a JSON array.
+type MessageCitations []MessageCitation
+
+func (mc MessageCitations) Value() (driver.Value, error) {
+	if len(mc) == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]MessageCitation(mc))
+}
+
+func (mc *MessageCitations) Scan(value any) error {
+	if mc == nil {
+		return fmt.Errorf("MessageCitations receiver is nil")
+	}
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*mc = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported type %T for MessageCitations", value)
+	}
+	if len(data) == 0 {
+		*mc = nil
+		return nil
+	}
+	var decoded []MessageCitation
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if len(decoded) == 0 {
+		*mc = nil
+		return nil
+	}
+	*mc = decoded
+	return nil
+}
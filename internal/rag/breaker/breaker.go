@@ -0,0 +1,188 @@
+// Package breaker implements circuit breakers that keep requests away from
+// model endpoints that keep failing.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the state of a Breaker.
+type State string
+
+// Breaker states. A closed breaker lets requests through, an open one
+// rejects them, and a half-open one lets a single probe through to decide
+// whether to close again.
+const (
+	Closed   State = "closed"
+	Open     State = "open"
+	HalfOpen State = "half_open"
+)
+
+// Defaults used by Get.
+const (
+	// DefaultFailures is the number of consecutive failures that opens a
+	// breaker.
+	DefaultFailures = 5
+	// DefaultCooldown is how long a breaker stays open before probing.
+	DefaultCooldown = 30 * time.Second
+)
+
+// ErrOpen is returned by Allow while a breaker rejects requests.
+var ErrOpen = errors.New("circuit breaker open")
+
+// Breaker tracks the health of one endpoint.
+type Breaker struct {
+	name     string
+	failures int
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	count    int
+	openedAt time.Time
+	probing  bool
+	lastErr  string
+}
+
+// New returns a closed breaker that opens after failures consecutive
+// failures and probes again after cooldown.
+func New(name string, failures int, cooldown time.Duration) *Breaker {
+	if failures <= 0 {
+		failures = DefaultFailures
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultCooldown
+	}
+	return &Breaker{name: name, failures: failures, cooldown: cooldown, now: time.Now, state: Closed}
+}
+
+// Allow reports whether a request may be sent. Once the cooldown has passed
+// an open breaker turns half-open and admits one probe at a time. Every
+// admitted request must be followed by Report.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open {
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%s: %w", b.name, ErrOpen)
+		}
+		b.state = HalfOpen
+		b.probing = false
+	}
+	if b.state == HalfOpen {
+		if b.probing {
+			return fmt.Errorf("%s: %w", b.name, ErrOpen)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Report records the outcome of a request admitted by Allow. Errors for
+// which Failure is false count as successes, except context.Canceled, which
+// means the caller gave up and says nothing about the endpoint.
+func (b *Breaker) Report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case errors.Is(err, context.Canceled):
+		b.probing = false
+	case !Failure(err):
+		b.state = Closed
+		b.count = 0
+		b.probing = false
+	default:
+		b.count++
+		b.lastErr = err.Error()
+		b.probing = false
+		if b.state == HalfOpen || b.count >= b.failures {
+			b.state = Open
+			b.openedAt = b.now()
+		}
+	}
+}
+
+// Failure reports whether err points at an unhealthy endpoint: a timeout, a
+// network error, rate limiting or a server error. Errors carrying an HTTP
+// status are recognised by a StatusCode method.
+func Failure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var coded interface{ StatusCode() int }
+	if errors.As(err, &coded) {
+		code := coded.StatusCode()
+		return code == http.StatusTooManyRequests || code >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Status describes a breaker for health reporting.
+type Status struct {
+	Name     string `json:"name"`
+	State    State  `json:"state"`
+	Failures int    `json:"failures"`
+	// LastError is the most recent failure.
+	LastError string `json:"last_error,omitempty"`
+	// RetryAt is when an open breaker admits its next probe.
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+}
+
+// Status returns the current state of b.
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Status{Name: b.name, State: b.state, Failures: b.count, LastError: b.lastErr}
+	if b.state == Open {
+		at := b.openedAt.Add(b.cooldown)
+		s.RetryAt = &at
+	}
+	return s
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Breaker{}
+)
+
+// Get returns the process-wide breaker called name, creating it with the
+// default thresholds on first use. Clients of the same endpoint share it.
+func Get(name string) *Breaker {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	b, ok := registry[name]
+	if !ok {
+		b = New(name, DefaultFailures, DefaultCooldown)
+		registry[name] = b
+	}
+	return b
+}
+
+// Snapshot returns the status of every breaker created by Get, sorted by
+// name.
+func Snapshot() []Status {
+	registryMu.Lock()
+	all := make([]*Breaker, 0, len(registry))
+	for _, b := range registry {
+		all = append(all, b)
+	}
+	registryMu.Unlock()
+	out := make([]Status, len(all))
+	for i, b := range all {
+		out[i] = b.Status()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,112 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+type statusErr int
+
+func (e statusErr) Error() string   { return fmt.Sprintf("status %d", int(e)) }
+func (e statusErr) StatusCode() int { return int(e) }
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("test", 2, time.Minute)
+	b.now = func() time.Time { return now }
+	fail := statusErr(503)
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("closed breaker rejected request %d: %v", i, err)
+		}
+		b.Report(fail)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("expected open breaker, got %v", err)
+	}
+	if st := b.Status(); st.State != Open || st.Failures != 2 || st.LastError != "status 503" || !st.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	// After the cooldown a single probe is admitted.
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected probe, got %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("expected a second probe to be rejected, got %v", err)
+	}
+	// A failed probe opens the breaker again.
+	b.Report(fail)
+	if b.Status().State != Open {
+		t.Fatalf("expected open after failed probe, got %s", b.Status().State)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected probe, got %v", err)
+	}
+	// A caller cancelling the probe frees the slot without a verdict.
+	b.Report(context.Canceled)
+	if st := b.Status(); st.State != HalfOpen {
+		t.Fatalf("expected half-open, got %s", st.State)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected another probe, got %v", err)
+	}
+	// Client errors mean the endpoint answered.
+	b.Report(statusErr(400))
+	if st := b.Status(); st.State != Closed || st.Failures != 0 {
+		t.Fatalf("expected closed, got %+v", st)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("decode"), false},
+		{statusErr(404), false},
+		{statusErr(429), true},
+		{fmt.Errorf("wrapped: %w", statusErr(502)), true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+	}
+	for _, c := range cases {
+		if got := Failure(c.err); got != c.want {
+			t.Errorf("Failure(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestGetAndSnapshot(t *testing.T) {
+	a := Get("snapshot b")
+	if Get("snapshot b") != a {
+		t.Fatal("expected the same breaker")
+	}
+	Get("snapshot a")
+	var names []string
+	for _, s := range Snapshot() {
+		names = append(names, s.Name)
+	}
+	ia, ib := -1, -1
+	for i, n := range names {
+		switch n {
+		case "snapshot a":
+			ia = i
+		case "snapshot b":
+			ib = i
+		}
+	}
+	if ia < 0 || ib < 0 || ia > ib {
+		t.Fatalf("unexpected snapshot order %v", names)
+	}
+}
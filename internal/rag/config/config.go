@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -13,17 +14,38 @@ type DataSource struct {
 	Name string `yaml:"name"`
 	Repo string `yaml:"repo"`
 	Path string `yaml:"path"`
+	// Ref is the branch, tag or commit to ingest. Empty means the remote's
+	// default branch.
+	Ref string `yaml:"ref"`
+	// Sparse checks out only Path instead of the whole repository.
+	Sparse bool `yaml:"sparse"`
+	// Username is the HTTP basic-auth user for TokenEnv (default "token")
+	// or the SSH user for SSHKey (default "git").
+	Username string `yaml:"username"`
+	// TokenEnv names the environment variable holding the HTTP token.
+	TokenEnv string `yaml:"token_env"`
+	// SSHKey is the path of the private key for SSH remotes.
+	SSHKey string `yaml:"ssh_key"`
+	// SSHKeyPassphraseEnv names the environment variable holding the
+	// passphrase of SSHKey, if it has one.
+	SSHKeyPassphraseEnv string `yaml:"ssh_key_passphrase_env"`
+	// KnownHosts is the known_hosts file that SSH host keys are checked
+	// against. Empty means $SSH_KNOWN_HOSTS or ~/.ssh/known_hosts.
+	KnownHosts string `yaml:"known_hosts"`
 }
 
-// VectorDB configuration for PostgreSQL with pgvector.
+// VectorDB configuration for the vector store. Backend selects "postgres"
+// (default, PostgreSQL with pgvector) or "memory" (in-process, not persisted).
 type VectorDB struct {
-	PGURL      string `yaml:"pgurl"`
-	PGHost     string `yaml:"pg_host"`
-	PGPort     int    `yaml:"pg_port"`
-	PGUser     string `yaml:"pg_user"`
-	PGPassword string `yaml:"pg_password"`
-	PGDBName   string `yaml:"pg_db_name"`
-	PGSSLMode  string `yaml:"pg_sslmode"`
+	Backend    string  `yaml:"backend"`
+	PGURL      string  `yaml:"pgurl"`
+	PGHost     string  `yaml:"pg_host"`
+	PGPort     int     `yaml:"pg_port"`
+	PGUser     string  `yaml:"pg_user"`
+	PGPassword string  `yaml:"pg_password"`
+	PGDBName   string  `yaml:"pg_db_name"`
+	PGSSLMode  string  `yaml:"pg_sslmode"`
+	Pool       PoolCfg `yaml:"pool"`
 }
 
 // Duration is a thin wrapper around time.Duration to support YAML unmarshalling.
@@ -123,7 +145,27 @@ type ModelCfg struct {
 	Models   StringSlice `yaml:"models"`
 	BaseURL  string      `yaml:"baseurl"`
 	Endpoint string      `yaml:"endpoint"`
-	Token    string      `yaml:"token"`
+	// Endpoints lists equivalent endpoints that are tried in order after
+	// Endpoint when it fails or its circuit breaker is open.
+	Endpoints StringSlice `yaml:"endpoints"`
+	Token     string      `yaml:"token"`
+	// Timeout bounds each request to one endpoint. Zero keeps the default.
+	Timeout Duration `yaml:"timeout"`
+}
+
+// EndpointList returns Endpoint followed by Endpoints with trailing slashes
+// removed, skipping blanks and duplicates.
+func (m ModelCfg) EndpointList() []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, e := range append([]string{m.Endpoint}, m.Endpoints...) {
+		e = strings.TrimRight(strings.TrimSpace(e), "/")
+		if e != "" && !seen[e] {
+			seen[e] = true
+			out = append(out, e)
+		}
+	}
+	return out
 }
 
 // EmbeddingCfg describes embedding service settings.
@@ -132,6 +174,9 @@ type EmbeddingCfg struct {
 	Dimension    int `yaml:"dimension"`
 	MaxChars     int `yaml:"max_chars"`
 	RateLimitTPM int `yaml:"rate_limit_tpm"`
+	// Overflow is "truncate" (default) or "split" for texts over MaxChars.
+	Overflow    string `yaml:"overflow"`
+	Concurrency int    `yaml:"concurrency"`
 }
 
 // ChunkingCfg controls how markdown is split into chunks.
@@ -145,6 +190,54 @@ type ChunkingCfg struct {
 	EmbedHeadings       bool     `yaml:"embed_headings"`
 	EmbedTOC            bool     `yaml:"embed_toc"`
 	AdditionalMaxTokens []int    `yaml:"additional_max_tokens"`
+	// Tokenizer measures chunk sizes: "cjk" (default) or "bpe".
+	Tokenizer string `yaml:"tokenizer"`
+	// TokenizerVocab is the tiktoken vocabulary file of the bpe tokenizer.
+	TokenizerVocab string `yaml:"tokenizer_vocab"`
+}
+
+// RetrievalCfg tunes hybrid retrieval. MaxK and MaxCandidates bound the
+// values callers may request per query.
+type RetrievalCfg struct {
+	Alpha         float64   `yaml:"alpha"`
+	Candidates    int       `yaml:"candidates"`
+	MaxK          int       `yaml:"max_k"`
+	MaxCandidates int       `yaml:"max_candidates"`
+	Fusion        FusionCfg `yaml:"fusion"`
+	MMR           MMRCfg    `yaml:"mmr"`
+	MaxPerPath    int       `yaml:"max_per_path"`
+	Expand        ExpandCfg `yaml:"expand"`
+	Query         QueryCfg  `yaml:"query"`
+}
+
+// QueryCfg selects a pre-retrieval strategy that uses the generator. Mode is
+// "none" (default), "rewrite", "multi" or "hyde". Paraphrases is the number of
+// alternative queries generated in "multi" mode.
+type QueryCfg struct {
+	Mode        string `yaml:"mode"`
+	Paraphrases int    `yaml:"paraphrases"`
+}
+
+// ExpandCfg widens retrieved chunks with surrounding text. Mode is "none"
+// (default), "neighbors" or "section"; MaxTokens bounds each expanded hit.
+type ExpandCfg struct {
+	Mode      string `yaml:"mode"`
+	MaxTokens int    `yaml:"max_tokens"`
+}
+
+// MMRCfg enables Maximal Marginal Relevance diversification of results.
+// Lambda trades relevance (1) against novelty (0).
+type MMRCfg struct {
+	Enabled bool    `yaml:"enabled"`
+	Lambda  float64 `yaml:"lambda"`
+}
+
+// FusionCfg selects how vector and lexical results are merged. Strategy is
+// one of "raw" (default), "rrf", "minmax" or "zscore". RRFK is the rank
+// constant for reciprocal rank fusion.
+type FusionCfg struct {
+	Strategy string `yaml:"strategy"`
+	RRFK     int    `yaml:"rrf_k"`
 }
 
 // Config is the root configuration for ingestion.
@@ -164,18 +257,19 @@ type Config struct {
 	} `yaml:"models"`
 	Embedding EmbeddingCfg `yaml:"embedding"`
 	Chunking  ChunkingCfg  `yaml:"chunking"`
-	Retrieval struct {
-		Alpha      float64 `yaml:"alpha"`
-		Candidates int     `yaml:"candidates"`
-	} `yaml:"retrieval"`
-	API struct {
-		AskAI struct {
-			Timeout int `yaml:"timeout"`
-			Retries int `yaml:"retries"`
-		} `yaml:"askai"`
+	Retrieval RetrievalCfg `yaml:"retrieval"`
+	API       struct {
+		AskAI AskAICfg `yaml:"askai"`
 	} `yaml:"api"`
 }
 
+// AskAICfg controls generation requests. Timeout is in seconds.
+type AskAICfg struct {
+	Timeout       int `yaml:"timeout"`
+	Retries       int `yaml:"retries"`
+	HistoryWindow int `yaml:"history_window"`
+}
+
 // Load reads YAML configuration from the given path.
 func Load(path string) (*Config, error) {
 	b, err := os.ReadFile(path)
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PoolCfg tunes the shared Postgres connection pool. Zero values keep the
+// pgxpool defaults.
+type PoolCfg struct {
+	MaxConns          int32    `yaml:"max_conns"`
+	MinConns          int32    `yaml:"min_conns"`
+	MaxConnLifetime   Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime   Duration `yaml:"max_conn_idle_time"`
+	HealthCheckPeriod Duration `yaml:"health_check_period"`
+	// StatementCache is the number of prepared statements cached per
+	// connection. A negative value disables statement caching, which is
+	// required behind PgBouncer in transaction pooling mode.
+	StatementCache int `yaml:"statement_cache"`
+}
+
+// PoolConfig returns the pgxpool configuration for the database described by
+// v with the pool settings applied.
+func (v VectorDB) PoolConfig() (*pgxpool.Config, error) {
+	dsn := v.DSN()
+	if dsn == "" {
+		return nil, errors.New("vectordb: no postgres dsn configured")
+	}
+	pc, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	p := v.Pool
+	if p.MaxConns > 0 {
+		pc.MaxConns = p.MaxConns
+	}
+	if p.MinConns > 0 {
+		pc.MinConns = p.MinConns
+	}
+	if pc.MinConns > pc.MaxConns {
+		return nil, errors.New("vectordb: pool min_conns exceeds max_conns")
+	}
+	if p.MaxConnLifetime.Duration > 0 {
+		pc.MaxConnLifetime = p.MaxConnLifetime.Duration
+	}
+	if p.MaxConnIdleTime.Duration > 0 {
+		pc.MaxConnIdleTime = p.MaxConnIdleTime.Duration
+	}
+	if p.HealthCheckPeriod.Duration > 0 {
+		pc.HealthCheckPeriod = p.HealthCheckPeriod.Duration
+	}
+	switch {
+	case p.StatementCache > 0:
+		pc.ConnConfig.StatementCacheCapacity = p.StatementCache
+	case p.StatementCache < 0:
+		pc.ConnConfig.StatementCacheCapacity = 0
+		pc.ConnConfig.DescriptionCacheCapacity = 0
+		pc.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	}
+	return pc, nil
+}
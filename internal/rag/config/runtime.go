@@ -6,18 +6,24 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // RuntimeEmbedding is the resolved embedding configuration used at runtime.
 type RuntimeEmbedding struct {
-	Provider     string
+	Provider string
+	// Endpoint is the first of Endpoints.
 	Endpoint     string
+	Endpoints    []string
+	Timeout      time.Duration
 	APIKey       string
 	Model        string
 	Dimension    int
 	RateLimitTPM int
 	MaxBatch     int
 	MaxChars     int
+	Overflow     string
+	Concurrency  int
 }
 
 // ResolveEmbedding applies fallback logic to produce runtime embedding settings.
@@ -28,7 +34,11 @@ func (c *Config) ResolveEmbedding() RuntimeEmbedding {
 	if len(m.Models) > 0 {
 		rt.Model = m.Models[0]
 	}
-	rt.Endpoint = strings.TrimRight(m.Endpoint, "/")
+	rt.Endpoints = m.EndpointList()
+	if len(rt.Endpoints) > 0 {
+		rt.Endpoint = rt.Endpoints[0]
+	}
+	rt.Timeout = m.Timeout.Duration
 	rt.APIKey = m.Token
 
 	e := c.Embedding
@@ -36,6 +46,8 @@ func (c *Config) ResolveEmbedding() RuntimeEmbedding {
 	rt.RateLimitTPM = e.RateLimitTPM
 	rt.MaxBatch = e.MaxBatch
 	rt.MaxChars = e.MaxChars
+	rt.Overflow = e.Overflow
+	rt.Concurrency = e.Concurrency
 	return rt
 }
 
@@ -121,11 +133,10 @@ type Runtime struct {
 	Datasources []DataSource `yaml:"datasources"`
 	Proxy       string       `yaml:"proxy"`
 	Embedding   RuntimeEmbedding
+	Generator   ModelCfg
+	AskAI       AskAICfg
 	Reranker    ModelCfg
-	Retrieval   struct {
-		Alpha      float64 `yaml:"alpha"`
-		Candidates int     `yaml:"candidates"`
-	} `yaml:"retrieval"`
+	Retrieval   RetrievalCfg `yaml:"retrieval"`
 }
 
 // ServerConfigPath points to the server configuration file.
@@ -168,6 +179,8 @@ func LoadServer() (*Runtime, error) {
 	}
 	rt.Cache = cfg.Global.Cache
 	rt.Embedding = cfg.ResolveEmbedding()
+	rt.Generator = cfg.Models.Generator
+	rt.AskAI = cfg.API.AskAI
 	rt.Reranker = cfg.Models.Reranker
 	rt.Retrieval = cfg.Retrieval
 	return rt, nil
@@ -185,15 +198,21 @@ func (rt *Runtime) ToConfig() *Config {
 	c.Global.Proxy = rt.Proxy
 	c.Models.Embedder.Provider = rt.Embedding.Provider
 	c.Models.Embedder.Endpoint = rt.Embedding.Endpoint
+	c.Models.Embedder.Endpoints = rt.Embedding.Endpoints
+	c.Models.Embedder.Timeout = Duration{rt.Embedding.Timeout}
 	c.Models.Embedder.Token = rt.Embedding.APIKey
 	if rt.Embedding.Model != "" {
 		c.Models.Embedder.Models = []string{rt.Embedding.Model}
 	}
+	c.Models.Generator = rt.Generator
+	c.API.AskAI = rt.AskAI
 	c.Models.Reranker = rt.Reranker
 	c.Retrieval = rt.Retrieval
 	c.Embedding.Dimension = rt.Embedding.Dimension
 	c.Embedding.MaxBatch = rt.Embedding.MaxBatch
 	c.Embedding.MaxChars = rt.Embedding.MaxChars
 	c.Embedding.RateLimitTPM = rt.Embedding.RateLimitTPM
+	c.Embedding.Overflow = rt.Embedding.Overflow
+	c.Embedding.Concurrency = rt.Embedding.Concurrency
 	return &c
 }
@@ -1,6 +1,12 @@
 package config
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
 
 func TestVectorDB_DSN(t *testing.T) {
 	v := VectorDB{
@@ -18,6 +24,33 @@ func TestVectorDB_DSN(t *testing.T) {
 	}
 }
 
+func TestVectorDB_PoolConfig(t *testing.T) {
+	v := VectorDB{PGURL: "postgres://u:p@db:5432/kb?sslmode=disable", Pool: PoolCfg{
+		MaxConns:          12,
+		MinConns:          2,
+		HealthCheckPeriod: Duration{30 * time.Second},
+		StatementCache:    -1,
+	}}
+	pc, err := v.PoolConfig()
+	if err != nil {
+		t.Fatalf("PoolConfig: %v", err)
+	}
+	if pc.MaxConns != 12 || pc.MinConns != 2 || pc.HealthCheckPeriod != 30*time.Second {
+		t.Fatalf("unexpected pool config %+v", pc)
+	}
+	if pc.ConnConfig.StatementCacheCapacity != 0 || pc.ConnConfig.DefaultQueryExecMode != pgx.QueryExecModeExec {
+		t.Fatalf("statement cache should be disabled")
+	}
+
+	v.Pool = PoolCfg{MaxConns: 1, MinConns: 2}
+	if _, err := v.PoolConfig(); err == nil {
+		t.Fatal("expected min_conns > max_conns to fail")
+	}
+	if _, err := (VectorDB{}).PoolConfig(); err == nil {
+		t.Fatal("expected missing dsn to fail")
+	}
+}
+
 func TestResolveEmbedding(t *testing.T) {
 	cfg := &Config{}
 	cfg.Models.Embedder.Provider = "p1"
@@ -34,6 +67,19 @@ func TestResolveEmbedding(t *testing.T) {
 	if e.Model != "m" {
 		t.Fatalf("unexpected model %q", e.Model)
 	}
+
+	cfg.Models.Embedder.Endpoint = ""
+	cfg.Models.Embedder.Endpoints = []string{"http://a/", " ", "http://b", "http://a"}
+	cfg.Models.Embedder.Timeout = Duration{5 * time.Second}
+	e = cfg.ResolveEmbedding()
+	if e.Endpoint != "http://a" || strings.Join(e.Endpoints, ",") != "http://a,http://b" || e.Timeout != 5*time.Second {
+		t.Fatalf("unexpected endpoints %q %v timeout %s", e.Endpoint, e.Endpoints, e.Timeout)
+	}
+	rt := &Runtime{Embedding: e}
+	back := rt.ToConfig().ResolveEmbedding()
+	if strings.Join(back.Endpoints, ",") != "http://a,http://b" || back.Timeout != 5*time.Second {
+		t.Fatalf("ToConfig lost endpoints %v or timeout %s", back.Endpoints, back.Timeout)
+	}
 }
 
 func TestResolveChunking(t *testing.T) {
@@ -0,0 +1,244 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Defaults applied by NewBatcher to unset BatchOptions.
+const (
+	DefaultMaxBatch    = 64
+	DefaultConcurrency = 4
+)
+
+// Ways to handle inputs longer than BatchOptions.MaxChars.
+const (
+	// OverflowTruncate embeds the first MaxChars characters.
+	OverflowTruncate = "truncate"
+	// OverflowSplit embeds every MaxChars piece and averages the vectors.
+	OverflowSplit = "split"
+)
+
+// BatchOptions configure a Batcher.
+type BatchOptions struct {
+	// MaxBatch is the number of texts sent in one request.
+	MaxBatch int
+	// MaxChars limits the characters of one text; zero means no limit.
+	MaxChars int
+	// Overflow is OverflowTruncate (default) or OverflowSplit.
+	Overflow string
+	// Concurrency bounds the number of requests in flight.
+	Concurrency int
+}
+
+// Batcher wraps an Embedder so that inputs are sent in groups of at most
+// MaxBatch texts, over-long texts are truncated or split, and groups run
+// concurrently. Results are returned in input order.
+type Batcher struct {
+	inner Embedder
+	opts  BatchOptions
+}
+
+// NewBatcher wraps inner. Zero MaxBatch and Concurrency select the defaults.
+func NewBatcher(inner Embedder, opts BatchOptions) *Batcher {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = DefaultMaxBatch
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	opts.Overflow = strings.ToLower(opts.Overflow)
+	return &Batcher{inner: inner, opts: opts}
+}
+
+// Dimension returns the dimension of the wrapped embedder.
+func (b *Batcher) Dimension() int { return b.inner.Dimension() }
+
+// RateLimitWait returns the time the wrapped embedder spent waiting for
+// rate limiters, zero when it does not limit its requests.
+func (b *Batcher) RateLimitWait() time.Duration {
+	if w, ok := b.inner.(interface{ RateLimitWait() time.Duration }); ok {
+		return w.RateLimitWait()
+	}
+	return 0
+}
+
+// Embed embeds inputs and returns one vector per input and the summed token
+// usage. The first failing group cancels the others.
+func (b *Batcher) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	var (
+		texts  []string
+		owners []int
+	)
+	for i, in := range inputs {
+		for _, p := range b.prepare(in) {
+			texts = append(texts, p)
+			owners = append(owners, i)
+		}
+	}
+
+	vecs := make([][]float32, len(texts))
+	tokens, err := b.run(ctx, texts, vecs)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(texts) == len(inputs) {
+		return vecs, tokens, nil
+	}
+	return combine(inputs, texts, owners, vecs), tokens, nil
+}
+
+// run embeds texts into vecs group by group.
+func (b *Batcher) run(ctx context.Context, texts []string, vecs [][]float32) (int, error) {
+	if len(texts) == 0 {
+		return 0, nil
+	}
+	size := b.opts.MaxBatch
+	embed := func(ctx context.Context, start, end int) (int, error) {
+		out, n, err := b.inner.Embed(ctx, texts[start:end])
+		if err != nil {
+			return 0, err
+		}
+		if len(out) != end-start {
+			return 0, fmt.Errorf("embedding count mismatch: got %d, want %d", len(out), end-start)
+		}
+		copy(vecs[start:end], out)
+		return n, nil
+	}
+
+	start, tokens := 0, 0
+	// Embedders learn their dimension from the first response, so run the
+	// first group alone when it is still unknown.
+	if b.inner.Dimension() == 0 || b.opts.Concurrency == 1 || len(texts) <= size {
+		end := min(size, len(texts))
+		n, err := embed(ctx, 0, end)
+		if err != nil {
+			return 0, err
+		}
+		start, tokens = end, n
+	}
+	if start == len(texts) {
+		return tokens, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, b.opts.Concurrency)
+loop:
+	for ; start < len(texts); start += size {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			n, err := embed(ctx, start, end)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			tokens += n
+		}(start, min(start+size, len(texts)))
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return tokens, nil
+}
+
+// prepare applies MaxChars to one input.
+func (b *Batcher) prepare(text string) []string {
+	limit := b.opts.MaxChars
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+	if b.opts.Overflow != OverflowSplit {
+		return []string{string([]rune(text)[:limit])}
+	}
+	return splitText(text, limit)
+}
+
+// splitText cuts text into pieces of at most limit runes, preferring to break
+// after whitespace in the second half of a piece.
+func splitText(text string, limit int) []string {
+	runes := []rune(text)
+	var pieces []string
+	for len(runes) > limit {
+		cut := limit
+		for i := limit; i > limit/2; i-- {
+			if unicode.IsSpace(runes[i-1]) {
+				cut = i
+				break
+			}
+		}
+		pieces = append(pieces, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	if len(runes) > 0 {
+		pieces = append(pieces, string(runes))
+	}
+	return pieces
+}
+
+// combine averages the vectors of split inputs, weighted by piece length, and
+// scales the result to unit length.
+func combine(inputs, texts []string, owners []int, vecs [][]float32) [][]float32 {
+	out := make([][]float32, len(inputs))
+	sums := make([][]float64, len(inputs))
+	parts := make([]int, len(inputs))
+	for i, v := range vecs {
+		o := owners[i]
+		parts[o]++
+		if sums[o] == nil {
+			sums[o] = make([]float64, len(v))
+		}
+		w := float64(utf8.RuneCountInString(texts[i]))
+		for j := range v {
+			if j < len(sums[o]) {
+				sums[o][j] += w * float64(v[j])
+			}
+		}
+		out[o] = v
+	}
+	for o, sum := range sums {
+		if parts[o] < 2 {
+			continue
+		}
+		var norm float64
+		for _, x := range sum {
+			norm += x * x
+		}
+		norm = math.Sqrt(norm)
+		vec := make([]float32, len(sum))
+		for j, x := range sum {
+			if norm > 0 {
+				vec[j] = float32(x / norm)
+			}
+		}
+		out[o] = vec
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lengthEmbedder returns [len(text), 1] for every text and records the
+// request sizes and peak concurrency.
+type lengthEmbedder struct {
+	mu       sync.Mutex
+	sizes    []int
+	texts    []string
+	active   atomic.Int32
+	peak     atomic.Int32
+	failWith string
+}
+
+func (e *lengthEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	n := e.active.Add(1)
+	defer e.active.Add(-1)
+	for {
+		p := e.peak.Load()
+		if n <= p || e.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	e.mu.Lock()
+	e.sizes = append(e.sizes, len(inputs))
+	e.texts = append(e.texts, inputs...)
+	e.mu.Unlock()
+	out := make([][]float32, len(inputs))
+	for i, in := range inputs {
+		if e.failWith != "" && in == e.failWith {
+			return nil, 0, errors.New("boom")
+		}
+		out[i] = []float32{float32(len([]rune(in))), 1}
+	}
+	return out, len(inputs), nil
+}
+
+func (e *lengthEmbedder) Dimension() int { return 2 }
+
+func TestBatcherGroupsAndOrder(t *testing.T) {
+	inner := &lengthEmbedder{}
+	b := NewBatcher(inner, BatchOptions{MaxBatch: 3, Concurrency: 2})
+	inputs := make([]string, 10)
+	for i := range inputs {
+		inputs[i] = strings.Repeat("x", i+1)
+	}
+	vecs, tokens, err := b.Embed(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if tokens != 10 || len(vecs) != 10 {
+		t.Fatalf("got %d vectors, %d tokens", len(vecs), tokens)
+	}
+	for i, v := range vecs {
+		if v[0] != float32(i+1) {
+			t.Fatalf("vector %d out of order: %v", i, v)
+		}
+	}
+	for _, n := range inner.sizes {
+		if n > 3 {
+			t.Fatalf("request of %d texts exceeds max_batch", n)
+		}
+	}
+	if p := inner.peak.Load(); p > 2 {
+		t.Fatalf("peak concurrency %d exceeds limit", p)
+	}
+}
+
+func TestBatcherMaxChars(t *testing.T) {
+	inner := &lengthEmbedder{}
+	b := NewBatcher(inner, BatchOptions{MaxChars: 4})
+	vecs, _, err := b.Embed(context.Background(), []string{"短文本", "abcdefghij"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if vecs[0][0] != 3 || vecs[1][0] != 4 {
+		t.Fatalf("expected truncation to 4 runes, got %v", vecs)
+	}
+
+	inner = &lengthEmbedder{}
+	b = NewBatcher(inner, BatchOptions{MaxChars: 4, Overflow: "Split"})
+	vecs, _, err = b.Embed(context.Background(), []string{"ab", "aaa bbbb cc"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if strings.Join(inner.texts, "|") != "ab|aaa |bbbb| cc" {
+		t.Fatalf("unexpected pieces %q", inner.texts)
+	}
+	if len(vecs) != 2 || vecs[0][0] != 2 {
+		t.Fatalf("unexpected vectors %v", vecs)
+	}
+	// Weighted mean of [4,1],[4,1],[3,1] with weights 4,4,3, scaled to unit length.
+	x, y := float64(4*4+4*4+3*3), float64(4+4+3)
+	n := math.Hypot(x, y)
+	if math.Abs(float64(vecs[1][0])-x/n) > 1e-6 || math.Abs(float64(vecs[1][1])-y/n) > 1e-6 {
+		t.Fatalf("unexpected combined vector %v", vecs[1])
+	}
+}
+
+func TestBatcherError(t *testing.T) {
+	inner := &lengthEmbedder{failWith: "bad"}
+	b := NewBatcher(inner, BatchOptions{MaxBatch: 1, Concurrency: 4})
+	_, _, err := b.Embed(context.Background(), []string{"a", "b", "bad", "c", "d"})
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("expected boom, got %v", err)
+	}
+}
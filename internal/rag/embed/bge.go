@@ -1,85 +1,88 @@
 package embed
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 )
 
 // BGE implements the Embedder interface for a BGE embedding service.
 type BGE struct {
 	endpoint string
 	token    string
-	dim      int
+	dim      dimension
 	client   *http.Client
 }
 
 // NewBGE returns a new BGE embedder.
 func NewBGE(endpoint, token string, dim int) *BGE {
-	return &BGE{
+	b := &BGE{
 		endpoint: endpoint,
 		token:    token,
-		dim:      dim,
-		client:   &http.Client{Timeout: 30 * time.Second},
+		client:   &http.Client{},
 	}
+	b.dim.learn(dim)
+	return b
 }
 
 // Dimension returns the embedding dimension if known.
-func (b *BGE) Dimension() int { return b.dim }
+func (b *BGE) Dimension() int { return b.dim.get() }
 
-// Embed posts texts to the BGE service and returns embeddings.
+// Embed posts all texts to the BGE service in one request and returns
+// their embeddings.
 func (b *BGE) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
-	vecs := make([][]float32, len(inputs))
-	for i, text := range inputs {
-		payload := map[string]any{"inputs": text}
-		body, _ := json.Marshal(payload)
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint, bytes.NewReader(body))
-		if err != nil {
-			return nil, 0, err
-		}
-		req.Header.Set("Content-Type", "application/json")
-		if b.token != "" {
-			req.Header.Set("Authorization", "Bearer "+b.token)
-		}
-		resp, err := b.client.Do(req)
-		if err != nil {
-			return nil, 0, err
-		}
-		if resp.StatusCode >= 300 {
-			resp.Body.Close()
-			return nil, 0, &HTTPError{Code: resp.StatusCode, Status: fmt.Sprintf("embed failed: %s", resp.Status)}
+	if len(inputs) == 0 {
+		return nil, 0, nil
+	}
+	body, _ := json.Marshal(map[string]any{"inputs": inputs})
+	headers := map[string]string{"Content-Type": "application/json"}
+	if b.token != "" {
+		headers["Authorization"] = "Bearer " + b.token
+	}
+	resp, err := postWithRetry(ctx, b.client, b.endpoint, body, headers)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, 0, &HTTPError{Code: resp.StatusCode, Status: fmt.Sprintf("embed failed: %s", resp.Status)}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	vecs, err := decodeBGE(data, len(inputs))
+	if err != nil {
+		return nil, 0, err
+	}
+	b.dim.learn(len(vecs[0]))
+	return vecs, 0, nil
+}
+
+// decodeBGE parses the embeddings of n inputs. Batches are answered with an
+// array of vectors; for a single input some services return the bare vector
+// or {"embedding": [...]} instead.
+func decodeBGE(data []byte, n int) ([][]float32, error) {
+	var vecs [][]float32
+	if err := json.Unmarshal(data, &vecs); err == nil && len(vecs) > 0 {
+		if len(vecs) != n {
+			return nil, fmt.Errorf("embedding count mismatch: got %d, want %d", len(vecs), n)
 		}
-		data, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return nil, 0, err
+		return vecs, nil
+	}
+	if n == 1 {
+		var vec []float32
+		if err := json.Unmarshal(data, &vec); err == nil && len(vec) > 0 {
+			return [][]float32{vec}, nil
 		}
-
 		var out struct {
 			Embedding []float32 `json:"embedding"`
 		}
-		if err := json.Unmarshal(data, &out); err != nil || len(out.Embedding) == 0 {
-			// try raw array format
-			var arr []float32
-			if err := json.Unmarshal(data, &arr); err != nil || len(arr) == 0 {
-				// some services return [[..]] even for single input
-				var arr2 [][]float32
-				if err := json.Unmarshal(data, &arr2); err != nil || len(arr2) == 0 {
-					return nil, 0, err
-				}
-				arr = arr2[0]
-			}
-			out.Embedding = arr
-		}
-
-		if b.dim == 0 {
-			b.dim = len(out.Embedding)
+		if err := json.Unmarshal(data, &out); err == nil && len(out.Embedding) > 0 {
+			return [][]float32{out.Embedding}, nil
 		}
-		vecs[i] = out.Embedding
 	}
-	return vecs, 0, nil
+	return nil, fmt.Errorf("decode embeddings: unexpected response %.100q", data)
 }
@@ -2,8 +2,10 @@ package embed
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 )
 
@@ -57,3 +59,72 @@ func TestBGEEmbedNestedArray(t *testing.T) {
 		t.Fatalf("unexpected embedding: %#v", vecs)
 	}
 }
+
+func TestBGEEmbedBatch(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var in struct {
+			Inputs []string `json:"inputs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		vecs := make([][]float32, len(in.Inputs))
+		for i := range vecs {
+			vecs[i] = []float32{float32(i), 1, 2}
+		}
+		json.NewEncoder(w).Encode(vecs)
+	}))
+	defer srv.Close()
+
+	emb := NewBGE(srv.URL, "", 0)
+	vecs, _, err := emb.Embed(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("sent %d requests, want 1", requests)
+	}
+	if len(vecs) != 3 || vecs[2][0] != 2 || emb.Dimension() != 3 {
+		t.Fatalf("unexpected embeddings %v, dimension %d", vecs, emb.Dimension())
+	}
+}
+
+func TestBGEEmbedCountMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[[0.1,0.2]]`))
+	}))
+	defer srv.Close()
+
+	if _, _, err := NewBGE(srv.URL, "", 0).Embed(context.Background(), []string{"a", "b"}); err == nil {
+		t.Fatal("expected an error for a short response")
+	}
+}
+
+// TestEmbedDimensionConcurrent learns the dimension from concurrent Embed
+// calls; run it with -race.
+func TestEmbedDimensionConcurrent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"embeddings":[[0.1,0.2]],"embedding":[0.1,0.2]}`))
+	}))
+	defer srv.Close()
+
+	for name, emb := range map[string]Embedder{"bge": NewBGE(srv.URL, "", 0), "ollama": NewOllama(srv.URL, "m", 0)} {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := emb.Embed(context.Background(), []string{"x"}); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+				emb.Dimension()
+			}()
+		}
+		wg.Wait()
+		if emb.Dimension() != 2 {
+			t.Fatalf("%s: dimension %d, want 2", name, emb.Dimension())
+		}
+	}
+}
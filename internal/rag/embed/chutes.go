@@ -6,29 +6,29 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 )
 
 // Chutes implements the Embedder interface for Chutes embedding services.
 type Chutes struct {
 	endpoint string
 	token    string
-	dim      int
+	dim      dimension
 	client   *http.Client
 }
 
 // NewChutes creates a new Chutes embedder.
 func NewChutes(endpoint, token string, dim int) *Chutes {
-	return &Chutes{
+	c := &Chutes{
 		endpoint: endpoint,
 		token:    token,
-		dim:      dim,
-		client:   &http.Client{Timeout: 30 * time.Second},
+		client:   &http.Client{},
 	}
+	c.dim.learn(dim)
+	return c
 }
 
 // Dimension returns the embedding dimension if known.
-func (c *Chutes) Dimension() int { return c.dim }
+func (c *Chutes) Dimension() int { return c.dim.get() }
 
 // Embed posts texts to the Chutes embedding endpoint.
 func (c *Chutes) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
@@ -65,8 +65,8 @@ func (c *Chutes) Embed(ctx context.Context, inputs []string) ([][]float32, int,
 	if len(out.Data) != len(inputs) {
 		return nil, 0, fmt.Errorf("embedding count mismatch")
 	}
-	if c.dim == 0 && len(out.Data) > 0 {
-		c.dim = len(out.Data[0])
+	if len(out.Data) > 0 {
+		c.dim.learn(len(out.Data[0]))
 	}
 	return out.Data, 0, nil
 }
@@ -1,9 +1,33 @@
 package embed
 
-import "context"
+import (
+	"context"
+	"sync/atomic"
+)
 
-// Embedder defines embedding operations.
+// Embedder defines embedding operations. The HTTP embedders have no client
+// timeout of their own; requests are bounded by ctx, and New adds the
+// configured per-endpoint timeout. Embed and Dimension may be called
+// concurrently.
 type Embedder interface {
 	Embed(ctx context.Context, inputs []string) ([][]float32, int, error)
 	Dimension() int
 }
+
+// dimension is the vector size of an HTTP embedder: the configured one, or
+// else the size of the first vector it receives. Concurrent Embed calls
+// record it safely.
+type dimension struct {
+	n atomic.Int64
+}
+
+// get returns the dimension, 0 while it is unknown.
+func (d *dimension) get() int { return int(d.n.Load()) }
+
+// learn records n unless the dimension is already known or n is not
+// positive.
+func (d *dimension) learn(n int) {
+	if n > 0 {
+		d.n.CompareAndSwap(0, int64(n))
+	}
+}
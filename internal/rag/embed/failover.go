@@ -0,0 +1,83 @@
+package embed
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"rag-server/internal/rag/breaker"
+)
+
+// DefaultTimeout bounds each embedding request when no timeout is
+// configured.
+const DefaultTimeout = 30 * time.Second
+
+// Failover sends each request to the first endpoint whose circuit breaker
+// admits it. A request that times out, cannot connect, is rate limited or
+// gets a server error moves on to the next endpoint; other errors are
+// returned as they are.
+type Failover struct {
+	targets []failoverTarget
+	timeout time.Duration
+	waited  atomic.Int64
+}
+
+type failoverTarget struct {
+	emb Embedder
+	br  *breaker.Breaker
+	// lim, when set, paces the requests to this endpoint.
+	lim *Limiter
+}
+
+// Embed embeds inputs with the first healthy endpoint. Each attempt waits
+// for the endpoint's rate limiter, then is bounded by the failover timeout.
+func (f *Failover) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	var lastErr error
+	for _, t := range f.targets {
+		if err := t.br.Allow(); err != nil {
+			lastErr = err
+			continue
+		}
+		estimate := 0
+		if t.lim != nil {
+			estimate = EstimateTokens(inputs)
+			d, err := t.lim.Wait(ctx, estimate)
+			f.waited.Add(int64(d))
+			if err != nil {
+				// The caller gave up before the endpoint was contacted,
+				// which says nothing about its health.
+				t.br.Report(context.Canceled)
+				return nil, 0, err
+			}
+		}
+		actx, cancel := context.WithTimeout(ctx, f.timeout)
+		vecs, n, err := t.emb.Embed(actx, inputs)
+		cancel()
+		t.br.Report(err)
+		if err == nil {
+			if t.lim != nil && n > 0 {
+				t.lim.Adjust(n - estimate)
+			}
+			return vecs, n, nil
+		}
+		if ctx.Err() != nil || !breaker.Failure(err) {
+			return nil, 0, err
+		}
+		lastErr = err
+	}
+	return nil, 0, lastErr
+}
+
+// RateLimitWait returns the total time spent waiting for the endpoints' rate
+// limiters.
+func (f *Failover) RateLimitWait() time.Duration { return time.Duration(f.waited.Load()) }
+
+// Dimension returns the first dimension known by an endpoint's embedder.
+func (f *Failover) Dimension() int {
+	for _, t := range f.targets {
+		if d := t.emb.Dimension(); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,149 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"rag-server/internal/rag/breaker"
+	"rag-server/internal/rag/config"
+)
+
+func TestFailover(t *testing.T) {
+	var slowCalls, goodCalls atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer slow.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goodCalls.Add(1)
+		w.Write([]byte(`{"data":[{"embedding":[1,0]}],"usage":{"total_tokens":3}}`))
+	}))
+	defer good.Close()
+
+	emb, err := New(config.RuntimeEmbedding{
+		Provider:  "openai",
+		Endpoints: []string{slow.URL, good.URL},
+		Timeout:   20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < breaker.DefaultFailures+2; i++ {
+		vecs, tokens, err := emb.Embed(context.Background(), []string{"x"})
+		if err != nil || len(vecs) != 1 || tokens != 3 {
+			t.Fatalf("Embed = %v, %d, %v", vecs, tokens, err)
+		}
+	}
+	if n := slowCalls.Load(); n != breaker.DefaultFailures {
+		t.Fatalf("slow endpoint called %d times, want %d before its breaker opened", n, breaker.DefaultFailures)
+	}
+	if n := goodCalls.Load(); n != breaker.DefaultFailures+2 {
+		t.Fatalf("good endpoint called %d times", n)
+	}
+	st := breaker.Get("embedder openai " + slow.URL).Status()
+	if st.State != breaker.Open || st.RetryAt == nil {
+		t.Fatalf("unexpected breaker status %+v", st)
+	}
+}
+
+func TestFailoverClientError(t *testing.T) {
+	var calls atomic.Int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad input", http.StatusBadRequest)
+	}))
+	defer bad.Close()
+	emb, err := New(config.RuntimeEmbedding{Provider: "openai", Endpoints: []string{bad.URL, bad.URL + "/other"}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	_, _, err = emb.Embed(context.Background(), []string{"x"})
+	var herr *HTTPError
+	if !errors.As(err, &herr) || herr.Code != http.StatusBadRequest || calls.Load() != 1 {
+		t.Fatalf("expected the 400 without failover, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestFailoverRateLimitPerEndpoint(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"embedding":[1,0]}],"usage":{"total_tokens":3}}`))
+	}))
+	defer fallback.Close()
+
+	emb, err := New(config.RuntimeEmbedding{
+		Provider:     "openai",
+		Endpoints:    []string{primary.URL, fallback.URL},
+		RateLimitTPM: 600,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// The primary is throttled for minutes and its breaker is open, so
+	// requests go to the fallback, which has a budget of its own.
+	SharedLimiter(primary.URL, 600).Adjust(6000)
+	br := breaker.Get("embedder openai " + primary.URL)
+	for i := 0; i < breaker.DefaultFailures; i++ {
+		br.Report(&HTTPError{Code: http.StatusServiceUnavailable})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, tokens, err := emb.Embed(ctx, []string{"x"}); err != nil || tokens != 3 {
+		t.Fatalf("Embed = %d, %v", tokens, err)
+	}
+	lim := SharedLimiter(fallback.URL, 600)
+	if lim.tokens != 597 {
+		t.Fatalf("fallback budget = %v, want 597 after the reported usage", lim.tokens)
+	}
+	// Emptying the fallback's budget makes the next request wait for it.
+	lim.Adjust(int(lim.tokens))
+	if _, _, err := emb.Embed(ctx, []string{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	w, ok := emb.(interface{ RateLimitWait() time.Duration })
+	if !ok || w.RateLimitWait() <= 0 {
+		t.Fatal("expected the fallback's rate limit wait to be reported")
+	}
+}
+
+func TestFailoverRateLimitCancelHalfOpen(t *testing.T) {
+	br := breaker.New("embedder test half-open", 1, time.Millisecond)
+	br.Allow()
+	br.Report(&HTTPError{Code: http.StatusServiceUnavailable})
+	time.Sleep(2 * time.Millisecond)
+	lim := NewLimiter(60)
+	lim.Adjust(600)
+	f := &Failover{
+		targets: []failoverTarget{{emb: &lengthEmbedder{}, br: br, lim: lim}},
+		timeout: time.Second,
+	}
+
+	// The probe is admitted but cancelled while waiting for the limiter.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := f.Embed(ctx, []string{"x"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the limiter wait to time out, got %v", err)
+	}
+	if st := br.Status(); st.State != breaker.HalfOpen || st.Failures != 1 {
+		t.Fatalf("the cancelled wait should leave the breaker as it was, got %+v", st)
+	}
+	lim.Adjust(-1000)
+	if _, _, err := f.Embed(context.Background(), []string{"x"}); err != nil {
+		t.Fatalf("endpoint did not recover: %v", err)
+	}
+	if st := br.Status(); st.State != breaker.Closed {
+		t.Fatalf("expected a closed breaker after a successful probe, got %+v", st)
+	}
+}
@@ -3,16 +3,24 @@ package embed
 import (
 	"bytes"
 	"context"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-// postWithRetry sends an HTTP POST request with basic retry and backoff for
-// rate limiting or transient server errors.
+const (
+	// retryAttempts is the number of tries postWithRetry makes.
+	retryAttempts = 3
+	// maxRetryAfter caps the delay requested by a Retry-After header.
+	maxRetryAfter = time.Minute
+)
+
+// postWithRetry sends an HTTP POST request and retries rate limited (429) and
+// transient server (5xx) responses. It waits as long as a Retry-After header
+// asks, or backs off exponentially from one second, with random jitter.
 func postWithRetry(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string) (*http.Response, error) {
-	var resp *http.Response
-	var err error
-	for i := 0; i < 3; i++ {
+	for i := 0; ; i++ {
 		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 		if err != nil {
 			return nil, err
@@ -20,24 +28,65 @@ func postWithRetry(ctx context.Context, client *http.Client, url string, body []
 		for k, v := range headers {
 			req.Header.Set(k, v)
 		}
-		resp, err = client.Do(req)
-		if err != nil {
-			if i == 2 {
+		resp, err := client.Do(req)
+		last := i == retryAttempts-1
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if last || ctx.Err() != nil {
 				return nil, err
 			}
-		} else if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
-			if i == 2 {
+			wait = backoff(i, 0, false)
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			if last {
 				return resp, nil
 			}
+			after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
 			resp.Body.Close()
-		} else {
+			wait = backoff(i, after, ok)
+		default:
 			return resp, nil
 		}
+		t := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
+			t.Stop()
 			return nil, ctx.Err()
-		case <-time.After(time.Duration(1<<i) * time.Second):
+		case <-t.C:
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt+1. A server supplied delay
+// is honoured with up to 10% jitter added; otherwise the delay doubles from
+// one second per attempt and is jittered by ±50%.
+func backoff(attempt int, after time.Duration, ok bool) time.Duration {
+	if ok {
+		if after > maxRetryAfter {
+			after = maxRetryAfter
+		}
+		return after + time.Duration(rand.Int64N(int64(after/10)+1))
+	}
+	base := time.Duration(1<<attempt) * time.Second
+	return base/2 + time.Duration(rand.Int64N(int64(base)))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
 		}
+		return 0, true
 	}
-	return resp, err
+	return 0, false
 }
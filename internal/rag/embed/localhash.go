@@ -0,0 +1,91 @@
+package embed
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// DefaultLocalHashDimension is the dimension of LocalHash vectors when
+// embedding.dimension is not set.
+const DefaultLocalHashDimension = 256
+
+// LocalHash embeds texts in process by feature hashing their character
+// bigrams and trigrams into a fixed number of buckets. The vectors are
+// deterministic and need no model, so texts sharing wording end up close to
+// each other. It is meant for offline development and tests, not for
+// semantic search quality.
+type LocalHash struct {
+	dim int
+}
+
+// NewLocalHash returns a LocalHash embedder producing vectors of dimension
+// dim, or DefaultLocalHashDimension when dim is not positive.
+func NewLocalHash(dim int) *LocalHash {
+	if dim <= 0 {
+		dim = DefaultLocalHashDimension
+	}
+	return &LocalHash{dim: dim}
+}
+
+// Dimension returns the vector dimension.
+func (l *LocalHash) Dimension() int { return l.dim }
+
+// Embed returns one L2-normalized vector per input. The reported token usage
+// is always zero.
+func (l *LocalHash) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	out := make([][]float32, len(inputs))
+	for i, text := range inputs {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		out[i] = l.vector(text)
+	}
+	return out, 0, nil
+}
+
+func (l *LocalHash) vector(text string) []float32 {
+	// Lower-case the text and collapse whitespace and punctuation into
+	// single spaces so that formatting does not change the vector.
+	runes := []rune{' '}
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			runes = append(runes, r)
+		} else if runes[len(runes)-1] != ' ' {
+			runes = append(runes, ' ')
+		}
+	}
+	if runes[len(runes)-1] != ' ' {
+		runes = append(runes, ' ')
+	}
+
+	vec := make([]float32, l.dim)
+	h := fnv.New64a()
+	for n := 2; n <= 3; n++ {
+		for i := 0; i+n <= len(runes); i++ {
+			h.Reset()
+			h.Write([]byte(string(runes[i : i+n])))
+			sum := h.Sum64()
+			// The top bit picks the sign so that collisions tend to
+			// cancel out instead of piling up.
+			if sum>>63 == 1 {
+				vec[sum%uint64(l.dim)]--
+			} else {
+				vec[sum%uint64(l.dim)]++
+			}
+		}
+	}
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vec {
+			vec[i] *= scale
+		}
+	}
+	return vec
+}
@@ -0,0 +1,65 @@
+package embed
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"rag-server/internal/rag/config"
+)
+
+func TestLocalHash(t *testing.T) {
+	emb, err := New(config.RuntimeEmbedding{Provider: "local-hash", Dimension: 64})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if emb.Dimension() != 64 {
+		t.Fatalf("unexpected dimension %d", emb.Dimension())
+	}
+	texts := []string{
+		"Deploy the rag-server with make deploy.",
+		"deploy   the RAG server, with make deploy",
+		"备份数据库",
+		"",
+	}
+	vecs, _, err := emb.Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	dot := func(a, b []float32) float64 {
+		s := 0.0
+		for i := range a {
+			s += float64(a[i]) * float64(b[i])
+		}
+		return s
+	}
+	for i, v := range vecs[:3] {
+		if n := dot(v, v); math.Abs(n-1) > 1e-5 {
+			t.Fatalf("vector %d not normalized: %v", i, n)
+		}
+	}
+	// Case, punctuation and spacing do not matter.
+	if s := dot(vecs[0], vecs[1]); s < 0.99 {
+		t.Fatalf("expected near identical vectors, similarity %v", s)
+	}
+	if s := dot(vecs[0], vecs[2]); s > 0.5 {
+		t.Fatalf("expected unrelated texts to differ, similarity %v", s)
+	}
+	again, _, _ := emb.Embed(context.Background(), texts[:1])
+	for i := range again[0] {
+		if again[0][i] != vecs[0][i] {
+			t.Fatal("embedding is not deterministic")
+		}
+	}
+}
+
+func TestNewNoEndpoint(t *testing.T) {
+	for _, p := range []string{"openai", "ollama", "chutes", "bge", ""} {
+		if _, err := New(config.RuntimeEmbedding{Provider: p}); err != ErrNoEndpoint {
+			t.Errorf("New(%q) error = %v, want ErrNoEndpoint", p, err)
+		}
+	}
+	if emb, err := New(config.RuntimeEmbedding{Provider: "local-hash"}); err != nil || emb.Dimension() != DefaultLocalHashDimension {
+		t.Fatalf("New(local-hash) = %v, %v", emb, err)
+	}
+}
@@ -1,76 +1,97 @@
 package embed
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"time"
 )
 
-// Ollama implements the Embedder interface using the Ollama embeddings API.
+// Ollama implements the Embedder interface using the Ollama batch embed API.
 type Ollama struct {
 	endpoint string
+	legacy   string
 	model    string
-	dim      int
+	dim      dimension
 	client   *http.Client
 }
 
-// NewOllama creates a new Ollama embedder.
+// NewOllama creates a new Ollama embedder. endpoint may be the server base
+// URL (e.g. http://localhost:11434), the /api/embed URL or the legacy
+// /api/embeddings URL.
 func NewOllama(endpoint, model string, dim int) *Ollama {
-	return &Ollama{
-		endpoint: strings.TrimRight(endpoint, "/"),
+	base := strings.TrimRight(endpoint, "/")
+	base = strings.TrimSuffix(base, "/api/embeddings")
+	base = strings.TrimSuffix(base, "/api/embed")
+	a := &Ollama{
+		endpoint: base + "/api/embed",
+		legacy:   base + "/api/embeddings",
 		model:    model,
-		dim:      dim,
-		client:   &http.Client{Timeout: 30 * time.Second},
+		client:   &http.Client{},
 	}
+	a.dim.learn(dim)
+	return a
 }
 
 // Dimension returns the embedding dimension if known.
-func (a *Ollama) Dimension() int { return a.dim }
+func (a *Ollama) Dimension() int { return a.dim.get() }
 
-// Embed posts texts to the Ollama embeddings endpoint.
+// Embed posts all texts to /api/embed in one request. Servers older than
+// Ollama 0.3 lack that endpoint; for them each text is posted to
+// /api/embeddings.
 func (a *Ollama) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	var out struct {
+		Embeddings      [][]float32 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	err := a.post(ctx, a.endpoint, map[string]any{"model": a.model, "input": inputs}, &out)
+	var herr *HTTPError
+	if errors.As(err, &herr) && herr.Code == http.StatusNotFound {
+		return a.embedLegacy(ctx, inputs)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(out.Embeddings) != len(inputs) {
+		return nil, 0, fmt.Errorf("embedding count mismatch: got %d, want %d", len(out.Embeddings), len(inputs))
+	}
+	if len(out.Embeddings) > 0 {
+		a.dim.learn(len(out.Embeddings[0]))
+	}
+	return out.Embeddings, out.PromptEvalCount, nil
+}
+
+func (a *Ollama) embedLegacy(ctx context.Context, inputs []string) ([][]float32, int, error) {
 	vecs := make([][]float32, len(inputs))
-	url := a.endpoint
 	for i, text := range inputs {
-		payload := map[string]any{"model": a.model, "prompt": text}
-		body, _ := json.Marshal(payload)
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-		if err != nil {
-			return nil, 0, err
-		}
-		req.Header.Set("Content-Type", "application/json")
-		resp, err := a.client.Do(req)
-		if err != nil {
-			return nil, 0, err
-		}
-		if resp.StatusCode >= 300 {
-			resp.Body.Close()
-			return nil, 0, &HTTPError{Code: resp.StatusCode, Status: fmt.Sprintf("embed failed: %s", resp.Status)}
-		}
-		data, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return nil, 0, err
-		}
 		var out struct {
-			Embedding []float64 `json:"embedding"`
+			Embedding []float32 `json:"embedding"`
 		}
-		if err := json.Unmarshal(data, &out); err != nil {
+		if err := a.post(ctx, a.legacy, map[string]any{"model": a.model, "prompt": text}, &out); err != nil {
 			return nil, 0, err
 		}
-		if a.dim == 0 {
-			a.dim = len(out.Embedding)
-		}
-		vec := make([]float32, len(out.Embedding))
-		for j, v := range out.Embedding {
-			vec[j] = float32(v)
-		}
-		vecs[i] = vec
+		a.dim.learn(len(out.Embedding))
+		vecs[i] = out.Embedding
 	}
 	return vecs, 0, nil
 }
+
+func (a *Ollama) post(ctx context.Context, url string, payload any, out any) error {
+	body, _ := json.Marshal(payload)
+	resp, err := postWithRetry(ctx, a.client, url, body, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &HTTPError{Code: resp.StatusCode, Status: fmt.Sprintf("embed failed: %s", resp.Status)}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
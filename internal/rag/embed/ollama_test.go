@@ -0,0 +1,59 @@
+package embed
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllamaBatchEmbed(t *testing.T) {
+	var got struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"embeddings":[[0.1,0.2],[0.3,0.4]],"prompt_eval_count":7}`))
+	}))
+	defer srv.Close()
+
+	// The legacy endpoint in the config is mapped to the batch endpoint.
+	emb := NewOllama(srv.URL+"/api/embeddings", "bge-m3", 0)
+	vecs, tokens, err := emb.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if got.Model != "bge-m3" || len(got.Input) != 2 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	if len(vecs) != 2 || vecs[1][0] != 0.3 || tokens != 7 || emb.Dimension() != 2 {
+		t.Fatalf("unexpected result %v %d dim %d", vecs, tokens, emb.Dimension())
+	}
+}
+
+func TestOllamaLegacyFallback(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/embed" {
+			http.NotFound(w, r)
+			return
+		}
+		calls++
+		w.Write([]byte(`{"embedding":[0.5,0.6,0.7]}`))
+	}))
+	defer srv.Close()
+
+	emb := NewOllama(srv.URL, "m", 0)
+	vecs, _, err := emb.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if calls != 2 || len(vecs) != 2 || len(vecs[0]) != 3 {
+		t.Fatalf("unexpected fallback result %v after %d calls", vecs, calls)
+	}
+}
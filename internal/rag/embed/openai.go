@@ -8,7 +8,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"time"
 )
 
 // OpenAI implements the Embedder interface using OpenAI-compatible APIs.
@@ -16,23 +15,24 @@ type OpenAI struct {
 	endpoint string
 	apiKey   string
 	model    string
-	dim      int
+	dim      dimension
 	client   *http.Client
 }
 
 // NewOpenAI creates a new OpenAI embedder from configuration.
 func NewOpenAI(endpoint, apiKey, model string, dim int) *OpenAI {
-	return &OpenAI{
+	o := &OpenAI{
 		endpoint: endpoint,
 		apiKey:   apiKey,
 		model:    model,
-		dim:      dim,
-		client:   &http.Client{Timeout: 30 * time.Second},
+		client:   &http.Client{},
 	}
+	o.dim.learn(dim)
+	return o
 }
 
 // Dimension returns the embedding dimension if known.
-func (o *OpenAI) Dimension() int { return o.dim }
+func (o *OpenAI) Dimension() int { return o.dim.get() }
 
 // Embed embeds the inputs and returns vectors and token usage.
 func (o *OpenAI) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
@@ -72,8 +72,8 @@ func (o *OpenAI) Embed(ctx context.Context, inputs []string) ([][]float32, int,
 	if len(out.Data) != len(inputs) {
 		return nil, 0, errors.New("embedding count mismatch")
 	}
-	if o.dim == 0 && len(out.Data) > 0 {
-		o.dim = len(out.Data[0].Embedding)
+	if len(out.Data) > 0 {
+		o.dim.learn(len(out.Data[0].Embedding))
 	}
 	vecs := make([][]float32, len(out.Data))
 	for i, d := range out.Data {
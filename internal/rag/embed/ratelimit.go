@@ -0,0 +1,114 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Limiter is a token bucket that spreads embedding requests over a
+// tokens-per-minute budget. It holds up to one minute of tokens. A request
+// larger than the remaining budget puts the bucket into debt and later
+// callers wait until the debt is repaid.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLimiter returns a limiter allowing tpm tokens per minute.
+func NewLimiter(tpm int) *Limiter {
+	l := &Limiter{rate: float64(tpm) / 60, burst: float64(tpm), now: time.Now}
+	l.tokens = l.burst
+	l.last = l.now()
+	return l
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*Limiter{}
+)
+
+// SharedLimiter returns the process-wide limiter for endpoint, so that every
+// embedder talking to one provider endpoint shares its budget.
+func SharedLimiter(endpoint string, tpm int) *Limiter {
+	key := fmt.Sprintf("%s|%d", endpoint, tpm)
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	l, ok := limiters[key]
+	if !ok {
+		l = NewLimiter(tpm)
+		limiters[key] = l
+	}
+	return l
+}
+
+// reserve takes n tokens and returns how long the caller has to wait before
+// using them.
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until n tokens are available and returns the time spent
+// waiting. If ctx ends first the tokens are returned to the bucket.
+func (l *Limiter) Wait(ctx context.Context, n int) (time.Duration, error) {
+	d := l.reserve(n)
+	if d <= 0 {
+		return 0, nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return d, nil
+	case <-ctx.Done():
+		l.Adjust(-n)
+		return 0, ctx.Err()
+	}
+}
+
+// Adjust corrects the bucket by delta tokens once the provider has reported
+// the actual usage of a request that was estimated.
+func (l *Limiter) Adjust(delta int) {
+	l.mu.Lock()
+	l.tokens -= float64(delta)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.mu.Unlock()
+}
+
+// EstimateTokens approximates the tokens of texts: one per CJK character and
+// one per four other characters.
+func EstimateTokens(texts []string) int {
+	total := 0
+	for _, text := range texts {
+		other := 0
+		for _, r := range text {
+			if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+				total++
+			} else {
+				other++
+			}
+		}
+		total += (other + 3) / 4
+	}
+	return total
+}
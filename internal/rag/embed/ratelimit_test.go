@@ -0,0 +1,168 @@
+package embed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"rag-server/internal/rag/breaker"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(600) // 10 tokens per second
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	if d := l.reserve(600); d != 0 {
+		t.Fatalf("full bucket should not wait, got %s", d)
+	}
+	if d := l.reserve(50); d != 5*time.Second {
+		t.Fatalf("expected 5s wait, got %s", d)
+	}
+	now = now.Add(10 * time.Second)
+	// 100 tokens refilled, 50 of them repay the debt.
+	if d := l.reserve(50); d != 0 {
+		t.Fatalf("expected no wait after refill, got %s", d)
+	}
+	// The provider reported 30 tokens more than estimated.
+	l.Adjust(30)
+	if d := l.reserve(0); d != 3*time.Second {
+		t.Fatalf("expected 3s wait after adjustment, got %s", d)
+	}
+	now = now.Add(time.Hour)
+	l.reserve(0)
+	if l.tokens != l.burst {
+		t.Fatalf("bucket should be capped at %v, got %v", l.burst, l.tokens)
+	}
+}
+
+func TestLimiterWaitCancel(t *testing.T) {
+	l := NewLimiter(60)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Wait(ctx, 120); err == nil {
+		t.Fatal("expected context error")
+	}
+	if l.tokens < 59 {
+		t.Fatalf("tokens should be returned on cancel, got %v", l.tokens)
+	}
+}
+
+func TestSharedLimiter(t *testing.T) {
+	a := SharedLimiter("http://shared.test/embed", 1000)
+	if b := SharedLimiter("http://shared.test/embed", 1000); a != b {
+		t.Fatal("expected the same limiter for one endpoint")
+	}
+	if c := SharedLimiter("http://other.test/embed", 1000); a == c {
+		t.Fatal("expected separate limiters per endpoint")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	cases := []struct {
+		texts []string
+		want  int
+	}{
+		{nil, 0},
+		{[]string{"abcd"}, 1},
+		{[]string{"abcde"}, 2},
+		{[]string{"部署服务"}, 4},
+		{[]string{"部署 rag", "ab"}, 4},
+	}
+	for _, c := range cases {
+		if got := EstimateTokens(c.texts); got != c.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", c.texts, got, c.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"7", 7 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+	}
+	for _, c := range cases {
+		got, ok := retryAfter(c.in, now)
+		if got != c.want || ok != c.ok {
+			t.Errorf("retryAfter(%q) = %s, %v; want %s, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := backoff(1, 0, false); d < time.Second || d >= 3*time.Second {
+			t.Fatalf("exponential backoff out of range: %s", d)
+		}
+		if d := backoff(0, 10*time.Second, true); d < 10*time.Second || d > 11*time.Second {
+			t.Fatalf("Retry-After backoff out of range: %s", d)
+		}
+		if d := backoff(0, time.Hour, true); d > maxRetryAfter+maxRetryAfter/10 {
+			t.Fatalf("Retry-After should be capped, got %s", d)
+		}
+	}
+}
+
+func TestPostWithRetryHonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := postWithRetry(context.Background(), srv.Client(), srv.URL, []byte("{}"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("status %d after %d calls", resp.StatusCode, calls.Load())
+	}
+	// Without Retry-After the first retry waits at least half a second.
+	if el := time.Since(start); el > 400*time.Millisecond {
+		t.Fatalf("Retry-After: 0 should retry immediately, took %s", el)
+	}
+}
+
+func TestFailoverRateLimitWait(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(60000)
+	l.now = func() time.Time { return now }
+	l.last = now
+	l.tokens = 0
+	f := &Failover{
+		targets: []failoverTarget{{emb: &lengthEmbedder{}, br: breaker.New("embedder test rate limit", 0, 0), lim: l}},
+		timeout: time.Second,
+	}
+	b := NewBatcher(f, BatchOptions{MaxBatch: 1, Concurrency: 1})
+	// Each text is estimated at 10 tokens and the clock stands still, so at
+	// 1000 tokens per second the first request waits 10ms. The provider
+	// reports 1 token, which refunds 9, so the second request waits 11ms.
+	text := strings.Repeat("x", 40)
+	if _, _, err := b.Embed(context.Background(), []string{text, text}); err != nil {
+		t.Fatal(err)
+	}
+	if w := b.RateLimitWait(); w != 21*time.Millisecond {
+		t.Fatalf("expected 21ms wait, got %s", w)
+	}
+}
@@ -0,0 +1,153 @@
+package embed
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"rag-server/internal/rag/breaker"
+	"rag-server/internal/rag/config"
+)
+
+// Factory builds an Embedder from resolved embedding configuration.
+type Factory func(cfg config.RuntimeEmbedding) (Embedder, error)
+
+// ErrUnknownProvider is returned by New when no factory is registered for the
+// configured provider.
+var ErrUnknownProvider = errors.New("unknown embedding provider")
+
+// ErrNoEndpoint is returned by New when the configured provider needs an
+// endpoint and none is set.
+var ErrNoEndpoint = errors.New("embedding endpoint not configured")
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+func init() {
+	Register("ollama", remote(func(c config.RuntimeEmbedding) Embedder {
+		return NewOllama(c.Endpoint, c.Model, c.Dimension)
+	}))
+	Register("chutes", remote(func(c config.RuntimeEmbedding) Embedder {
+		return NewChutes(c.Endpoint, c.APIKey, c.Dimension)
+	}))
+	Register("openai", remote(func(c config.RuntimeEmbedding) Embedder {
+		return NewOpenAI(c.Endpoint, c.APIKey, c.Model, c.Dimension)
+	}))
+	Register("bge", remote(func(c config.RuntimeEmbedding) Embedder {
+		return NewBGE(c.Endpoint, c.APIKey, c.Dimension)
+	}))
+	Register("local-hash", func(c config.RuntimeEmbedding) (Embedder, error) {
+		return NewLocalHash(c.Dimension), nil
+	})
+}
+
+// remote wraps the constructor of an HTTP embedder into a Factory that
+// returns ErrNoEndpoint when no endpoint is configured.
+func remote(build func(config.RuntimeEmbedding) Embedder) Factory {
+	return func(c config.RuntimeEmbedding) (Embedder, error) {
+		if c.Endpoint == "" {
+			return nil, ErrNoEndpoint
+		}
+		return build(c), nil
+	}
+}
+
+// Register makes an embedder factory available under name. Names are case
+// insensitive. Register panics if name is empty, f is nil or the name is
+// already taken, so it is meant to be called from init functions.
+func Register(name string, f Factory) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if key == "" || f == nil {
+		panic("embed: Register needs a name and a factory")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[key]; dup {
+		panic("embed: Register called twice for provider " + key)
+	}
+	registry[key] = f
+}
+
+// Providers returns the registered provider names in sorted order.
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New constructs the embedder for cfg.Provider, wrapped in a Batcher that
+// applies the batch size, character limit and concurrency from cfg. An empty
+// provider selects "openai" when a model is configured and "bge" otherwise.
+// With endpoints configured, requests go through a Failover over
+// cfg.Endpoints, each guarded by a circuit breaker and paced by its own
+// tokens-per-minute limiter.
+func New(cfg config.RuntimeEmbedding) (Embedder, error) {
+	name := providerName(cfg)
+	registryMu.RLock()
+	f, ok := registry[name]
+	registryMu.RUnlock()
+	var err error
+	if !ok {
+		return nil, fmt.Errorf("%w %q (registered: %s)", ErrUnknownProvider, cfg.Provider, strings.Join(Providers(), ", "))
+	}
+	var emb Embedder
+	if len(cfg.Endpoints) == 0 && cfg.Endpoint != "" {
+		cfg.Endpoints = []string{cfg.Endpoint}
+	}
+	if len(cfg.Endpoints) == 0 {
+		if emb, err = f(cfg); err != nil {
+			return nil, err
+		}
+	} else {
+		fo := &Failover{timeout: cfg.Timeout}
+		if fo.timeout <= 0 {
+			fo.timeout = DefaultTimeout
+		}
+		for _, ep := range cfg.Endpoints {
+			c := cfg
+			c.Endpoint = ep
+			e, err := f(c)
+			if err != nil {
+				return nil, err
+			}
+			t := failoverTarget{emb: e, br: breaker.Get("embedder " + name + " " + ep)}
+			if cfg.RateLimitTPM > 0 {
+				t.lim = SharedLimiter(ep, cfg.RateLimitTPM)
+			}
+			fo.targets = append(fo.targets, t)
+		}
+		emb = fo
+	}
+	return NewBatcher(emb, BatchOptions{
+		MaxBatch:    cfg.MaxBatch,
+		MaxChars:    cfg.MaxChars,
+		Overflow:    cfg.Overflow,
+		Concurrency: cfg.Concurrency,
+	}), nil
+}
+
+// ModelID identifies the vectors New(cfg) produces, for example
+// "ollama:bge-m3". Stored embeddings are reused only for the same ID.
+func ModelID(cfg config.RuntimeEmbedding) string {
+	return providerName(cfg) + ":" + cfg.Model
+}
+
+func providerName(cfg config.RuntimeEmbedding) string {
+	name := strings.ToLower(strings.TrimSpace(cfg.Provider))
+	if name == "" {
+		name = "bge"
+		if cfg.Model != "" {
+			name = "openai"
+		}
+	}
+	return name
+}
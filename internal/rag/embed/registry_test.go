@@ -0,0 +1,92 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"rag-server/internal/rag/config"
+)
+
+type fixedEmbedder struct{ dim int }
+
+func (f fixedEmbedder) Embed(_ context.Context, inputs []string) ([][]float32, int, error) {
+	return make([][]float32, len(inputs)), 0, nil
+}
+
+func (f fixedEmbedder) Dimension() int { return f.dim }
+
+func TestNewProviders(t *testing.T) {
+	cases := []struct {
+		provider, model string
+		want            any
+	}{
+		{"OpenAI", "m", &OpenAI{}},
+		{" Ollama ", "m", &Ollama{}},
+		{"CHUTES", "", &Chutes{}},
+		{"bge", "", &BGE{}},
+		{"", "m", &OpenAI{}},
+		{"", "", &BGE{}},
+	}
+	for _, tc := range cases {
+		emb, err := New(config.RuntimeEmbedding{Provider: tc.provider, Model: tc.model, Endpoint: "http://x"})
+		if err != nil {
+			t.Fatalf("New(%q): %v", tc.provider, err)
+		}
+		b, ok := emb.(*Batcher)
+		if !ok {
+			t.Fatalf("New(%q) = %T, want a *Batcher", tc.provider, emb)
+		}
+		fo, ok := b.inner.(*Failover)
+		if !ok || len(fo.targets) != 1 {
+			t.Fatalf("New(%q) wraps %T, want a *Failover with one endpoint", tc.provider, b.inner)
+		}
+		if got, want := fmt.Sprintf("%T", fo.targets[0].emb), fmt.Sprintf("%T", tc.want); got != want {
+			t.Errorf("New(%q, model %q) = %s, want %s", tc.provider, tc.model, got, want)
+		}
+	}
+}
+
+func TestNewUnknownProvider(t *testing.T) {
+	_, err := New(config.RuntimeEmbedding{Provider: "cohere"})
+	if !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("expected ErrUnknownProvider, got %v", err)
+	}
+}
+
+func TestRegisterCustom(t *testing.T) {
+	Register("Test-Fixed", func(c config.RuntimeEmbedding) (Embedder, error) {
+		return fixedEmbedder{dim: c.Dimension}, nil
+	})
+	emb, err := New(config.RuntimeEmbedding{Provider: "test-fixed", Dimension: 7})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if emb.Dimension() != 7 {
+		t.Fatalf("unexpected dimension %d", emb.Dimension())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected duplicate registration to panic")
+		}
+	}()
+	Register("TEST-FIXED", func(config.RuntimeEmbedding) (Embedder, error) { return nil, nil })
+}
+
+func TestModelID(t *testing.T) {
+	cases := []struct {
+		cfg  config.RuntimeEmbedding
+		want string
+	}{
+		{config.RuntimeEmbedding{Provider: "Ollama", Model: "bge-m3"}, "ollama:bge-m3"},
+		{config.RuntimeEmbedding{Model: "text-embedding-3-small"}, "openai:text-embedding-3-small"},
+		{config.RuntimeEmbedding{}, "bge:"},
+	}
+	for _, c := range cases {
+		if got := ModelID(c.cfg); got != c.want {
+			t.Errorf("ModelID(%+v) = %q, want %q", c.cfg, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"rag-server/internal/rag/store"
+)
+
+// Expansion modes for QueryOptions.Expand.
+const (
+	// ExpandNone returns chunks as stored.
+	ExpandNone = "none"
+	// ExpandNeighbors widens each hit by one window on either side.
+	ExpandNeighbors = "neighbors"
+	// ExpandSection widens each hit to its whole markdown section.
+	ExpandSection = "section"
+)
+
+// DefaultExpandTokens is the token budget of an expanded hit when none is
+// configured.
+const DefaultExpandTokens = 1000
+
+// sectionFetcher loads the stored chunks of one section of a file.
+type sectionFetcher func(ctx context.Context, repo, path string, section int) ([]store.SearchHit, error)
+
+// span locates a chunk within its section.
+type span struct {
+	section, start, end int
+	// size is the window size the chunk was cut with, 0 if unknown.
+	size int
+	// byteStart and byteEnd locate the content within the section text. They
+	// are set, and hasBytes with them, for chunks whose content is a slice of
+	// the section text.
+	byteStart, byteEnd int
+	hasBytes           bool
+}
+
+func chunkSpan(meta map[string]any) (span, bool) {
+	sec, ok1 := metaInt(meta, "section")
+	start, ok2 := metaInt(meta, "token_start")
+	end, ok3 := metaInt(meta, "token_end")
+	if !ok1 || !ok2 || !ok3 || end <= start {
+		return span{}, false
+	}
+	sp := span{section: sec, start: start, end: end}
+	sp.size, _ = metaInt(meta, "size")
+	bs, ok1 := metaInt(meta, "byte_start")
+	be, ok2 := metaInt(meta, "byte_end")
+	if ok1 && ok2 && be >= bs && bs >= 0 {
+		sp.byteStart, sp.byteEnd, sp.hasBytes = bs, be, true
+	}
+	return sp, true
+}
+
+func metaInt(meta map[string]any, key string) (int, bool) {
+	switch v := meta[key].(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// expand replaces the content of each hit with a contiguous token range
+// around it, reconstructed from the section's stored windows. Hits from the
+// same section whose ranges overlap or touch are merged into the
+// best-ranked one. Chunks without section metadata (TOC and heading chunks,
+// or rows ingested before offsets were recorded) are returned unchanged.
+func expand(ctx context.Context, hits []*Document, mode string, budget int, fetch sectionFetcher) ([]*Document, error) {
+	if mode == ExpandNone || mode == "" {
+		return hits, nil
+	}
+	if budget <= 0 {
+		budget = DefaultExpandTokens
+	}
+
+	type group struct {
+		windows []store.SearchHit
+		length  int
+		merged  []*expanded
+	}
+	groups := map[string]*group{}
+	byDoc := map[*Document]*expanded{}
+	out := make([]*Document, 0, len(hits))
+
+	for _, d := range hits {
+		sp, ok := chunkSpan(d.Metadata)
+		if !ok {
+			out = append(out, d)
+			continue
+		}
+		key := fmt.Sprintf("%s\x00%s\x00%d", d.Repo, d.Path, sp.section)
+		g, ok := groups[key]
+		if !ok {
+			windows, err := fetch(ctx, d.Repo, d.Path, sp.section)
+			if err != nil {
+				return nil, err
+			}
+			g = &group{windows: windows}
+			for _, w := range windows {
+				if ws, ok := chunkSpan(w.Metadata); ok && ws.end > g.length {
+					g.length = ws.end
+				}
+			}
+			if sp.end > g.length {
+				g.length = sp.end
+			}
+			groups[key] = g
+		}
+
+		lo, hi := sp.start, sp.end
+		if mode == ExpandSection {
+			lo, hi = 0, g.length
+		} else {
+			w := sp.end - sp.start
+			if sp.hasBytes {
+				// Windows cut at sentence ends are often shorter than
+				// their size, while their neighbours may use all of it.
+				w = max(w, sp.size)
+			}
+			lo, hi = sp.start-w, sp.end+w
+		}
+		lo, hi = clampRange(lo, hi, sp.start, sp.end, g.length, budget)
+
+		e := &expanded{doc: d, start: lo, end: hi, ids: []int{d.ChunkID}, hit: sp}
+		// Fold every earlier range this one touches into the best-ranked of
+		// them, which keeps its position in the results.
+		var into *expanded
+		kept := g.merged[:0]
+		for _, other := range g.merged {
+			if e.start > other.end || e.end < other.start {
+				kept = append(kept, other)
+				continue
+			}
+			if into == nil {
+				into = other
+				kept = append(kept, other)
+			} else {
+				other.dropped = true
+				into.ids = append(into.ids, other.ids...)
+			}
+			into.start, into.end = min(into.start, e.start, other.start), max(into.end, e.end, other.end)
+		}
+		g.merged = kept
+		if into != nil {
+			into.ids = append(into.ids, e.ids...)
+			continue
+		}
+		g.merged = append(g.merged, e)
+		out = append(out, d)
+		byDoc[d] = e
+	}
+
+	filtered := out[:0]
+	for _, d := range out {
+		if e, ok := byDoc[d]; ok && e.dropped {
+			continue
+		}
+		filtered = append(filtered, d)
+	}
+	out = filtered
+
+	for _, g := range groups {
+		for _, e := range g.merged {
+			e.apply(g.windows)
+		}
+	}
+	return out, nil
+}
+
+// clampRange limits [lo,hi) to the section and to budget tokens, shrinking
+// evenly around the hit [start,end).
+func clampRange(lo, hi, start, end, length, budget int) (int, int) {
+	lo, hi = max(lo, 0), min(hi, max(length, end))
+	for hi-lo > budget {
+		before, after := start-lo, hi-end
+		switch {
+		case before <= 0 && after <= 0:
+			// the hit alone exceeds the budget; keep it whole
+			return start, end
+		case after >= before:
+			hi--
+		default:
+			lo++
+		}
+	}
+	return lo, hi
+}
+
+// expanded is a hit whose content will be replaced by the range [start,end)
+// of its section.
+type expanded struct {
+	doc        *Document
+	start, end int
+	ids        []int
+	dropped    bool
+	// hit is the span of doc.
+	hit span
+}
+
+// apply rebuilds the content of e.doc from windows. Tokens missing from every
+// stored window leave a gap marked with an ellipsis.
+func (e *expanded) apply(windows []store.SearchHit) {
+	if e.hit.hasBytes {
+		e.applySlices(windows)
+		return
+	}
+	tokens := make([]string, e.end-e.start)
+	covered := make([]bool, len(tokens))
+	for _, w := range windows {
+		ws, ok := chunkSpan(w.Metadata)
+		if !ok || ws.end <= e.start || ws.start >= e.end {
+			continue
+		}
+		words := strings.Fields(w.Content)
+		if len(words) != ws.end-ws.start {
+			continue
+		}
+		for i, word := range words {
+			pos := ws.start + i - e.start
+			if pos >= 0 && pos < len(tokens) && !covered[pos] {
+				tokens[pos] = word
+				covered[pos] = true
+			}
+		}
+	}
+
+	var parts []string
+	var cur []string
+	for i, tok := range tokens {
+		if covered[i] {
+			cur = append(cur, tok)
+			continue
+		}
+		if len(cur) > 0 {
+			parts = append(parts, strings.Join(cur, " "))
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		parts = append(parts, strings.Join(cur, " "))
+	}
+	if len(parts) == 0 {
+		return
+	}
+
+	sort.Ints(e.ids)
+	d := e.doc
+	d.Content = strings.Join(parts, " … ")
+	meta := make(map[string]any, len(d.Metadata)+3)
+	for k, v := range d.Metadata {
+		meta[k] = v
+	}
+	meta["token_start"] = e.start
+	meta["token_end"] = e.end
+	meta["expanded_from"] = e.ids
+	d.Metadata = meta
+}
+
+// applySlices rebuilds the content of e.doc from the windows that lie within
+// [start,end) and whose content is a slice of the section text. The slices
+// are joined by their byte offsets, so the text keeps its original spacing;
+// only the whitespace between windows that touch without overlapping is
+// replaced by a space. Tokens covered by none of the windows leave a gap
+// marked with an ellipsis.
+func (e *expanded) applySlices(windows []store.SearchHit) {
+	type slice struct {
+		sp   span
+		text string
+	}
+	sel := []slice{{e.hit, e.doc.Content}}
+	for _, w := range windows {
+		ws, ok := chunkSpan(w.Metadata)
+		if !ok || !ws.hasBytes || ws.start < e.start || ws.end > e.end || len(w.Content) != ws.byteEnd-ws.byteStart {
+			continue
+		}
+		sel = append(sel, slice{ws, w.Content})
+	}
+	if len(sel[0].text) != e.hit.byteEnd-e.hit.byteStart {
+		return
+	}
+	sort.SliceStable(sel, func(i, j int) bool { return sel[i].sp.byteStart < sel[j].sp.byteStart })
+
+	var parts []string
+	var cur strings.Builder
+	cov := sel[0].sp
+	cur.WriteString(sel[0].text)
+	for _, s := range sel[1:] {
+		switch {
+		case s.sp.start > cov.end:
+			parts = append(parts, cur.String())
+			cur.Reset()
+			cur.WriteString(s.text)
+		case s.sp.byteStart > cov.byteEnd:
+			cur.WriteString(" ")
+			cur.WriteString(s.text)
+		case s.sp.byteEnd > cov.byteEnd:
+			cur.WriteString(s.text[cov.byteEnd-s.sp.byteStart:])
+		default:
+			continue
+		}
+		cov.start, cov.end = min(cov.start, s.sp.start), max(cov.end, s.sp.end)
+		cov.byteEnd = s.sp.byteEnd
+	}
+	parts = append(parts, cur.String())
+
+	first := sel[0].sp
+	sort.Ints(e.ids)
+	d := e.doc
+	d.Content = strings.Join(parts, " … ")
+	meta := make(map[string]any, len(d.Metadata)+3)
+	for k, v := range d.Metadata {
+		meta[k] = v
+	}
+	meta["token_start"] = cov.start
+	meta["token_end"] = cov.end
+	meta["byte_start"] = first.byteStart
+	meta["byte_end"] = cov.byteEnd
+	meta["expanded_from"] = e.ids
+	d.Metadata = meta
+}
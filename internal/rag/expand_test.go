@@ -0,0 +1,184 @@
+package rag
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"rag-server/internal/rag/config"
+	"rag-server/internal/rag/ingest"
+	"rag-server/internal/rag/store"
+)
+
+// sectionWindows slides a window of size over the tokens of text with the
+// given overlap, mimicking ingest.BuildChunks.
+func sectionWindows(text string, section, size, overlap, firstID int) []store.SearchHit {
+	toks := strings.Fields(text)
+	var out []store.SearchHit
+	for start := 0; start < len(toks); start += size - overlap {
+		end := min(start+size, len(toks))
+		out = append(out, store.SearchHit{
+			Repo: "kb", Path: "a.md", ChunkID: firstID + len(out),
+			Content:  strings.Join(toks[start:end], " "),
+			Metadata: map[string]any{"section": float64(section), "token_start": float64(start), "token_end": float64(end)},
+		})
+		if end == len(toks) {
+			break
+		}
+	}
+	return out
+}
+
+func hitFrom(h store.SearchHit) *Document {
+	d := hitDocument(h)
+	return &d
+}
+
+const sectionText = "t0 t1 t2 t3 t4 t5 t6 t7 t8 t9 t10 t11"
+
+func fetcher(windows []store.SearchHit, calls *int) sectionFetcher {
+	return func(ctx context.Context, repo, path string, section int) ([]store.SearchHit, error) {
+		*calls++
+		return windows, nil
+	}
+}
+
+func TestExpandNeighbors(t *testing.T) {
+	// windows: [0,4) [3,7) [6,10) [9,12)
+	windows := sectionWindows(sectionText, 2, 4, 1, 10)
+	var calls int
+	out, err := expand(context.Background(), []*Document{hitFrom(windows[1])}, ExpandNeighbors, 100, fetcher(windows, &calls))
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if len(out) != 1 || out[0].Content != "t0 t1 t2 t3 t4 t5 t6 t7 t8 t9 t10" {
+		t.Fatalf("unexpected expansion %q", out[0].Content)
+	}
+	if out[0].Metadata["token_start"] != 0 || out[0].Metadata["token_end"] != 11 {
+		t.Fatalf("unexpected range %v", out[0].Metadata)
+	}
+}
+
+func TestExpandSectionBudget(t *testing.T) {
+	windows := sectionWindows(sectionText, 0, 4, 1, 0)
+	var calls int
+	out, err := expand(context.Background(), []*Document{hitFrom(windows[2])}, ExpandSection, 6, fetcher(windows, &calls))
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	// hit is [6,10); a 6 token budget keeps one token on each side
+	if out[0].Content != "t5 t6 t7 t8 t9 t10" {
+		t.Fatalf("unexpected expansion %q", out[0].Content)
+	}
+
+	out, _ = expand(context.Background(), []*Document{hitFrom(windows[0])}, ExpandSection, 100, fetcher(windows, &calls))
+	if out[0].Content != sectionText {
+		t.Fatalf("expected whole section, got %q", out[0].Content)
+	}
+}
+
+func TestExpandMergesOverlappingHits(t *testing.T) {
+	windows := sectionWindows(sectionText, 0, 4, 1, 0)
+	other := &Document{Repo: "kb", Path: "toc.md", Content: "toc", Metadata: map[string]any{"type": "toc"}}
+	hits := []*Document{hitFrom(windows[3]), other, hitFrom(windows[0]), hitFrom(windows[1])}
+
+	var calls int
+	out, err := expand(context.Background(), hits, ExpandNeighbors, 100, fetcher(windows, &calls))
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected one section fetch, got %d", calls)
+	}
+	// windows[3] expands to [6,12), windows[0] to [0,8) and windows[1] to
+	// [0,10): all three merge into the first, best-ranked hit.
+	if len(out) != 2 || out[0].ChunkID != 3 || out[1] != other {
+		t.Fatalf("unexpected merge result %+v", out)
+	}
+	if out[0].Content != sectionText {
+		t.Fatalf("unexpected merged content %q", out[0].Content)
+	}
+	ids := out[0].Metadata["expanded_from"].([]int)
+	if len(ids) != 3 || ids[0] != 0 || ids[2] != 3 {
+		t.Fatalf("unexpected merged ids %v", ids)
+	}
+}
+
+func TestExpandGap(t *testing.T) {
+	windows := sectionWindows(sectionText, 0, 4, 1, 0)
+	sparse := []store.SearchHit{windows[0], windows[2]}
+	var calls int
+	out, err := expand(context.Background(), []*Document{hitFrom(windows[2])}, ExpandSection, 100, fetcher(sparse, &calls))
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if out[0].Content != "t0 t1 t2 t3 … t6 t7 t8 t9" {
+		t.Fatalf("unexpected gap handling %q", out[0].Content)
+	}
+}
+
+func TestExpandNone(t *testing.T) {
+	d := &Document{Content: "x", Metadata: map[string]any{"section": 0, "token_start": 0, "token_end": 1}}
+	out, err := expand(context.Background(), []*Document{d}, ExpandNone, 0, nil)
+	if err != nil || len(out) != 1 || out[0].Content != "x" {
+		t.Fatalf("unexpected result %v %v", out, err)
+	}
+}
+
+func TestExpandSlices(t *testing.T) {
+	text := "检索增强生成把文档切成块。每个块单独嵌入！查询时找出最相近的块？" +
+		"然后把它们交给模型回答问题。这一段没有空格。"
+	chunks := ingest.BuildChunksWith([]ingest.Section{{Text: text}}, config.ChunkingCfg{MaxTokens: 12, OverlapTokens: 4}, ingest.CJK{})
+	windows := make([]store.SearchHit, len(chunks))
+	for i, c := range chunks {
+		windows[i] = store.SearchHit{Repo: "kb", Path: "a.md", ChunkID: c.ChunkID, Content: c.Text, Metadata: c.Meta}
+	}
+	if len(windows) < 4 {
+		t.Fatalf("expected at least 4 windows, got %d", len(windows))
+	}
+	var calls int
+	out, err := expand(context.Background(), []*Document{hitFrom(windows[1])}, ExpandNeighbors, 100, fetcher(windows, &calls))
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	// The neighbours are the windows within one window size of the hit.
+	lo, hi := chunks[1].Meta["token_start"].(int)-12, chunks[1].Meta["token_end"].(int)+12
+	from, to := len(text), 0
+	for _, c := range chunks {
+		if c.Meta["token_start"].(int) >= lo && c.Meta["token_end"].(int) <= hi {
+			from, to = min(from, c.Meta["byte_start"].(int)), max(to, c.Meta["byte_end"].(int))
+		}
+	}
+	if to == chunks[1].Meta["byte_end"].(int) || out[0].Content != text[from:to] {
+		t.Fatalf("neighbours expanded to %q, want %q", out[0].Content, text[from:to])
+	}
+
+	out, _ = expand(context.Background(), []*Document{hitFrom(windows[2])}, ExpandSection, 1000, fetcher(windows, &calls))
+	if out[0].Content != text {
+		t.Fatalf("expected the whole section, got %q", out[0].Content)
+	}
+
+	// Without the windows in between, the first window is separated from
+	// the ones that do not overlap it by an ellipsis.
+	k := 1
+	for chunks[k].Meta["token_start"].(int) <= chunks[0].Meta["token_end"].(int) {
+		k++
+	}
+	sparse := append([]store.SearchHit{windows[0]}, windows[k:]...)
+	out, _ = expand(context.Background(), []*Document{hitFrom(windows[k])}, ExpandSection, 1000, fetcher(sparse, &calls))
+	if want := chunks[0].Text + " … " + text[chunks[k].Meta["byte_start"].(int):]; out[0].Content != want {
+		t.Fatalf("unexpected gap handling %q, want %q", out[0].Content, want)
+	}
+
+	// Windows that touch are joined with a space instead of the whitespace
+	// between them.
+	chunks = ingest.BuildChunksWith([]ingest.Section{{Text: "One two.\nThree four."}}, config.ChunkingCfg{MaxTokens: 2}, ingest.CJK{})
+	windows = windows[:0]
+	for _, c := range chunks {
+		windows = append(windows, store.SearchHit{Repo: "kb", Path: "a.md", ChunkID: c.ChunkID, Content: c.Text, Metadata: c.Meta})
+	}
+	out, _ = expand(context.Background(), []*Document{hitFrom(windows[0])}, ExpandSection, 100, fetcher(windows, &calls))
+	if out[0].Content != "One two. Three four." {
+		t.Fatalf("unexpected join %q", out[0].Content)
+	}
+}
@@ -0,0 +1,167 @@
+package rag
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"rag-server/internal/rag/config"
+)
+
+// Fusion strategies for merging vector and lexical results.
+const (
+	// FusionRaw linearly mixes the raw vector and lexical scores.
+	FusionRaw = "raw"
+	// FusionRRF uses weighted reciprocal rank fusion and ignores raw scores.
+	FusionRRF = "rrf"
+	// FusionMinMax min-max normalizes each list before mixing.
+	FusionMinMax = "minmax"
+	// FusionZScore z-score normalizes each list before mixing.
+	FusionZScore = "zscore"
+)
+
+// DefaultRRFK is the reciprocal rank fusion constant from the original paper.
+const DefaultRRFK = 60
+
+// fusionStrategy returns the configured strategy name in canonical form.
+func fusionStrategy(cfg config.FusionCfg) (string, error) {
+	switch s := strings.ToLower(strings.TrimSpace(cfg.Strategy)); s {
+	case "":
+		return FusionRaw, nil
+	case FusionRaw, FusionRRF, FusionMinMax, FusionZScore:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown fusion strategy %q", cfg.Strategy)
+	}
+}
+
+// fuse sets FusedScore on every document. alpha weights the vector list and
+// 1-alpha the lexical list. A document missing from a list contributes nothing
+// for RRF and the list's lowest normalized score otherwise.
+func fuse(docs []*Document, strategy string, rrfK int, alpha float64) {
+	switch strategy {
+	case FusionRRF:
+		if rrfK <= 0 {
+			rrfK = DefaultRRFK
+		}
+		for _, d := range docs {
+			var s float64
+			if d.VectorRank > 0 {
+				s += alpha / float64(rrfK+d.VectorRank)
+			}
+			if d.LexicalRank > 0 {
+				s += (1 - alpha) / float64(rrfK+d.LexicalRank)
+			}
+			d.FusedScore = s
+		}
+	case FusionMinMax, FusionZScore:
+		norm := minMax
+		if strategy == FusionZScore {
+			norm = zScore
+		}
+		vec := norm(docs, func(d *Document) (float64, bool) { return d.VectorScore, d.VectorRank > 0 })
+		lex := norm(docs, func(d *Document) (float64, bool) { return d.LexicalScore, d.LexicalRank > 0 })
+		for i, d := range docs {
+			d.FusedScore = alpha*vec[i] + (1-alpha)*lex[i]
+		}
+	default:
+		for _, d := range docs {
+			d.FusedScore = alpha*d.VectorScore + (1-alpha)*d.LexicalScore
+		}
+	}
+}
+
+// scoreFn returns a document's score in one list and whether it appears in it.
+type scoreFn func(*Document) (float64, bool)
+
+// minMax scales the scores of one list to [0,1]. Documents absent from the
+// list get 0.
+func minMax(docs []*Document, score scoreFn) []float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, d := range docs {
+		if v, ok := score(d); ok {
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+	}
+	out := make([]float64, len(docs))
+	for i, d := range docs {
+		v, ok := score(d)
+		switch {
+		case !ok:
+			out[i] = 0
+		case hi > lo:
+			out[i] = (v - lo) / (hi - lo)
+		default:
+			out[i] = 1
+		}
+	}
+	return out
+}
+
+// zScore standardizes the scores of one list. Documents absent from the list
+// get the lowest standardized score observed.
+func zScore(docs []*Document, score scoreFn) []float64 {
+	var sum, sumSq float64
+	n := 0
+	for _, d := range docs {
+		if v, ok := score(d); ok {
+			sum += v
+			sumSq += v * v
+			n++
+		}
+	}
+	out := make([]float64, len(docs))
+	if n == 0 {
+		return out
+	}
+	mean := sum / float64(n)
+	std := math.Sqrt(math.Max(sumSq/float64(n)-mean*mean, 0))
+	lowest := math.Inf(1)
+	for i, d := range docs {
+		if v, ok := score(d); ok {
+			if std > 0 {
+				out[i] = (v - mean) / std
+			}
+			lowest = math.Min(lowest, out[i])
+		}
+	}
+	for i, d := range docs {
+		if _, ok := score(d); !ok {
+			out[i] = lowest
+		}
+	}
+	return out
+}
+
+// fuseVariants merges the ranked candidate lists of several query variants
+// with reciprocal rank fusion. A document keeps the scores from the first
+// list it appears in; its FusedScore and Score become the RRF score. At most
+// limit documents are returned.
+func fuseVariants(lists [][]*Document, rrfK, limit int) []*Document {
+	if rrfK <= 0 {
+		rrfK = DefaultRRFK
+	}
+	byKey := map[string]*Document{}
+	rrf := map[string]float64{}
+	var out []*Document
+	for _, list := range lists {
+		for rank, d := range list {
+			if _, ok := byKey[d.DocKey]; !ok {
+				byKey[d.DocKey] = d
+				out = append(out, d)
+			}
+			rrf[d.DocKey] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	for _, d := range out {
+		d.FusedScore = rrf[d.DocKey]
+		d.Score = d.FusedScore
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package rag
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"rag-server/internal/rag/config"
+)
+
+// rankedLists builds candidate documents from a vector and a lexical ranked
+// list of (key, score) pairs, mirroring how Query merges search results.
+func rankedLists(vector, lexical [][2]any) []*Document {
+	byKey := map[string]*Document{}
+	var docs []*Document
+	get := func(key string) *Document {
+		if d, ok := byKey[key]; ok {
+			return d
+		}
+		d := &Document{DocKey: key}
+		byKey[key] = d
+		docs = append(docs, d)
+		return d
+	}
+	for i, e := range vector {
+		d := get(e[0].(string))
+		d.VectorScore, d.VectorRank = e[1].(float64), i+1
+	}
+	for i, e := range lexical {
+		d := get(e[0].(string))
+		d.LexicalScore, d.LexicalRank = e[1].(float64), i+1
+	}
+	return docs
+}
+
+func order(docs []*Document) []string {
+	sorted := append([]*Document(nil), docs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].FusedScore > sorted[j].FusedScore })
+	keys := make([]string, len(sorted))
+	for i, d := range sorted {
+		keys[i] = d.DocKey
+	}
+	return keys
+}
+
+func score(docs []*Document, key string) float64 {
+	for _, d := range docs {
+		if d.DocKey == key {
+			return d.FusedScore
+		}
+	}
+	return math.NaN()
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+// Vector scores are small negative inner products; lexical ranks are tiny
+// positive numbers. Raw mixing lets the vector scale dominate.
+var (
+	vectorList  = [][2]any{{"a", -0.20}, {"b", -0.25}, {"c", -0.90}}
+	lexicalList = [][2]any{{"c", 0.09}, {"d", 0.05}, {"a", 0.01}}
+)
+
+func TestFuseRaw(t *testing.T) {
+	docs := rankedLists(vectorList, lexicalList)
+	fuse(docs, FusionRaw, 0, 0.5)
+	if !near(score(docs, "a"), 0.5*-0.20+0.5*0.01) {
+		t.Fatalf("unexpected raw score %v", score(docs, "a"))
+	}
+	if got := order(docs); got[0] != "d" {
+		t.Fatalf("expected lexical-only doc to win on raw scale, got %v", got)
+	}
+}
+
+func TestFuseRRF(t *testing.T) {
+	docs := rankedLists(vectorList, lexicalList)
+	fuse(docs, FusionRRF, 60, 0.5)
+	if want := 0.5/61 + 0.5/63; !near(score(docs, "a"), want) {
+		t.Fatalf("a = %v, want %v", score(docs, "a"), want)
+	}
+	if want := 0.5 / 62; !near(score(docs, "d"), want) {
+		t.Fatalf("d = %v, want %v", score(docs, "d"), want)
+	}
+	if got := order(docs); got[0] != "a" || got[1] != "c" {
+		t.Fatalf("unexpected rrf order %v", got)
+	}
+
+	defaults := rankedLists(vectorList, lexicalList)
+	fuse(defaults, FusionRRF, 0, 0.5)
+	if !near(score(defaults, "d"), 0.5/float64(DefaultRRFK+2)) {
+		t.Fatalf("default k not applied: %v", score(defaults, "d"))
+	}
+}
+
+func TestFuseMinMax(t *testing.T) {
+	docs := rankedLists(vectorList, lexicalList)
+	fuse(docs, FusionMinMax, 0, 0.5)
+	// a: vector top (1), lexical bottom (0)
+	if !near(score(docs, "a"), 0.5) {
+		t.Fatalf("a = %v", score(docs, "a"))
+	}
+	// c: vector bottom (0), lexical top (1)
+	if !near(score(docs, "c"), 0.5) {
+		t.Fatalf("c = %v", score(docs, "c"))
+	}
+	// d: absent from vector (0), lexical (0.05-0.01)/(0.08)
+	if !near(score(docs, "d"), 0.5*0.5) {
+		t.Fatalf("d = %v", score(docs, "d"))
+	}
+
+	vecOnly := rankedLists(vectorList, lexicalList)
+	fuse(vecOnly, FusionMinMax, 0, 1)
+	if got := order(vecOnly); got[0] != "a" || got[1] != "b" {
+		t.Fatalf("alpha=1 should follow the vector list, got %v", got)
+	}
+}
+
+func TestFuseZScore(t *testing.T) {
+	docs := rankedLists([][2]any{{"a", 3.0}, {"b", 1.0}}, [][2]any{{"b", 5.0}, {"c", 1.0}})
+	fuse(docs, FusionZScore, 0, 0.5)
+	// each two-element list standardizes to +1 / -1; absent docs get -1
+	for key, want := range map[string]float64{"a": 0, "b": 0, "c": -1} {
+		if !near(score(docs, key), want) {
+			t.Fatalf("%s = %v, want %v", key, score(docs, key), want)
+		}
+	}
+
+	same := rankedLists([][2]any{{"a", 2.0}, {"b", 2.0}}, nil)
+	fuse(same, FusionZScore, 0, 1)
+	if score(same, "a") != 0 || score(same, "b") != 0 {
+		t.Fatalf("constant scores should standardize to 0, got %v %v", score(same, "a"), score(same, "b"))
+	}
+}
+
+func TestFusionStrategy(t *testing.T) {
+	for in, want := range map[string]string{"": FusionRaw, "RRF": FusionRRF, " zscore ": FusionZScore} {
+		got, err := fusionStrategy(config.FusionCfg{Strategy: in})
+		if err != nil || got != want {
+			t.Fatalf("fusionStrategy(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := fusionStrategy(config.FusionCfg{Strategy: "borda"}); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+}
@@ -0,0 +1,73 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Chain tries its generators in order and moves on to the next one when a
+// request is rate limited, fails with a server error or times out. Other
+// errors are returned immediately.
+type Chain struct {
+	gens    []Generator
+	timeout time.Duration
+	retries int
+}
+
+// NewChain returns a chain over gens. Each attempt is bounded by timeout when
+// it is positive and the whole chain is walked retries+1 times.
+func NewChain(gens []Generator, timeout time.Duration, retries int) *Chain {
+	if retries < 0 {
+		retries = 0
+	}
+	return &Chain{gens: gens, timeout: timeout, retries: retries}
+}
+
+// Generate returns the completion of the first generator that succeeds.
+func (c *Chain) Generate(ctx context.Context, req Request) (Response, error) {
+	return c.run(ctx, func(ctx context.Context, g Generator) (Response, bool, error) {
+		resp, err := g.Generate(ctx, req)
+		return resp, false, err
+	})
+}
+
+// Stream streams from the first generator that starts responding. Once a
+// delta has been delivered the chain no longer falls back, since the caller
+// has already seen partial output.
+func (c *Chain) Stream(ctx context.Context, req Request, onDelta func(string) error) (Response, error) {
+	return c.run(ctx, func(ctx context.Context, g Generator) (Response, bool, error) {
+		started := false
+		resp, err := g.Stream(ctx, req, func(delta string) error {
+			started = true
+			return onDelta(delta)
+		})
+		return resp, started, err
+	})
+}
+
+func (c *Chain) run(ctx context.Context, call func(context.Context, Generator) (Response, bool, error)) (Response, error) {
+	if len(c.gens) == 0 {
+		return Response{}, errors.New("no generator configured")
+	}
+	var lastErr error
+	for pass := 0; pass <= c.retries; pass++ {
+		for _, g := range c.gens {
+			attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+			if c.timeout > 0 {
+				attemptCtx, cancel = context.WithTimeout(ctx, c.timeout)
+			}
+			resp, started, err := call(attemptCtx, g)
+			cancel()
+			if err == nil {
+				return resp, nil
+			}
+			lastErr = err
+			if started || ctx.Err() != nil || !Retryable(err) {
+				return resp, err
+			}
+		}
+	}
+	return Response{}, fmt.Errorf("%w (timeout=%s retries=%d)", lastErr, c.timeout, c.retries)
+}
@@ -0,0 +1,144 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rag-server/internal/rag/breaker"
+)
+
+// modelServer answers chat completions, failing for the models in fail with
+// the given status and sleeping for the models in slow.
+func modelServer(t *testing.T, fail map[string]int, slow map[string]bool, calls *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		*calls = append(*calls, payload.Model)
+		if slow[payload.Model] {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		if code := fail[payload.Model]; code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"from ` + payload.Model + `"}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestChainFallback(t *testing.T) {
+	var calls []string
+	srv := modelServer(t, map[string]int{"a": 429, "b": 503}, map[string]bool{"c": true}, &calls)
+
+	chain := New("openai", []string{srv.URL}, "", []string{"a", "b", "c", "d"}, 50*time.Millisecond, 0)
+	resp, err := chain.Generate(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if resp.Content != "from d" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if len(calls) != 4 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestChainStopsOnClientError(t *testing.T) {
+	var calls []string
+	srv := modelServer(t, map[string]int{"a": 400}, nil, &calls)
+
+	_, err := New("openai", []string{srv.URL}, "", []string{"a", "b"}, time.Second, 2).Generate(context.Background(), Request{})
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != 400 {
+		t.Fatalf("expected 400 error, got %v", err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected a single call, got %v", calls)
+	}
+}
+
+func TestChainRetriesPasses(t *testing.T) {
+	var calls []string
+	srv := modelServer(t, map[string]int{"a": 500}, nil, &calls)
+
+	_, err := New("openai", []string{srv.URL}, "", []string{"a"}, time.Second, 2).Generate(context.Background(), Request{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 attempts, got %v", calls)
+	}
+}
+
+func TestChainEndpointFailover(t *testing.T) {
+	var downCalls, upCalls []string
+	down := modelServer(t, map[string]int{"a": 503}, nil, &downCalls)
+	up := modelServer(t, nil, nil, &upCalls)
+
+	chain := New("openai", []string{down.URL, up.URL}, "", []string{"a"}, time.Second, 0)
+	for i := 0; i < breaker.DefaultFailures+2; i++ {
+		resp, err := chain.Generate(context.Background(), Request{})
+		if err != nil || resp.Content != "from a" {
+			t.Fatalf("Generate = %+v, %v", resp, err)
+		}
+	}
+	// The breaker for the failing endpoint opens and stops sending it
+	// requests.
+	if len(downCalls) != breaker.DefaultFailures {
+		t.Fatalf("failing endpoint called %d times, want %d", len(downCalls), breaker.DefaultFailures)
+	}
+	if len(upCalls) != breaker.DefaultFailures+2 {
+		t.Fatalf("healthy endpoint called %d times", len(upCalls))
+	}
+}
+
+type fakeStream struct {
+	deltas []string
+	err    error
+}
+
+func (f fakeStream) Generate(ctx context.Context, req Request) (Response, error) {
+	return Response{}, f.err
+}
+
+func (f fakeStream) Stream(ctx context.Context, req Request, onDelta func(string) error) (Response, error) {
+	for _, d := range f.deltas {
+		if err := onDelta(d); err != nil {
+			return Response{}, err
+		}
+	}
+	return Response{Content: "done"}, f.err
+}
+
+func TestChainStreamNoFallbackAfterOutput(t *testing.T) {
+	overloaded := &HTTPError{Code: 503, Status: "503"}
+	chain := NewChain([]Generator{
+		fakeStream{err: overloaded},
+		fakeStream{deltas: []string{"par"}, err: overloaded},
+		fakeStream{deltas: []string{"never"}},
+	}, 0, 0)
+	var got string
+	_, err := chain.Stream(context.Background(), Request{}, func(d string) error {
+		got += d
+		return nil
+	})
+	if !errors.Is(err, overloaded) {
+		t.Fatalf("expected mid-stream error, got %v", err)
+	}
+	if got != "par" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
@@ -0,0 +1,21 @@
+package generate
+
+import "strings"
+
+// DefaultChutesEndpoint is used when a Chutes generator has no endpoint.
+const DefaultChutesEndpoint = "https://llm.chutes.ai/v1/chat/completions"
+
+// Chutes implements the Generator interface for the Chutes LLM service, which
+// speaks the OpenAI chat completions protocol.
+type Chutes struct {
+	*OpenAI
+}
+
+// NewChutes returns a Chutes generator. An empty endpoint selects the public
+// Chutes API.
+func NewChutes(endpoint, token, model string) *Chutes {
+	if strings.TrimSpace(endpoint) == "" {
+		endpoint = DefaultChutesEndpoint
+	}
+	return &Chutes{OpenAI: NewOpenAI(endpoint, token, model)}
+}
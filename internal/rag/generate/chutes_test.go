@@ -0,0 +1,32 @@
+package generate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChutesGenerate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer cpk" {
+			t.Errorf("missing bearer token")
+		}
+		w.Write([]byte(`{"model":"deepseek-ai/DeepSeek-V3","choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewChutes(srv.URL, "cpk", "deepseek-ai/DeepSeek-V3").Generate(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if resp.Content != "ok" || resp.Model != "deepseek-ai/DeepSeek-V3" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestChutesDefaultEndpoint(t *testing.T) {
+	if got := NewChutes("", "", "m").endpoint; got != DefaultChutesEndpoint {
+		t.Fatalf("endpoint = %q", got)
+	}
+}
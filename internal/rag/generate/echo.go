@@ -0,0 +1,77 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EchoModel is the model name reported by the echo generator.
+const EchoModel = "echo"
+
+// sourceHeader matches the "[n] repo/path#chunk" lines that start each source
+// in a grounded system prompt.
+var sourceHeader = regexp.MustCompile(`(?m)^\[(\d+)\] (.+)$`)
+
+// echoSnippet is the number of characters of each source quoted by Echo.
+const echoSnippet = 200
+
+// NewEcho returns a generator that needs no model. When the system prompt
+// lists sources, it answers with the question and an excerpt of every source,
+// cited as [n]. Otherwise it repeats the last user message, so query rewriting
+// searches for the question as asked. It is meant for offline development
+// and tests.
+func NewEcho() Generator {
+	return Func(echo)
+}
+
+// Local reports whether provider runs in process and needs no endpoint.
+func Local(provider string) bool {
+	return strings.EqualFold(strings.TrimSpace(provider), "echo")
+}
+
+func echo(ctx context.Context, req Request) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	var system, question string
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system":
+			system += m.Content + "\n"
+		case "user":
+			question = m.Content
+		}
+	}
+	content := question
+	if _, sources, ok := strings.Cut(system, "\nSources:\n"); ok {
+		if answer := echoSources(question, sources); answer != "" {
+			content = answer
+		}
+	}
+	return Response{Content: content, Model: EchoModel}, nil
+}
+
+// echoSources templates an answer that quotes every source listed in a
+// grounded prompt.
+func echoSources(question, sources string) string {
+	heads := sourceHeader.FindAllStringSubmatchIndex(sources, -1)
+	if len(heads) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sources for %q:\n", strings.TrimSpace(question))
+	for i, h := range heads {
+		end := len(sources)
+		if i+1 < len(heads) {
+			end = heads[i+1][0]
+		}
+		text := strings.Join(strings.Fields(sources[h[1]:end]), " ")
+		if r := []rune(text); len(r) > echoSnippet {
+			text = string(r[:echoSnippet]) + "…"
+		}
+		fmt.Fprintf(&b, "\n- %s [%s]: %s", sources[h[4]:h[5]], sources[h[2]:h[3]], text)
+	}
+	return b.String()
+}
@@ -0,0 +1,47 @@
+package generate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEcho(t *testing.T) {
+	gen := New("Echo", nil, "", nil, time.Second, 0)
+	grounded := Request{Messages: []Message{
+		{Role: "system", Content: "Answer from the sources.\n\nSources:\n\n[1] kb/deploy.md#0\nRun make deploy\nto ship it.\n\n[2] kb/backup.md#3\nUse pg_dump.\n"},
+		{Role: "user", Content: "How do I deploy?"},
+	}}
+	resp, err := gen.Generate(context.Background(), grounded)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := "Sources for \"How do I deploy?\":\n\n- kb/deploy.md#0 [1]: Run make deploy to ship it.\n- kb/backup.md#3 [2]: Use pg_dump."
+	if resp.Content != want || resp.Model != EchoModel {
+		t.Fatalf("unexpected response %q (%s)", resp.Content, resp.Model)
+	}
+
+	var streamed strings.Builder
+	resp, err = gen.Stream(context.Background(), grounded, func(d string) error {
+		streamed.WriteString(d)
+		return nil
+	})
+	if err != nil || streamed.String() != want {
+		t.Fatalf("Stream = %q, %v", streamed.String(), err)
+	}
+
+	plain := Request{Messages: []Message{
+		{Role: "system", Content: "Rewrite the question as a search query."},
+		{Role: "user", Content: "部署步骤"},
+	}}
+	if resp, _ = gen.Generate(context.Background(), plain); resp.Content != "部署步骤" {
+		t.Fatalf("expected the question echoed, got %q", resp.Content)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	if !Local(" ECHO ") || Local("openai") || Local("") {
+		t.Fatal("unexpected Local result")
+	}
+}
@@ -0,0 +1,27 @@
+package generate
+
+import "context"
+
+// Func adapts a function to the Generator interface. It is meant for local
+// stubs in tests and offline setups; Stream delivers the whole completion as
+// a single delta.
+type Func func(ctx context.Context, req Request) (Response, error)
+
+// Generate calls f.
+func (f Func) Generate(ctx context.Context, req Request) (Response, error) {
+	return f(ctx, req)
+}
+
+// Stream calls f and passes its content to onDelta.
+func (f Func) Stream(ctx context.Context, req Request, onDelta func(string) error) (Response, error) {
+	resp, err := f(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Content != "" {
+		if err := onDelta(resp.Content); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,142 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"rag-server/internal/rag/breaker"
+)
+
+// Message is a single chat message sent to a generator.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Usage reports token usage of a completion.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Request describes a chat completion request.
+type Request struct {
+	Messages []Message
+}
+
+// Response is the result of a chat completion.
+type Response struct {
+	Content string
+	Model   string
+	Usage   Usage
+}
+
+// Generator produces chat completions.
+type Generator interface {
+	// Generate returns the full completion for req.
+	Generate(ctx context.Context, req Request) (Response, error)
+	// Stream invokes onDelta for every content fragment and returns the
+	// final response once the generator has finished.
+	Stream(ctx context.Context, req Request, onDelta func(string) error) (Response, error)
+}
+
+// HTTPError represents an HTTP error returned by a generation service.
+type HTTPError struct {
+	Code   int
+	Status string
+}
+
+// Error implements the error interface.
+func (e *HTTPError) Error() string {
+	return e.Status
+}
+
+// StatusCode returns the HTTP status code associated with the error.
+func (e *HTTPError) StatusCode() int {
+	return e.Code
+}
+
+// Retryable reports whether err is worth retrying with another model or
+// endpoint: rate limiting, server errors, timeouts and open circuit breakers.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, breaker.ErrOpen) {
+		return true
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code == 429 || httpErr.Code >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// New builds a fallback chain for provider over models. Each model is tried
+// on every endpoint in order before moving on to the next model, and every
+// model and endpoint pair is guarded by a circuit breaker. Each attempt is
+// bounded by timeout and the chain is walked retries+1 times.
+func New(provider string, endpoints []string, token string, models []string, timeout time.Duration, retries int) *Chain {
+	if Local(provider) {
+		return NewChain([]Generator{NewEcho()}, timeout, retries)
+	}
+	if len(models) == 0 {
+		models = []string{""}
+	}
+	if len(endpoints) == 0 {
+		// The chutes generator falls back to its public endpoint.
+		endpoints = []string{""}
+	}
+	gens := make([]Generator, 0, len(models)*len(endpoints))
+	for _, m := range models {
+		for _, ep := range endpoints {
+			var g Generator
+			switch strings.ToLower(provider) {
+			case "ollama":
+				g = NewOllama(ep, m)
+			case "chutes":
+				g = NewChutes(ep, token, m)
+			default:
+				g = NewOpenAI(ep, token, m)
+			}
+			name := ep
+			if name == "" {
+				name = strings.ToLower(provider)
+			}
+			gens = append(gens, guarded{Generator: g, br: breaker.Get("generator " + name + " " + m)})
+		}
+	}
+	return NewChain(gens, timeout, retries)
+}
+
+// guarded routes requests through a circuit breaker.
+type guarded struct {
+	Generator
+	br *breaker.Breaker
+}
+
+func (g guarded) Generate(ctx context.Context, req Request) (Response, error) {
+	if err := g.br.Allow(); err != nil {
+		return Response{}, err
+	}
+	resp, err := g.Generator.Generate(ctx, req)
+	g.br.Report(err)
+	return resp, err
+}
+
+func (g guarded) Stream(ctx context.Context, req Request, onDelta func(string) error) (Response, error) {
+	if err := g.br.Allow(); err != nil {
+		return Response{}, err
+	}
+	resp, err := g.Generator.Stream(ctx, req, onDelta)
+	g.br.Report(err)
+	return resp, err
+}
@@ -0,0 +1,133 @@
+package generate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Ollama implements the Generator interface using the native Ollama chat API.
+type Ollama struct {
+	endpoint string
+	model    string
+	client   *http.Client
+}
+
+// NewOllama returns an Ollama generator. endpoint may be the server base URL
+// (e.g. http://localhost:11434) or the full /api/chat URL.
+func NewOllama(endpoint, model string) *Ollama {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/api/chat") {
+		endpoint += "/api/chat"
+	}
+	return &Ollama{
+		endpoint: endpoint,
+		model:    model,
+		client:   &http.Client{},
+	}
+}
+
+// ollamaChunk is a single /api/chat response object. Non-streaming requests
+// return one object with done set; streaming requests return one per line.
+type ollamaChunk struct {
+	Model   string `json:"model"`
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+func (c ollamaChunk) usage() Usage {
+	return Usage{
+		PromptTokens:     c.PromptEvalCount,
+		CompletionTokens: c.EvalCount,
+		TotalTokens:      c.PromptEvalCount + c.EvalCount,
+	}
+}
+
+// Generate requests a non-streaming completion.
+func (o *Ollama) Generate(ctx context.Context, req Request) (Response, error) {
+	resp, err := o.post(ctx, req, false)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+	var out ollamaChunk
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Response{}, fmt.Errorf("decode completion: %w", err)
+	}
+	if out.Error != "" {
+		return Response{}, errors.New(out.Error)
+	}
+	return Response{Content: out.Message.Content, Model: o.model, Usage: out.usage()}, nil
+}
+
+// Stream requests a streaming completion and forwards content deltas.
+func (o *Ollama) Stream(ctx context.Context, req Request, onDelta func(string) error) (Response, error) {
+	resp, err := o.post(ctx, req, true)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	out := Response{Model: o.model}
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return out, fmt.Errorf("decode stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return out, errors.New(chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if err := onDelta(chunk.Message.Content); err != nil {
+				return out, err
+			}
+		}
+		if chunk.Done {
+			out.Usage = chunk.usage()
+			break
+		}
+	}
+	out.Content = content.String()
+	return out, scanner.Err()
+}
+
+func (o *Ollama) post(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	payload := map[string]any{
+		"model":    o.model,
+		"messages": req.Messages,
+		"stream":   stream,
+	}
+	body, _ := json.Marshal(payload)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, &HTTPError{Code: resp.StatusCode, Status: fmt.Sprintf("generate failed: %s", resp.Status)}
+	}
+	return resp, nil
+}
@@ -0,0 +1,53 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllamaGenerate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload["stream"] != false {
+			t.Errorf("expected non-stream payload, got %v (%v)", payload, err)
+		}
+		w.Write([]byte(`{"model":"llama3","message":{"role":"assistant","content":"hi"},"done":true,"prompt_eval_count":5,"eval_count":2}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewOllama(srv.URL, "llama3").Generate(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hello"}}})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if resp.Content != "hi" || resp.Usage.TotalTokens != 7 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"content":"Hel"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"content":"lo"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"content":""},"done":true,"prompt_eval_count":4,"eval_count":2}`)
+	}))
+	defer srv.Close()
+
+	var got string
+	resp, err := NewOllama(srv.URL+"/api/chat", "llama3").Stream(context.Background(), Request{}, func(d string) error {
+		got += d
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if got != "Hello" || resp.Content != "Hello" || resp.Usage.PromptTokens != 4 || resp.Usage.CompletionTokens != 2 {
+		t.Fatalf("unexpected stream result %q %+v", got, resp)
+	}
+}
@@ -0,0 +1,167 @@
+package generate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAI implements the Generator interface for OpenAI-compatible chat
+// completion endpoints.
+type OpenAI struct {
+	endpoint string
+	token    string
+	model    string
+	client   *http.Client
+}
+
+// NewOpenAI returns a generator posting to the chat completions endpoint.
+// Requests are bounded by the caller's context rather than a client timeout so
+// that long streams are not cut off.
+func NewOpenAI(endpoint, token, model string) *OpenAI {
+	return &OpenAI{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		token:    token,
+		model:    model,
+		client:   &http.Client{},
+	}
+}
+
+// Generate requests a non-streaming completion.
+func (o *OpenAI) Generate(ctx context.Context, req Request) (Response, error) {
+	resp, err := o.post(ctx, req, false)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Response{}, err
+	}
+	var out struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Response{}, fmt.Errorf("decode completion: %w", err)
+	}
+	if len(out.Choices) == 0 {
+		return Response{}, errors.New("completion has no choices")
+	}
+	return Response{Content: out.Choices[0].Message.Content, Model: o.modelOr(out.Model), Usage: out.Usage}, nil
+}
+
+// Stream requests a streaming completion and forwards content deltas.
+func (o *OpenAI) Stream(ctx context.Context, req Request, onDelta func(string) error) (Response, error) {
+	resp, err := o.post(ctx, req, true)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+	var content strings.Builder
+	usage, err := ReadEventStream(resp.Body, func(delta string) error {
+		content.WriteString(delta)
+		return onDelta(delta)
+	})
+	return Response{Content: content.String(), Model: o.model, Usage: usage}, err
+}
+
+func (o *OpenAI) post(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	payload := map[string]any{
+		"model":    o.model,
+		"messages": req.Messages,
+	}
+	if stream {
+		payload["stream"] = true
+		payload["stream_options"] = map[string]any{"include_usage": true}
+	}
+	body, _ := json.Marshal(payload)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	if o.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, &HTTPError{Code: resp.StatusCode, Status: fmt.Sprintf("generate failed: %s", resp.Status)}
+	}
+	return resp, nil
+}
+
+func (o *OpenAI) modelOr(reported string) string {
+	if reported != "" {
+		return reported
+	}
+	return o.model
+}
+
+// ReadEventStream parses an OpenAI-compatible chat completion event stream,
+// invoking onDelta for every content delta, and returns the reported usage.
+func ReadEventStream(r io.Reader, onDelta func(string) error) (Usage, error) {
+	var usage Usage
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return usage, nil
+		}
+		var event struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return usage, fmt.Errorf("decode stream event: %w", err)
+		}
+		if event.Error != nil {
+			return usage, errors.New(event.Error.Message)
+		}
+		if event.Usage != nil {
+			usage = *event.Usage
+		}
+		for _, choice := range event.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return usage, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
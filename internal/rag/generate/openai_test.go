@@ -0,0 +1,86 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIGenerate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("missing bearer token")
+		}
+		var payload struct {
+			Model    string    `json:"model"`
+			Messages []Message `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Model != "m1" || len(payload.Messages) != 1 {
+			t.Errorf("unexpected payload %+v (%v)", payload, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	defer srv.Close()
+
+	gen := NewOpenAI(srv.URL, "tok", "m1")
+	resp, err := gen.Generate(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hello"}}})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if resp.Content != "hi" || resp.Model != "m1" || resp.Usage.TotalTokens != 4 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload["stream"] != true {
+			t.Errorf("expected stream payload, got %v (%v)", payload, err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	var deltas []string
+	resp, err := NewOpenAI(srv.URL, "", "m").Stream(context.Background(), Request{}, func(d string) error {
+		deltas = append(deltas, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" || resp.Content != "Hello" || resp.Usage.TotalTokens != 5 {
+		t.Fatalf("unexpected stream result %v %+v", deltas, resp)
+	}
+}
+
+func TestOpenAIHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	_, err := NewOpenAI(srv.URL, "", "m").Generate(context.Background(), Request{})
+	if !Retryable(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+}
+
+func TestReadEventStreamError(t *testing.T) {
+	in := strings.NewReader("data: {\"error\":{\"message\":\"overloaded\"}}\n\n")
+	_, err := ReadEventStream(in, func(string) error { return nil })
+	if err == nil || err.Error() != "overloaded" {
+		t.Fatalf("expected provider error, got %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package ingest
 import (
 	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	cfgpkg "rag-server/internal/rag/config"
 )
@@ -16,9 +18,25 @@ type Chunk struct {
 	Meta    map[string]any
 }
 
-// BuildChunks splits sections into chunks based on configuration.
-// Token counting uses a best-effort approach by words when tiktoken fails.
+// BuildChunks splits sections into chunks based on configuration, measuring
+// sizes with the tokenizer cfg selects (see NewTokenizer).
 func BuildChunks(secs []Section, cfg cfgpkg.ChunkingCfg) ([]Chunk, error) {
+	tok, err := NewTokenizer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return BuildChunksWith(secs, cfg, tok), nil
+}
+
+// BuildChunksWith splits sections into chunks of at most cfg.MaxTokens
+// tokens of tok, and once more for every size of cfg.AdditionalMaxTokens.
+// A text longer than the size is cut into windows overlapping by about
+// cfg.OverlapTokens tokens. Windows end after the last sentence that fits
+// when one ends in their second half, otherwise between words, and the next
+// window starts at the first sentence or word of the overlap. The text of a
+// window is a slice of its section's text, located by the byte_start and
+// byte_end metadata.
+func BuildChunksWith(secs []Section, cfg cfgpkg.ChunkingCfg, tok Tokenizer) []Chunk {
 	var chunks []Chunk
 	seen := make(map[string]struct{})
 	nextID := 0
@@ -37,7 +55,7 @@ func BuildChunks(secs []Section, cfg cfgpkg.ChunkingCfg) ([]Chunk, error) {
 				chunks = append(chunks, Chunk{
 					ChunkID: nextID,
 					Text:    toc,
-					Tokens:  len(tokenize(toc)),
+					Tokens:  len(tok.Tokenize(toc)),
 					SHA256:  hash,
 					Meta:    map[string]any{"type": "toc"},
 				})
@@ -47,7 +65,10 @@ func BuildChunks(secs []Section, cfg cfgpkg.ChunkingCfg) ([]Chunk, error) {
 		}
 	}
 
-	for _, sec := range secs {
+	sizes := append([]int{cfg.MaxTokens}, cfg.AdditionalMaxTokens...)
+	sort.Ints(sizes)
+	overlap := max(cfg.OverlapTokens, 0)
+	for secIdx, sec := range secs {
 		if cfg.EmbedHeadings && sec.Heading != "" {
 			head := strings.TrimSpace(sec.Heading)
 			hash := HashString(head)
@@ -55,7 +76,7 @@ func BuildChunks(secs []Section, cfg cfgpkg.ChunkingCfg) ([]Chunk, error) {
 				chunks = append(chunks, Chunk{
 					ChunkID: nextID,
 					Text:    head,
-					Tokens:  len(tokenize(head)),
+					Tokens:  len(tok.Tokenize(head)),
 					SHA256:  hash,
 					Meta:    map[string]any{"type": "heading", "heading": sec.Heading},
 				})
@@ -64,98 +85,209 @@ func BuildChunks(secs []Section, cfg cfgpkg.ChunkingCfg) ([]Chunk, error) {
 			}
 		}
 
-		parts := []string{sec.Text}
+		parts := []textRange{trimRange(sec.Text, 0, len(sec.Text))}
 		if cfg.ByParagraph {
 			parts = splitParagraphs(sec.Text)
 		}
+		// offset is the position of the current part's first token within
+		// the section, so that token_start/token_end are section relative.
+		offset := 0
 		for _, part := range parts {
-			tokens := tokenize(part)
+			text := sec.Text[part.start:part.end]
+			tokens := tok.Tokenize(text)
 			if len(tokens) == 0 {
 				continue
 			}
-			sizes := append([]int{cfg.MaxTokens}, cfg.AdditionalMaxTokens...)
-			sort.Ints(sizes)
-			overlap := cfg.OverlapTokens
-			if overlap < 0 {
-				overlap = 0
-			}
+			partOffset := offset
+			offset += len(tokens)
 			for _, step := range sizes {
 				if step <= 0 {
 					step = 800
 				}
-				if len(tokens) <= step {
-					text := strings.TrimSpace(part)
-					hash := HashString(text)
+				for _, w := range windows(text, tokens, step, overlap) {
+					r := trimRange(text, tokens[w.start].Start, tokens[w.end-1].End)
+					sub := text[r.start:r.end]
+					hash := HashString(sub)
 					if _, ok := seen[hash]; ok {
 						continue
 					}
+					meta := windowMeta(sec.Heading, step, summarize(sub, tok), secIdx, partOffset+w.start, partOffset+w.end)
+					meta["byte_start"] = part.start + r.start
+					meta["byte_end"] = part.start + r.end
 					chunks = append(chunks, Chunk{
 						ChunkID: nextID,
-						Text:    text,
-						Tokens:  len(tokens),
+						Text:    sub,
+						Tokens:  w.end - w.start,
 						SHA256:  hash,
-						Meta:    map[string]any{"heading": sec.Heading, "size": step, "summary": summarize(text)},
+						Meta:    meta,
 					})
 					seen[hash] = struct{}{}
 					nextID++
-					continue
-				}
-				start := 0
-				for start < len(tokens) {
-					end := start + step
-					if end > len(tokens) {
-						end = len(tokens)
-					}
-					sub := strings.Join(tokens[start:end], " ")
-					hash := HashString(sub)
-					if _, ok := seen[hash]; !ok {
-						chunks = append(chunks, Chunk{
-							ChunkID: nextID,
-							Text:    sub,
-							Tokens:  end - start,
-							SHA256:  hash,
-							Meta:    map[string]any{"heading": sec.Heading, "size": step, "summary": summarize(sub)},
-						})
-						seen[hash] = struct{}{}
-						nextID++
-					}
-					if end == len(tokens) {
-						break
-					}
-					start = end - overlap
-					if start < 0 {
-						start = 0
-					}
 				}
 			}
 		}
 	}
-	return chunks, nil
+	return chunks
+}
+
+// windowMeta describes a content chunk. section is the index of the source
+// Section in the file and token_start/token_end delimit the chunk's tokens
+// within that section, which lets retrieval stitch neighbouring windows back
+// together.
+func windowMeta(heading string, size int, summary string, section, tokenStart, tokenEnd int) map[string]any {
+	return map[string]any{
+		"heading":     heading,
+		"size":        size,
+		"summary":     summary,
+		"section":     section,
+		"token_start": tokenStart,
+		"token_end":   tokenEnd,
+	}
+}
+
+// textRange is the byte range [start,end) of a text.
+type textRange struct {
+	start, end int
+}
+
+// windows cuts tokens, the tokens of text, into ranges of token indexes of
+// at most size tokens, see BuildChunksWith.
+func windows(text string, tokens []Token, size, overlap int) []textRange {
+	n := len(tokens)
+	var out []textRange
+	for start := 0; ; {
+		if n-start <= size {
+			return append(out, textRange{start, n})
+		}
+		end := snapEnd(text, tokens, start, start+size)
+		out = append(out, textRange{start, end})
+		start = snapStart(text, tokens, max(end-overlap, start+1), end)
+	}
+}
+
+// Quality of a break between two tokens, see breakAt.
+const (
+	breakNone = iota
+	breakToken
+	breakWord
+	breakSentence
+)
+
+// breakAt rates cutting tokens before tokens[i]. Cuts inside a multi-byte
+// character are impossible; cuts after sentence punctuation are preferred,
+// then cuts at whitespace or next to CJK characters, then other token
+// boundaries.
+func breakAt(text string, tokens []Token, i int) int {
+	if i <= 0 || i >= len(tokens) {
+		return breakSentence
+	}
+	pos := tokens[i].Start
+	if !utf8.RuneStart(text[pos]) {
+		return breakNone
+	}
+	before := strings.TrimRightFunc(text[:pos], unicode.IsSpace)
+	spaced := len(before) < pos
+	// Closing quotes and brackets may follow the punctuation.
+	core := strings.TrimRight(before, "\"')]”’」』）")
+	last, _ := utf8.DecodeLastRuneInString(core)
+	switch last {
+	case '。', '！', '？':
+		return breakSentence
+	case '.', '!', '?':
+		// "3.14" and "e.g. this" do not end sentences: the punctuation
+		// must be followed by whitespace and the next word must not start
+		// in lower case.
+		next := strings.TrimLeftFunc(text[pos:], unicode.IsSpace)
+		r, _ := utf8.DecodeRuneInString(next)
+		if (spaced || len(next) < len(text)-pos) && !unicode.IsLower(r) {
+			return breakSentence
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(text[pos:])
+	prev, _ := utf8.DecodeLastRuneInString(text[:pos])
+	if !spaced && unicode.IsPunct(r) {
+		// Keep punctuation with the text it follows.
+		return breakToken
+	}
+	if spaced || unicode.IsSpace(r) || isWide(r) || isWide(prev) {
+		return breakWord
+	}
+	return breakToken
+}
+
+// snapEnd returns where a window starting at token start and ending at the
+// latest at token limit ends: at the best break of its second half, the
+// latest one when several are as good.
+func snapEnd(text string, tokens []Token, start, limit int) int {
+	best, bestQ := -1, breakNone
+	for i := limit; i > start && (i >= start+(limit-start)/2 || best < 0); i-- {
+		if q := breakAt(text, tokens, i); q > bestQ {
+			best, bestQ = i, q
+			if q == breakSentence {
+				break
+			}
+		}
+	}
+	if best < 0 {
+		// Not even one character fits: grow the window to the next
+		// possible break.
+		for best = limit + 1; breakAt(text, tokens, best) == breakNone; best++ {
+		}
+	}
+	return best
 }
 
-func tokenize(s string) []string {
-	if s == "" {
-		return nil
+// snapStart returns where the window after one ending at token end starts:
+// at the best break in [from,end), the earliest one when several are as
+// good, or at end when there is none.
+func snapStart(text string, tokens []Token, from, end int) int {
+	best, bestQ := end, breakNone
+	for i := end - 1; i >= from; i-- {
+		if q := breakAt(text, tokens, i); q > breakNone && q >= bestQ {
+			best, bestQ = i, q
+		}
 	}
-	return strings.Fields(s)
+	return best
+}
+
+// trimRange returns [start,end) of s without leading and trailing
+// whitespace.
+func trimRange(s string, start, end int) textRange {
+	sub := s[start:end]
+	trimmed := strings.TrimLeftFunc(sub, unicode.IsSpace)
+	start += len(sub) - len(trimmed)
+	end = start + len(strings.TrimRightFunc(trimmed, unicode.IsSpace))
+	return textRange{start, end}
 }
 
-func splitParagraphs(s string) []string {
-	parts := strings.Split(s, "\n\n")
-	var res []string
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			res = append(res, p)
+// splitParagraphs returns the byte ranges of the blank line separated
+// paragraphs of s, trimmed.
+func splitParagraphs(s string) []textRange {
+	var res []textRange
+	for start := 0; start <= len(s); {
+		end := strings.Index(s[start:], "\n\n")
+		if end < 0 {
+			end = len(s)
+		} else {
+			end += start
 		}
+		if r := trimRange(s, start, end); r.end > r.start {
+			res = append(res, r)
+		}
+		start = end + 2
 	}
 	return res
 }
 
-func summarize(s string) string {
-	toks := tokenize(s)
-	if len(toks) > 50 {
-		toks = toks[:50]
+// summarize returns the first 50 tokens of s on a single line.
+func summarize(s string, tok Tokenizer) string {
+	if tokens := tok.Tokenize(s); len(tokens) > 50 {
+		// A BPE token may end inside a character.
+		end := tokens[49].End
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		s = s[:end]
 	}
-	return strings.Join(toks, " ")
+	return strings.Join(strings.Fields(s), " ")
 }
@@ -3,6 +3,7 @@ package ingest
 import (
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	cfgpkg "rag-server/internal/rag/config"
 )
@@ -100,3 +101,123 @@ func TestBuildChunksMultiSizes(t *testing.T) {
 		t.Fatalf("sizes not recorded: %v", sizes)
 	}
 }
+
+func TestBuildChunksTokenOffsets(t *testing.T) {
+	secs := []Section{
+		{Heading: "intro", Text: "x y"},
+		{Heading: "h", Text: "a b c\n\nd e f"},
+	}
+	cfg := cfgpkg.ChunkingCfg{MaxTokens: 2, OverlapTokens: 1, ByParagraph: true}
+	chunks, err := BuildChunks(secs, cfg)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	type span struct{ section, start, end int }
+	var got []span
+	for _, c := range chunks {
+		got = append(got, span{c.Meta["section"].(int), c.Meta["token_start"].(int), c.Meta["token_end"].(int)})
+	}
+	want := []span{{0, 0, 2}, {1, 0, 2}, {1, 1, 3}, {1, 3, 5}, {1, 4, 6}}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d chunks, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("chunk %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// checkSlices verifies that every content chunk is the slice of its
+// section's text given by its byte offsets.
+func checkSlices(t *testing.T, secs []Section, chunks []Chunk) {
+	t.Helper()
+	for _, c := range chunks {
+		sec, ok := c.Meta["section"].(int)
+		if !ok {
+			continue
+		}
+		bs, be := c.Meta["byte_start"].(int), c.Meta["byte_end"].(int)
+		if got := secs[sec].Text[bs:be]; got != c.Text {
+			t.Fatalf("chunk %d is %q, bytes [%d,%d) hold %q", c.ChunkID, c.Text, bs, be, got)
+		}
+		if !utf8.ValidString(c.Text) {
+			t.Fatalf("chunk %d is not valid UTF-8: %q", c.ChunkID, c.Text)
+		}
+	}
+}
+
+func TestBuildChunksCJKSentences(t *testing.T) {
+	text := "检索增强生成把文档切成块。每个块单独嵌入！查询时找出最相近的块？" +
+		"然后把它们交给模型回答问题。这一段没有空格。"
+	secs := []Section{{Heading: "概述", Text: text}}
+	for _, overlap := range []int{0, 8} {
+		cfg := cfgpkg.ChunkingCfg{MaxTokens: 20, OverlapTokens: overlap}
+		chunks, err := BuildChunks(secs, cfg)
+		if err != nil {
+			t.Fatalf("build: %v", err)
+		}
+		if len(chunks) < 3 {
+			t.Fatalf("overlap %d: expected the paragraph to be split, got %d chunks", overlap, len(chunks))
+		}
+		checkSlices(t, secs, chunks)
+		prevEnd := 0
+		for i, c := range chunks {
+			if c.Tokens > cfg.MaxTokens {
+				t.Fatalf("overlap %d: chunk %d has %d tokens: %q", overlap, i, c.Tokens, c.Text)
+			}
+			if n := utf8.RuneCountInString(c.Text); n != c.Tokens {
+				t.Fatalf("overlap %d: chunk %d has %d tokens for %d characters", overlap, i, c.Tokens, n)
+			}
+			start := c.Meta["token_start"].(int)
+			if i > 0 && (start > prevEnd || start < prevEnd-overlap) {
+				t.Fatalf("overlap %d: chunk %d starts at token %d, after the chunk ending at %d", overlap, i, start, prevEnd)
+			}
+			// Every sentence fits in a chunk, so without overlap every
+			// chunk is made of whole sentences.
+			last, _ := utf8.DecodeLastRuneInString(c.Text)
+			if overlap == 0 && !strings.ContainsRune("。！？", last) {
+				t.Fatalf("chunk %d does not end a sentence: %q", i, c.Text)
+			}
+			prevEnd = c.Meta["token_end"].(int)
+		}
+	}
+}
+
+func TestBuildChunksSentenceSnapping(t *testing.T) {
+	text := "First sentence is here. Second one, e.g. this, runs on and on. Third."
+	secs := []Section{{Text: text}}
+	chunks, err := BuildChunks(secs, cfgpkg.ChunkingCfg{MaxTokens: 8})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	checkSlices(t, secs, chunks)
+	want := []string{"First sentence is here.", "Second one, e.g. this, runs on and on.", "Third."}
+	var got []string
+	for _, c := range chunks {
+		got = append(got, c.Text)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("chunks = %q, want %q", got, want)
+	}
+}
+
+func TestBuildChunksBPE(t *testing.T) {
+	path := writeVocab(t, "中"[:2], "中", " the")
+	text := strings.Repeat("中文 the words。", 20)
+	secs := []Section{{Heading: "h", Text: text}}
+	cfg := cfgpkg.ChunkingCfg{MaxTokens: 16, OverlapTokens: 4, Tokenizer: "bpe", TokenizerVocab: path}
+	chunks, err := BuildChunks(secs, cfg)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	checkSlices(t, secs, chunks)
+	for i, c := range chunks {
+		if c.Tokens > cfg.MaxTokens {
+			t.Fatalf("chunk %d has %d tokens", i, c.Tokens)
+		}
+	}
+}
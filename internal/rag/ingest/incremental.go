@@ -0,0 +1,177 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	cfgpkg "rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+	rsync "rag-server/internal/rag/sync"
+)
+
+// CommitStore records the last commit ingested for each datasource.
+// store.VectorStore implements it.
+type CommitStore interface {
+	IngestedCommit(ctx context.Context, repo, pathPrefix string) (store.IngestState, error)
+	SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state store.IngestState) error
+}
+
+// ErrConfigChanged is the Plan.Fallback of a run whose chunking or embedding
+// configuration differs from the one the recorded commit was ingested with.
+var ErrConfigChanged = errors.New("chunking or embedding configuration changed")
+
+// ConfigFingerprint identifies the settings that shape the rows of a file:
+// the chunking configuration, tokenizer included, and the embedding model.
+// Files unchanged since a commit ingested with another fingerprint must be
+// ingested again.
+func ConfigFingerprint(chunking cfgpkg.ChunkingCfg, model string) string {
+	b, _ := json.Marshal(struct {
+		Chunking cfgpkg.ChunkingCfg
+		Model    string
+	}{chunking, model})
+	return HashString(string(b))
+}
+
+// Plan lists the files one datasource run ingests.
+type Plan struct {
+	// Files lists the files to ingest.
+	Files []string
+	// Incremental is set when Files comes from a diff against the commit
+	// ingested before.
+	Incremental bool
+	// Paths is set for incremental plans: the stored paths of Files and of
+	// the files deleted upstream, for Pipeline.ReconcilePaths.
+	Paths []string
+	// Fallback is why a full scan replaced the diff, if it did.
+	Fallback error
+}
+
+// PlanSource lists the markdown files of ds in its checkout at workdir. When
+// since is a commit ingested before, only the files changed between since and
+// head are listed, and Paths also holds the files deleted in between. When
+// since is empty or missing from the clone every file is listed.
+func PlanSource(ctx context.Context, workdir string, ds cfgpkg.DataSource, chunkCfg cfgpkg.ChunkingCfg, since, head string, maxFiles int) (Plan, error) {
+	var plan Plan
+	if since != "" {
+		changes, err := rsync.Diff(ctx, workdir, since, head)
+		if err == nil {
+			return diffPlan(workdir, ds, chunkCfg, changes, maxFiles), nil
+		}
+		if !errors.Is(err, rsync.ErrCommitNotFound) {
+			return plan, err
+		}
+		plan.Fallback = err
+	}
+	files, err := ListMarkdown(filepath.Join(workdir, ds.Path), chunkCfg.IncludeExts, chunkCfg.IgnoreDirs, maxFiles)
+	if err != nil {
+		return plan, err
+	}
+	plan.Files = files
+	return plan, nil
+}
+
+// diffPlan keeps the changes that ListMarkdown would have found.
+func diffPlan(workdir string, ds cfgpkg.DataSource, chunkCfg cfgpkg.ChunkingCfg, changes rsync.Changes, maxFiles int) Plan {
+	plan := Plan{Incremental: true}
+	prefix := SourcePrefix(ds)
+	filter := newMarkdownFilter(chunkCfg.IncludeExts, chunkCfg.IgnoreDirs)
+	wanted := func(p string) bool {
+		rel := p
+		if prefix != "" {
+			if !strings.HasPrefix(p, prefix+"/") {
+				return false
+			}
+			rel = strings.TrimPrefix(p, prefix+"/")
+		}
+		return filter.match(rel)
+	}
+	for _, p := range changes.Modified {
+		if !wanted(p) || (maxFiles > 0 && len(plan.Files) >= maxFiles) {
+			continue
+		}
+		plan.Files = append(plan.Files, filepath.Join(workdir, filepath.FromSlash(p)))
+		plan.Paths = append(plan.Paths, p)
+	}
+	for _, p := range changes.Deleted {
+		if wanted(p) {
+			plan.Paths = append(plan.Paths, p)
+		}
+	}
+	return plan
+}
+
+// Source is a datasource checked out at Workdir with Head checked out.
+type Source struct {
+	DataSource cfgpkg.DataSource
+	Workdir    string
+	Head       string
+}
+
+// SourceOptions control RunSource.
+type SourceOptions struct {
+	// Full ingests every file even when a commit was ingested before.
+	Full bool
+	// MaxFiles limits the number of files ingested. A limited run neither
+	// reconciles nor records its commit.
+	MaxFiles int
+}
+
+// RunSource ingests src with p. Unless opt.Full is set, only the files
+// changed since the commit recorded in commits are ingested and the rows of
+// files deleted since are removed; without a usable commit, or when it was
+// ingested with another ConfigFingerprint, every file is. After a run without
+// errors src.Head is recorded with the fingerprint, except for dry runs,
+// which have no p.Upsert. RunSource sets the Repo, Root, PathPrefix and
+// ReconcilePaths fields of p.
+func (p Pipeline) RunSource(ctx context.Context, src Source, commits CommitStore, opt SourceOptions) (Stats, Plan, error) {
+	ds := src.DataSource
+	p.Repo, p.Root, p.PathPrefix = ds.Repo, src.Workdir, SourcePrefix(ds)
+	fingerprint := ConfigFingerprint(p.Chunking, p.Model)
+	var since string
+	var changed bool
+	if !opt.Full && src.Head != "" {
+		prev, err := commits.IngestedCommit(ctx, ds.Repo, p.PathPrefix)
+		if err != nil {
+			return Stats{}, Plan{}, fmt.Errorf("read ingested commit: %w", err)
+		}
+		since = prev.Commit
+		if since != "" && prev.Config != fingerprint {
+			since, changed = "", true
+		}
+	}
+	plan, err := PlanSource(ctx, src.Workdir, ds, p.Chunking, since, src.Head, opt.MaxFiles)
+	if err != nil {
+		return Stats{}, plan, err
+	}
+	if changed {
+		plan.Fallback = ErrConfigChanged
+	}
+	// A list cut short by MaxFiles would make reconciliation delete the
+	// files left out and the next run skip them.
+	limited := opt.MaxFiles > 0 && len(plan.Files) >= opt.MaxFiles
+	if limited {
+		p.Reconcile = nil
+	}
+	p.ReconcilePaths = plan.Paths
+
+	var st Stats
+	if !plan.Incremental || len(plan.Paths) > 0 {
+		if st, err = p.Run(ctx, plan.Files); err != nil {
+			return st, plan, err
+		}
+	}
+	st.Incremental = plan.Incremental
+	if p.Upsert == nil || limited || len(st.Errors) > 0 || src.Head == "" {
+		return st, plan, nil
+	}
+	if err := commits.SetIngestedCommit(ctx, ds.Repo, p.PathPrefix, store.IngestState{Commit: src.Head, Config: fingerprint}); err != nil {
+		st.Errors = append(st.Errors, fmt.Errorf("record ingested commit: %w", err))
+		return st, plan, nil
+	}
+	st.Commit = src.Head
+	return st, plan, nil
+}
@@ -0,0 +1,202 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	cfgpkg "rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+	rsync "rag-server/internal/rag/sync"
+)
+
+// gitRepo is a local repository for incremental ingestion tests.
+type gitRepo struct {
+	t   *testing.T
+	dir string
+	r   *git.Repository
+}
+
+func newGitRepo(t *testing.T) *gitRepo {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gitRepo{t: t, dir: dir, r: r}
+}
+
+// commit writes files, removes those mapped to "" and commits.
+func (g *gitRepo) commit(files map[string]string) string {
+	g.t.Helper()
+	w, err := g.r.Worktree()
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	for name, content := range files {
+		if content == "" {
+			if _, err := w.Remove(name); err != nil {
+				g.t.Fatal(err)
+			}
+			continue
+		}
+		p := filepath.Join(g.dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			g.t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			g.t.Fatal(err)
+		}
+		if _, err := w.Add(name); err != nil {
+			g.t.Fatal(err)
+		}
+	}
+	h, err := w.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	return h.String()
+}
+
+func storedPaths(t *testing.T, mem *store.Memory) []string {
+	t.Helper()
+	hits, err := mem.VectorSearch(context.Background(), []float32{1, 1}, 1000, store.SearchFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, h := range hits {
+		if !seen[h.Path] {
+			seen[h.Path] = true
+			out = append(out, h.Path)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestRunSourceIncremental(t *testing.T) {
+	repo := newGitRepo(t)
+	first := repo.commit(map[string]string{
+		"docs/a.md":        "# A\n\nalpha\n",
+		"docs/b.md":        "# B\n\nbravo\n",
+		"docs/c.md":        "# C\n\ncharlie\n",
+		"docs/notes.txt":   "not markdown",
+		"docs/vendor/v.md": "# V\n\nignored\n",
+		"README.md":        "# Readme\n\noutside the datasource\n",
+	})
+	mem := store.NewMemory()
+	emb := &countingEmbedder{}
+	p := Pipeline{
+		Chunking:  cfgpkg.ChunkingCfg{MaxTokens: 50, IncludeExts: []string{".md"}, IgnoreDirs: []string{"vendor"}},
+		Embedder:  emb,
+		Model:     "test:m",
+		Lookup:    mem.CachedEmbeddings,
+		Upsert:    mem.Upsert,
+		Reconcile: mem.Reconcile,
+	}
+	ds := cfgpkg.DataSource{Name: "kb", Repo: "kb", Path: "docs"}
+	run := func(head string, opt SourceOptions) (Stats, Plan) {
+		t.Helper()
+		st, plan, err := p.RunSource(context.Background(), Source{DataSource: ds, Workdir: repo.dir, Head: head}, mem, opt)
+		if err != nil || len(st.Errors) > 0 {
+			t.Fatalf("RunSource: %v %v", err, st.Errors)
+		}
+		return st, plan
+	}
+
+	st, _ := run(first, SourceOptions{})
+	if st.Incremental || st.FilesScanned != 3 || st.Commit != first {
+		t.Fatalf("unexpected first run %+v", st)
+	}
+	if got, _ := mem.IngestedCommit(context.Background(), "kb", "docs"); got.Commit != first || got.Config != ConfigFingerprint(p.Chunking, p.Model) {
+		t.Fatalf("recorded %+v, want commit %q", got, first)
+	}
+
+	second := repo.commit(map[string]string{
+		"docs/a.md":        "# A\n\nalpha changed\n",
+		"docs/c.md":        "",
+		"docs/d.md":        "# D\n\ndelta\n",
+		"docs/vendor/v.md": "# V\n\nstill ignored\n",
+		"README.md":        "# Readme\n\nchanged outside\n",
+	})
+	emb.texts = nil
+	st, plan := run(second, SourceOptions{})
+	if !st.Incremental || st.FilesScanned != 2 || st.FilesDeleted != 1 || st.Commit != second {
+		t.Fatalf("unexpected incremental run %+v", st)
+	}
+	sort.Strings(plan.Paths)
+	if want := []string{"docs/a.md", "docs/c.md", "docs/d.md"}; !slices.Equal(plan.Paths, want) {
+		t.Fatalf("planned paths %v, want %v", plan.Paths, want)
+	}
+	if len(emb.texts) != 2 {
+		t.Fatalf("embedded %q, want only the changed files", emb.texts)
+	}
+	if got, want := storedPaths(t, mem), []string{"docs/a.md", "docs/b.md", "docs/d.md"}; !slices.Equal(got, want) {
+		t.Fatalf("stored paths %v, want %v", got, want)
+	}
+
+	// Nothing changed: nothing is read.
+	if st, _ = run(second, SourceOptions{}); !st.Incremental || st.FilesScanned != 0 {
+		t.Fatalf("unexpected no-op run %+v", st)
+	}
+
+	// Another configuration falls back to a full scan.
+	p.Chunking.MaxTokens = 60
+	st, plan = run(second, SourceOptions{})
+	if st.Incremental || st.FilesScanned != 3 || !errors.Is(plan.Fallback, ErrConfigChanged) {
+		t.Fatalf("unexpected run after a config change %+v, fallback %v", st, plan.Fallback)
+	}
+	if st, _ = run(second, SourceOptions{}); !st.Incremental || st.FilesScanned != 0 {
+		t.Fatalf("unexpected no-op run after a config change %+v", st)
+	}
+	p.Model = "test:other"
+	if st, plan = run(second, SourceOptions{}); st.Incremental || !errors.Is(plan.Fallback, ErrConfigChanged) {
+		t.Fatalf("unexpected run after a model change %+v, fallback %v", st, plan.Fallback)
+	}
+
+	// A commit missing from the clone falls back to a full scan.
+	state := store.IngestState{Commit: "0123456789012345678901234567890123456789", Config: ConfigFingerprint(p.Chunking, p.Model)}
+	if err := mem.SetIngestedCommit(context.Background(), "kb", "docs", state); err != nil {
+		t.Fatal(err)
+	}
+	st, plan = run(second, SourceOptions{})
+	if st.Incremental || st.FilesScanned != 3 || !errors.Is(plan.Fallback, rsync.ErrCommitNotFound) {
+		t.Fatalf("unexpected fallback run %+v, fallback %v", st, plan.Fallback)
+	}
+
+	// Full ignores the recorded commit.
+	if st, _ = run(second, SourceOptions{Full: true}); st.Incremental || st.FilesScanned != 3 {
+		t.Fatalf("unexpected full run %+v", st)
+	}
+}
+
+func TestRunSourceLimitedKeepsCommit(t *testing.T) {
+	repo := newGitRepo(t)
+	head := repo.commit(map[string]string{"a.md": "# A\n\nalpha\n", "b.md": "# B\n\nbravo\n"})
+	mem := store.NewMemory()
+	p := Pipeline{
+		Chunking:  cfgpkg.ChunkingCfg{MaxTokens: 50, IncludeExts: []string{".md"}},
+		Embedder:  &countingEmbedder{},
+		Upsert:    mem.Upsert,
+		Reconcile: mem.Reconcile,
+	}
+	src := Source{DataSource: cfgpkg.DataSource{Repo: "kb"}, Workdir: repo.dir, Head: head}
+	st, _, err := p.RunSource(context.Background(), src, mem, SourceOptions{MaxFiles: 1})
+	if err != nil || st.FilesScanned != 1 || st.Commit != "" {
+		t.Fatalf("RunSource = %+v, %v", st, err)
+	}
+	if got, _ := mem.IngestedCommit(context.Background(), "kb", ""); got.Commit != "" {
+		t.Fatalf("limited run recorded commit %q", got.Commit)
+	}
+}
@@ -2,11 +2,12 @@ package ingest
 
 import (
 	"context"
+	"errors"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	cfgpkg "rag-server/internal/rag/config"
 	"rag-server/internal/rag/embed"
@@ -17,10 +18,20 @@ import (
 
 // Options control ingestion behaviour.
 type Options struct {
-	MaxFiles    int
-	DryRun      bool
-	MigrateDim  bool
+	MaxFiles   int
+	DryRun     bool
+	MigrateDim bool
+	// Full ingests every file instead of the files changed since the
+	// commit ingested last.
+	Full bool
+	// Concurrency is the number of files parsed and chunked in parallel.
 	Concurrency int
+	// Progress, when set, is called as files finish.
+	Progress func(Progress)
+	// Store receives the chunks instead of the store configured in
+	// vectordb. It is required for the memory backend, which only exists
+	// inside a running process.
+	Store store.VectorStore
 }
 
 // Stats captures pipeline statistics.
@@ -28,13 +39,40 @@ type Stats struct {
 	FilesScanned, FilesSkipped int
 	ChunksBuilt, ChunksSkipped int
 	EmbeddingsCreated          int
-	RowsUpserted               int
-	TokensEstimated            int
-	Elapsed                    time.Duration
-	Errors                     []error
+	// CacheHits counts chunks whose stored embedding was reused instead of
+	// calling the embedder.
+	CacheHits    int
+	RowsUpserted int
+	// RowsDeleted and FilesDeleted count the stale rows removed after the
+	// run and the files that no longer have any row.
+	RowsDeleted, FilesDeleted int
+	// Incremental is set when only the files changed since the last
+	// ingested commit were processed.
+	Incremental bool
+	// Commit is the commit recorded as ingested by this run.
+	Commit          string
+	TokensEstimated int
+	// RateLimitWait is the time embedding requests waited for the
+	// embedding.rate_limit_tpm budget.
+	RateLimitWait time.Duration
+	Elapsed       time.Duration
+	Errors        []error
 }
 
-// IngestRepo performs the full ingestion pipeline for a datasource.
+// SourcePrefix returns the directory, relative to the repository root, that
+// ds ingests, in the form stored in DocRow.Path. It is empty for the whole
+// repository.
+func SourcePrefix(ds cfgpkg.DataSource) string {
+	prefix := filepath.ToSlash(filepath.Clean(ds.Path))
+	if prefix == "." || prefix == "/" {
+		return ""
+	}
+	return strings.TrimPrefix(prefix, "/")
+}
+
+// IngestRepo syncs a datasource and runs its markdown files through a
+// Pipeline, incrementally when a commit was ingested before (see
+// Pipeline.RunSource). Per-file failures are reported in Stats.Errors.
 func IngestRepo(ctx context.Context, cfg *cfgpkg.Config, ds cfgpkg.DataSource, opt Options) (Stats, error) {
 	start := time.Now()
 	var st Stats
@@ -43,97 +81,73 @@ func IngestRepo(ctx context.Context, cfg *cfgpkg.Config, ds cfgpkg.DataSource, o
 	embCfg := cfg.ResolveEmbedding()
 
 	workdir := filepath.Join("internal", "rag", ds.Name)
-	if err := proxy.With(cfg.Sync.Repo.Proxy, func() error {
-		_, err := rsync.SyncRepo(ctx, ds.Repo, workdir)
-		return err
-	}); err != nil {
-		st.Errors = append(st.Errors, err)
-		return st, err
-	}
-
-	root := filepath.Join(workdir, ds.Path)
-	files, err := ListMarkdown(root, chunkCfg.IncludeExts, chunkCfg.IgnoreDirs, opt.MaxFiles)
+	syncOpt, err := rsync.OptionsFor(ds)
 	if err != nil {
 		st.Errors = append(st.Errors, err)
 		return st, err
 	}
-	st.FilesScanned = len(files)
-
-	dsn := cfg.Global.VectorDB.DSN()
-	conn, err := pgx.Connect(ctx, dsn)
-	if err != nil {
-		st.Errors = append(st.Errors, err)
-		return st, err
-	}
-	defer conn.Close(ctx)
-
-	var embedder embed.Embedder
-	switch embCfg.Provider {
-	case "ollama":
-		embedder = embed.NewOllama(embCfg.Endpoint, embCfg.Model, embCfg.Dimension)
-	case "chutes":
-		embedder = embed.NewChutes(embCfg.Endpoint, embCfg.APIKey, embCfg.Dimension)
-	default:
-		if embCfg.Model != "" {
-			embedder = embed.NewOpenAI(embCfg.Endpoint, embCfg.APIKey, embCfg.Model, embCfg.Dimension)
-		} else {
-			embedder = embed.NewBGE(embCfg.Endpoint, embCfg.APIKey, embCfg.Dimension)
-		}
-	}
-	if err := store.EnsureSchema(ctx, conn, embedder.Dimension(), opt.MigrateDim); err != nil {
+	var head string
+	if err := proxy.With(cfg.Sync.Repo.Proxy, func() error {
+		var err error
+		head, err = rsync.SyncRepo(ctx, ds.Repo, workdir, syncOpt)
+		return err
+	}); err != nil {
 		st.Errors = append(st.Errors, err)
 		return st, err
 	}
 
-	for _, f := range files {
-		secs, err := ParseMarkdown(f)
-		if err != nil {
+	vs := opt.Store
+	if vs == nil {
+		if strings.EqualFold(cfg.Global.VectorDB.Backend, store.BackendMemory) {
+			err := errors.New("the memory vectordb backend needs an in-process store")
 			st.Errors = append(st.Errors, err)
-			continue
-		}
-		chunks, err := BuildChunks(secs, chunkCfg)
-		if err != nil {
-			st.Errors = append(st.Errors, err)
-			continue
-		}
-		if len(chunks) == 0 {
-			continue
+			return st, err
 		}
-		st.ChunksBuilt += len(chunks)
-		texts := make([]string, len(chunks))
-		rows := make([]store.DocRow, len(chunks))
-		for i, ch := range chunks {
-			texts[i] = ch.Text
-			rows[i] = store.DocRow{
-				Repo:       ds.Repo,
-				Path:       strings.TrimPrefix(f, workdir+"/"),
-				ChunkID:    ch.ChunkID,
-				Content:    ch.Text,
-				Metadata:   ch.Meta,
-				ContentSHA: ch.SHA256,
-			}
-		}
-		vecs, tokens, err := embedder.Embed(ctx, texts)
+		// The pipeline looks up and upserts rows from several goroutines,
+		// so it needs a pool rather than a single connection.
+		pc, err := cfg.Global.VectorDB.PoolConfig()
 		if err != nil {
 			st.Errors = append(st.Errors, err)
-			continue
-		}
-		st.EmbeddingsCreated += len(vecs)
-		st.TokensEstimated += tokens
-		for i := range rows {
-			rows[i].Embedding = vecs[i]
-		}
-		if opt.DryRun {
-			continue
+			return st, err
 		}
-		n, err := store.UpsertDocuments(ctx, conn, rows)
+		pool, err := pgxpool.NewWithConfig(ctx, pc)
 		if err != nil {
 			st.Errors = append(st.Errors, err)
-			continue
+			return st, err
 		}
-		st.RowsUpserted += n
+		defer pool.Close()
+		vs = store.NewPostgres(pool)
+	}
+
+	embedder, err := embed.New(embCfg)
+	if err != nil {
+		st.Errors = append(st.Errors, err)
+		return st, err
+	}
+	if err := vs.EnsureSchema(ctx, embedder.Dimension(), opt.MigrateDim); err != nil {
+		st.Errors = append(st.Errors, err)
+		return st, err
 	}
 
-	st.Elapsed = time.Since(start)
-	return st, nil
+	p := Pipeline{
+		Chunking:         chunkCfg,
+		Embedder:         embedder,
+		Model:            embed.ModelID(embCfg),
+		Lookup:           vs.CachedEmbeddings,
+		MaxBatch:         embCfg.MaxBatch,
+		Concurrency:      opt.Concurrency,
+		EmbedConcurrency: embCfg.Concurrency,
+		Reconcile:        vs.Reconcile,
+		Progress:         opt.Progress,
+	}
+	if !opt.DryRun {
+		p.Upsert = vs.Upsert
+	}
+	src := Source{DataSource: ds, Workdir: workdir, Head: head}
+	run, _, err := p.RunSource(ctx, src, vs, SourceOptions{Full: opt.Full, MaxFiles: opt.MaxFiles})
+	if err != nil {
+		run.Errors = append(run.Errors, err)
+	}
+	run.Elapsed = time.Since(start)
+	return run, err
 }
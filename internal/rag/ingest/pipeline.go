@@ -0,0 +1,383 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	cfgpkg "rag-server/internal/rag/config"
+	"rag-server/internal/rag/embed"
+	"rag-server/internal/rag/store"
+)
+
+// UpsertFunc stores embedded rows and returns the number written.
+// store.VectorStore.Upsert is one.
+type UpsertFunc func(ctx context.Context, rows []store.DocRow) (int, error)
+
+// ReconcileFunc deletes the rows of a datasource that an ingest run did not
+// produce. store.VectorStore.Reconcile is one.
+type ReconcileFunc func(ctx context.Context, spec store.ReconcileSpec) (store.ReconcileResult, error)
+
+// Progress reports how far a Pipeline run has come. Counts are cumulative.
+type Progress struct {
+	FilesDone, FilesTotal int
+	// Chunks counts the chunks of the finished files.
+	Chunks int
+	// Rows counts the rows written by Upsert.
+	Rows    int
+	Elapsed time.Duration
+}
+
+// FilesPerSecond returns the average file throughput so far.
+func (p Progress) FilesPerSecond() float64 { return perSecond(p.FilesDone, p.Elapsed) }
+
+// ChunksPerSecond returns the average chunk throughput so far.
+func (p Progress) ChunksPerSecond() float64 { return perSecond(p.Chunks, p.Elapsed) }
+
+func perSecond(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+// LogProgress returns a Pipeline.Progress callback that logs the progress of
+// the datasource name at most every five seconds and when the last file is
+// done.
+func LogProgress(name string) func(Progress) {
+	var last time.Time
+	return func(p Progress) {
+		if p.FilesDone < p.FilesTotal && time.Since(last) < 5*time.Second {
+			return
+		}
+		last = time.Now()
+		slog.Info("ingest progress", "repo", name, "files", p.FilesDone, "total", p.FilesTotal, "chunks", p.Chunks, "rows_upserted", p.Rows,
+			"files_per_sec", fmt.Sprintf("%.1f", p.FilesPerSecond()), "chunks_per_sec", fmt.Sprintf("%.1f", p.ChunksPerSecond()))
+	}
+}
+
+// FileError is the error recorded for a file that could not be ingested.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string { return e.Path + ": " + e.Err.Error() }
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// Pipeline ingests markdown files in stages: files are parsed and chunked
+// by parallel workers, the chunks of consecutive files are gathered into
+// groups of at least MaxBatch rows, groups are embedded concurrently and
+// finally upserted one group at a time. Groups are formed and upserted in
+// file order, so the rows written and the errors reported do not depend on
+// scheduling.
+type Pipeline struct {
+	// Repo is stored on every row.
+	Repo string
+	// Root is trimmed from file names to obtain the stored path.
+	Root     string
+	Chunking cfgpkg.ChunkingCfg
+	Embedder embed.Embedder
+	// Model identifies the embedding model, see embed.ModelID.
+	Model string
+	// Lookup, when set, provides embeddings to reuse.
+	Lookup LookupFunc
+	// Upsert stores the rows; nil makes a dry run.
+	Upsert UpsertFunc
+	// Reconcile, when set, is called once every file is done to delete
+	// the rows of Repo under PathPrefix that the run did not write: chunks
+	// of deleted or renamed files and chunks beyond the end of files that
+	// shrank. Rows of files that failed are kept. It is skipped for dry
+	// runs and when ctx ends early, so files must list every file under
+	// PathPrefix, or every one of ReconcilePaths that still exists.
+	Reconcile ReconcileFunc
+	// PathPrefix is the directory, relative to Root, that files were
+	// listed from.
+	PathPrefix string
+	// ReconcilePaths, when set, limits Reconcile to these stored paths, so
+	// that an incremental run can pass only the changed files along with
+	// the deleted ones.
+	ReconcilePaths []string
+	// MaxBatch is the number of rows gathered before a group is embedded
+	// (default embed.DefaultMaxBatch).
+	MaxBatch int
+	// Concurrency is the number of parse workers (default 1).
+	Concurrency int
+	// EmbedConcurrency is the number of groups embedded at the same time
+	// (default 1).
+	EmbedConcurrency int
+	// Progress, when set, is called after every group from a single
+	// goroutine.
+	Progress func(Progress)
+}
+
+type parsedFile struct {
+	idx  int
+	path string
+	rows []store.DocRow
+	err  error
+}
+
+type fileGroup struct {
+	seq    int
+	files  []parsedFile
+	rows   []store.DocRow
+	counts EmbedCounts
+	err    error
+}
+
+// Run ingests files. Failures of single files are collected in
+// Stats.Errors as *FileError in file order and do not stop the run; the
+// returned error is only set when ctx ends first.
+func (p Pipeline) Run(ctx context.Context, files []string) (Stats, error) {
+	start := time.Now()
+	st := Stats{FilesScanned: len(files)}
+	maxBatch := p.MaxBatch
+	if maxBatch <= 0 {
+		maxBatch = embed.DefaultMaxBatch
+	}
+	workers := max(p.Concurrency, 1)
+	embedWorkers := max(p.EmbedConcurrency, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+
+	// Parsing runs ahead of grouping by at most window files so that a slow
+	// file does not let finished ones pile up.
+	window := make(chan struct{}, 2*workers)
+	jobs := make(chan int)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for i := range files {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	parsed := make(chan parsedFile, workers)
+	var parseWG sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		parseWG.Add(1)
+		go func() {
+			defer parseWG.Done()
+			for i := range jobs {
+				pf := p.parse(i, files[i])
+				select {
+				case parsed <- pf:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		parseWG.Wait()
+		close(parsed)
+	}()
+
+	groups := make(chan *fileGroup, embedWorkers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(groups)
+		pending := map[int]parsedFile{}
+		next, seq := 0, 0
+		g := &fileGroup{}
+		emit := func() bool {
+			g.seq = seq
+			seq++
+			select {
+			case groups <- g:
+			case <-ctx.Done():
+				return false
+			}
+			g = &fileGroup{}
+			return true
+		}
+		for pf := range parsed {
+			pending[pf.idx] = pf
+			for {
+				pf, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-window
+				g.files = append(g.files, pf)
+				g.rows = append(g.rows, pf.rows...)
+				if len(g.rows) >= maxBatch && !emit() {
+					return
+				}
+			}
+		}
+		if len(g.files) > 0 && next == len(files) {
+			emit()
+		}
+	}()
+
+	embedded := make(chan *fileGroup, embedWorkers)
+	var embedWG sync.WaitGroup
+	for w := 0; w < embedWorkers; w++ {
+		embedWG.Add(1)
+		go func() {
+			defer embedWG.Done()
+			for g := range groups {
+				if len(g.rows) > 0 {
+					g.counts, g.err = EmbedRows(ctx, p.Embedder, p.Model, g.rows, p.Lookup)
+				}
+				select {
+				case embedded <- g:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		embedWG.Wait()
+		close(embedded)
+	}()
+
+	fileErrs := make([]*FileError, len(files))
+	var keep []string
+	prog := Progress{FilesTotal: len(files)}
+	ready := map[int]*fileGroup{}
+	next := 0
+	for g := range embedded {
+		ready[g.seq] = g
+		for {
+			g, ok := ready[next]
+			if !ok {
+				break
+			}
+			delete(ready, next)
+			next++
+			keep = p.finish(ctx, g, &st, fileErrs, keep)
+			for _, f := range g.files {
+				if f.err == nil {
+					prog.Chunks += len(f.rows)
+				}
+			}
+			prog.FilesDone += len(g.files)
+			prog.Rows = st.RowsUpserted
+			prog.Elapsed = time.Since(start)
+			if p.Progress != nil {
+				p.Progress(prog)
+			}
+		}
+	}
+	wg.Wait()
+
+	var keepPaths []string
+	for _, fe := range fileErrs {
+		if fe != nil {
+			st.Errors = append(st.Errors, fe)
+			keepPaths = append(keepPaths, fe.Path)
+		}
+	}
+	if p.Reconcile != nil && p.Upsert != nil && ctx.Err() == nil {
+		spec := store.ReconcileSpec{Repo: p.Repo, PathPrefix: p.PathPrefix, Keep: keep, KeepPaths: keepPaths, Paths: p.ReconcilePaths}
+		res, err := p.Reconcile(ctx, spec)
+		if err != nil {
+			st.Errors = append(st.Errors, fmt.Errorf("reconcile: %w", err))
+		}
+		st.RowsDeleted, st.FilesDeleted = res.Rows, res.Paths
+	}
+	if w, ok := p.Embedder.(interface{ RateLimitWait() time.Duration }); ok {
+		st.RateLimitWait = w.RateLimitWait()
+	}
+	st.Elapsed = time.Since(start)
+	return st, ctx.Err()
+}
+
+// parse reads and chunks one file.
+func (p Pipeline) parse(idx int, file string) parsedFile {
+	pf := parsedFile{idx: idx, path: file}
+	if p.Root != "" {
+		pf.path = strings.TrimPrefix(file, strings.TrimSuffix(p.Root, "/")+"/")
+	}
+	secs, err := ParseMarkdown(file)
+	if err != nil {
+		pf.err = fmt.Errorf("parse markdown: %w", err)
+		return pf
+	}
+	chunks, err := BuildChunks(secs, p.Chunking)
+	if err != nil {
+		pf.err = fmt.Errorf("build chunks: %w", err)
+		return pf
+	}
+	pf.rows = make([]store.DocRow, len(chunks))
+	for i, ch := range chunks {
+		pf.rows[i] = store.DocRow{
+			Repo:       p.Repo,
+			Path:       pf.path,
+			ChunkID:    ch.ChunkID,
+			Content:    ch.Text,
+			Metadata:   ch.Meta,
+			ContentSHA: ch.SHA256,
+		}
+	}
+	return pf
+}
+
+// finish upserts an embedded group and records its outcome. An embedding or
+// upsert failure is reported for every file of the group. The doc keys of
+// rows written are appended to keep.
+func (p Pipeline) finish(ctx context.Context, g *fileGroup, st *Stats, fileErrs []*FileError, keep []string) []string {
+	for _, f := range g.files {
+		if f.err != nil {
+			fileErrs[f.idx] = &FileError{Path: f.path, Err: f.err}
+		}
+	}
+	fail := func(err error) {
+		for _, f := range g.files {
+			if f.err == nil && len(f.rows) > 0 {
+				fileErrs[f.idx] = &FileError{Path: f.path, Err: err}
+			}
+		}
+	}
+	if len(g.rows) == 0 {
+		return keep
+	}
+	st.ChunksBuilt += len(g.rows)
+	if g.err != nil {
+		fail(fmt.Errorf("embed: %w", g.err))
+		return keep
+	}
+	st.EmbeddingsCreated += g.counts.Created
+	st.CacheHits += g.counts.Reused
+	st.TokensEstimated += g.counts.Tokens
+	if p.Upsert == nil {
+		return keep
+	}
+	if err := ctx.Err(); err != nil {
+		fail(err)
+		return keep
+	}
+	n, err := p.Upsert(ctx, g.rows)
+	if err != nil {
+		fail(fmt.Errorf("upsert: %w", err))
+		return keep
+	}
+	st.RowsUpserted += n
+	for _, r := range g.rows {
+		keep = append(keep, store.DocKey(r.Repo, r.Path, r.ChunkID))
+	}
+	return keep
+}
@@ -0,0 +1,255 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cfgpkg "rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+)
+
+// writeDocs creates n markdown files with two sections each and returns
+// their paths in order.
+func writeDocs(t *testing.T, dir string, n int) []string {
+	t.Helper()
+	var files []string
+	for i := 0; i < n; i++ {
+		f := filepath.Join(dir, fmt.Sprintf("doc%02d.md", i))
+		body := fmt.Sprintf("# Doc %d\n\n## One\n\nfirst section of doc %d\n\n## Two\n\nsecond section of doc %d\n", i, i, i)
+		if err := os.WriteFile(f, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func TestPipelineDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	files := writeDocs(t, dir, 12)
+	// A missing file fails on its own without stopping the others.
+	files = append(files[:5], append([]string{filepath.Join(dir, "missing.md")}, files[5:]...)...)
+
+	run := func(workers, embedWorkers int) ([]string, Stats, []Progress) {
+		var upserted []string
+		var progress []Progress
+		p := Pipeline{
+			Repo:     "kb",
+			Root:     dir,
+			Chunking: cfgpkg.ChunkingCfg{MaxTokens: 50},
+			Embedder: &countingEmbedder{},
+			Model:    "test:m",
+			Upsert: func(ctx context.Context, rows []store.DocRow) (int, error) {
+				for _, r := range rows {
+					upserted = append(upserted, fmt.Sprintf("%s#%d", r.Path, r.ChunkID))
+				}
+				return len(rows), nil
+			},
+			MaxBatch:         5,
+			Concurrency:      workers,
+			EmbedConcurrency: embedWorkers,
+			Progress:         func(p Progress) { progress = append(progress, p) },
+		}
+		st, err := p.Run(context.Background(), files)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return upserted, st, progress
+	}
+
+	want, st, progress := run(1, 1)
+	if st.RowsUpserted != len(want) || st.ChunksBuilt != len(want) || len(want) == 0 {
+		t.Fatalf("unexpected stats %+v for %d rows", st, len(want))
+	}
+	var fe *FileError
+	if len(st.Errors) != 1 || !errors.As(st.Errors[0], &fe) || fe.Path != "missing.md" {
+		t.Fatalf("unexpected errors %v", st.Errors)
+	}
+	last := progress[len(progress)-1]
+	if last.FilesDone != len(files) || last.FilesTotal != len(files) || last.Rows != len(want) || last.Chunks != len(want) {
+		t.Fatalf("unexpected final progress %+v", last)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i].FilesDone <= progress[i-1].FilesDone {
+			t.Fatalf("progress went backwards: %+v", progress)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		got, st, _ := run(4, 3)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("concurrent run upserted\n%v\nwant\n%v", got, want)
+		}
+		if len(st.Errors) != 1 || !strings.Contains(st.Errors[0].Error(), "missing.md") {
+			t.Fatalf("unexpected errors %v", st.Errors)
+		}
+	}
+}
+
+// failingEmbedder fails every request containing a text with bad.
+type failingEmbedder struct {
+	countingEmbedder
+	bad string
+}
+
+func (e *failingEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	for _, in := range inputs {
+		if strings.Contains(in, e.bad) {
+			return nil, 0, errors.New("overloaded")
+		}
+	}
+	return e.countingEmbedder.Embed(ctx, inputs)
+}
+
+func TestPipelineEmbedFailure(t *testing.T) {
+	dir := t.TempDir()
+	files := writeDocs(t, dir, 4)
+	mem := store.NewMemory()
+	p := Pipeline{
+		Repo:     "kb",
+		Root:     dir,
+		Chunking: cfgpkg.ChunkingCfg{MaxTokens: 50},
+		Embedder: &failingEmbedder{bad: "doc 2"},
+		Model:    "test:m",
+		Lookup:   mem.CachedEmbeddings,
+		Upsert:   mem.Upsert,
+		// Two files per group.
+		MaxBatch: 4,
+	}
+	st, err := p.Run(context.Background(), files)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// The group holding doc 2 also holds doc 3; both fail, the others are
+	// stored.
+	if len(st.Errors) != 2 || !strings.Contains(st.Errors[0].Error(), "doc02.md") || !strings.Contains(st.Errors[1].Error(), "doc03.md") {
+		t.Fatalf("unexpected errors %v", st.Errors)
+	}
+	if st.RowsUpserted != st.ChunksBuilt/2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestPipelineCanceled(t *testing.T) {
+	files := writeDocs(t, t.TempDir(), 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Pipeline{
+		Chunking: cfgpkg.ChunkingCfg{MaxTokens: 50},
+		Embedder: &countingEmbedder{},
+		MaxBatch: 1,
+		Progress: func(Progress) { cancel() },
+	}
+	if _, err := p.Run(ctx, files); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPipelineReconcile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		t.Helper()
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	mem := store.NewMemory()
+	run := func(emb *failingEmbedder, files ...string) Stats {
+		t.Helper()
+		p := Pipeline{
+			Repo:      "kb",
+			Root:      dir,
+			Chunking:  cfgpkg.ChunkingCfg{MaxTokens: 50},
+			Embedder:  emb,
+			Model:     "test:m",
+			Lookup:    mem.CachedEmbeddings,
+			Upsert:    mem.Upsert,
+			Reconcile: mem.Reconcile,
+			MaxBatch:  1,
+		}
+		st, err := p.Run(context.Background(), files)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return st
+	}
+
+	a := write("a.md", "# A\n\n## One\n\nalpha one\n\n## Two\n\nalpha two\n")
+	b := write("b.md", "# B\n\nbravo\n")
+	c := write("c.md", "# C\n\ncharlie\n")
+	st := run(&failingEmbedder{bad: "\x00"}, a, b, c)
+	if st.RowsDeleted != 0 || len(st.Errors) != 0 {
+		t.Fatalf("unexpected first run %+v", st)
+	}
+	keys := func() map[string]bool {
+		hits, _ := mem.VectorSearch(context.Background(), []float32{1, 1}, 100, store.SearchFilter{})
+		out := map[string]bool{}
+		for _, h := range hits {
+			out[fmt.Sprintf("%s#%d", h.Path, h.ChunkID)] = true
+		}
+		return out
+	}
+	before := keys()
+
+	// a.md shrinks, b.md is renamed to d.md and the new version of c.md
+	// fails to embed.
+	a = write("a.md", "# A\n\nalpha\n")
+	c = write("c.md", "# C\n\ncharlie again\n")
+	if err := os.Rename(b, filepath.Join(dir, "d.md")); err != nil {
+		t.Fatal(err)
+	}
+	emb := &failingEmbedder{bad: "charlie"}
+	st = run(emb, a, c, filepath.Join(dir, "d.md"))
+	if len(st.Errors) != 1 || !strings.Contains(st.Errors[0].Error(), "c.md") {
+		t.Fatalf("unexpected errors %v", st.Errors)
+	}
+	after := keys()
+	gone := 0
+	for k := range before {
+		if !after[k] {
+			gone++
+		}
+	}
+	if st.FilesDeleted != 1 || st.RowsDeleted != gone || gone < 2 {
+		t.Fatalf("unexpected deletions %+v, before %v, after %v", st, before, after)
+	}
+	if !after["d.md#0"] || after["b.md#0"] || !after["c.md#0"] || after["a.md#1"] {
+		t.Fatalf("unexpected rows after reconcile %v", after)
+	}
+	// The renamed file reuses the embedding of its old path.
+	for _, text := range emb.texts {
+		if strings.Contains(text, "bravo") {
+			t.Fatalf("renamed file was embedded again: %q", emb.texts)
+		}
+	}
+}
+
+func TestLogProgress(t *testing.T) {
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(old)
+
+	logf := LogProgress("docs")
+	for done := 1; done <= 3; done++ {
+		logf(Progress{FilesDone: done, FilesTotal: 3, Elapsed: time.Second})
+	}
+	// The first call logs, the second is within five seconds of it and the
+	// last one reports the final file.
+	if n := strings.Count(buf.String(), "ingest progress"); n != 2 {
+		t.Fatalf("logged %d lines, want 2:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "repo=docs files=3 total=3") {
+		t.Fatalf("final progress not logged:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,89 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+
+	"rag-server/internal/rag/embed"
+	"rag-server/internal/rag/store"
+)
+
+// LookupFunc returns reusable embeddings made by model, keyed by content SHA.
+// store.VectorStore.CachedEmbeddings is one.
+type LookupFunc func(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error)
+
+// EmbedCounts reports how EmbedRows obtained the embeddings.
+type EmbedCounts struct {
+	// Reused counts rows whose embedding came from lookup or from an
+	// identical chunk earlier in rows.
+	Reused int
+	// Created counts texts sent to the embedder.
+	Created int
+	// Tokens is the usage reported by the embedder.
+	Tokens int
+}
+
+// EmbedRows sets Embedding and EmbeddingModel on rows. Embeddings returned by
+// lookup are reused and only the remaining distinct texts are embedded. A
+// failing lookup is treated as a miss so that ingestion still succeeds.
+func EmbedRows(ctx context.Context, emb embed.Embedder, model string, rows []store.DocRow, lookup LookupFunc) (EmbedCounts, error) {
+	var c EmbedCounts
+	cached := map[string][]float32{}
+	if lookup != nil && len(rows) > 0 {
+		keys := make([]store.EmbeddingKey, len(rows))
+		for i, r := range rows {
+			keys[i] = store.EmbeddingKey{DocKey: store.DocKey(r.Repo, r.Path, r.ChunkID), ContentSHA: r.ContentSHA}
+		}
+		if got, err := lookup(ctx, model, keys); err == nil {
+			cached = got
+		}
+	}
+	// Cached vectors of another dimension were made by a different model
+	// and are ignored.
+	if dim := emb.Dimension(); dim > 0 {
+		for sha, vec := range cached {
+			if len(vec) != dim {
+				delete(cached, sha)
+			}
+		}
+	}
+
+	var texts []string
+	pending := map[string]int{}
+	for _, r := range rows {
+		if _, ok := cached[r.ContentSHA]; ok {
+			continue
+		}
+		if _, ok := pending[r.ContentSHA]; ok {
+			continue
+		}
+		pending[r.ContentSHA] = len(texts)
+		texts = append(texts, r.Content)
+	}
+	var vecs [][]float32
+	if len(texts) > 0 {
+		var err error
+		vecs, c.Tokens, err = emb.Embed(ctx, texts)
+		if err != nil {
+			return c, err
+		}
+		if len(vecs) != len(texts) {
+			return c, fmt.Errorf("embedding count mismatch: got %d, want %d", len(vecs), len(texts))
+		}
+		c.Created = len(vecs)
+	}
+	for i := range rows {
+		sha := rows[i].ContentSHA
+		if vec, ok := cached[sha]; ok {
+			rows[i].Embedding = vec
+			c.Reused++
+		} else {
+			rows[i].Embedding = vecs[pending[sha]]
+			// Later rows with the same content reuse this vector.
+			cached[sha] = rows[i].Embedding
+			delete(pending, sha)
+		}
+		rows[i].EmbeddingModel = model
+	}
+	return c, nil
+}
@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"rag-server/internal/rag/store"
+)
+
+// countingEmbedder embeds every text as [len(text), 1] and records the texts.
+type countingEmbedder struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	e.mu.Lock()
+	e.texts = append(e.texts, inputs...)
+	e.mu.Unlock()
+	out := make([][]float32, len(inputs))
+	for i, in := range inputs {
+		out[i] = []float32{float32(len(in)), 1}
+	}
+	return out, len(inputs), nil
+}
+
+func (e *countingEmbedder) Dimension() int { return 2 }
+
+func TestEmbedRowsReusesStoredEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	rows := []store.DocRow{
+		{Repo: "kb", Path: "a.md", ChunkID: 0, Content: "alpha", ContentSHA: HashString("alpha")},
+		{Repo: "kb", Path: "a.md", ChunkID: 1, Content: "beta", ContentSHA: HashString("beta")},
+		{Repo: "kb", Path: "a.md", ChunkID: 2, Content: "alpha", ContentSHA: HashString("alpha")},
+	}
+	emb := &countingEmbedder{}
+	c, err := EmbedRows(ctx, emb, "test:m", rows, mem.CachedEmbeddings)
+	if err != nil {
+		t.Fatalf("EmbedRows: %v", err)
+	}
+	if c.Created != 2 || c.Reused != 1 || len(emb.texts) != 2 {
+		t.Fatalf("unexpected counts %+v, embedded %q", c, emb.texts)
+	}
+	if rows[2].Embedding[0] != 5 || rows[2].EmbeddingModel != "test:m" {
+		t.Fatalf("unexpected row %+v", rows[2])
+	}
+	if _, err := mem.Upsert(ctx, rows); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+
+	// Another repo with one known and one new chunk.
+	other := []store.DocRow{
+		{Repo: "docs", Path: "b.md", ChunkID: 0, Content: "beta", ContentSHA: HashString("beta")},
+		{Repo: "docs", Path: "b.md", ChunkID: 1, Content: "gamma!", ContentSHA: HashString("gamma!")},
+	}
+	emb = &countingEmbedder{}
+	c, err = EmbedRows(ctx, emb, "test:m", other, mem.CachedEmbeddings)
+	if err != nil {
+		t.Fatalf("EmbedRows: %v", err)
+	}
+	if c.Created != 1 || c.Reused != 1 || len(emb.texts) != 1 || emb.texts[0] != "gamma!" {
+		t.Fatalf("unexpected counts %+v, embedded %q", c, emb.texts)
+	}
+	if other[0].Embedding[0] != 4 {
+		t.Fatalf("unexpected reused embedding %v", other[0].Embedding)
+	}
+
+	// A different model re-embeds everything.
+	emb = &countingEmbedder{}
+	if c, _ = EmbedRows(ctx, emb, "test:other", other, mem.CachedEmbeddings); c.Reused != 0 || c.Created != 2 {
+		t.Fatalf("unexpected counts %+v", c)
+	}
+}
+
+func TestEmbedRowsLookupFailure(t *testing.T) {
+	rows := []store.DocRow{{Repo: "kb", Path: "a.md", Content: "alpha", ContentSHA: "a"}}
+	lookup := func(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error) {
+		return nil, errors.New("unavailable")
+	}
+	c, err := EmbedRows(context.Background(), &countingEmbedder{}, "test:m", rows, lookup)
+	if err != nil || c.Created != 1 || len(rows[0].Embedding) != 2 {
+		t.Fatalf("EmbedRows = %+v, %v", c, err)
+	}
+}
+
+func TestEmbedRowsIgnoresOtherDimensions(t *testing.T) {
+	rows := []store.DocRow{{Repo: "kb", Path: "a.md", Content: "alpha", ContentSHA: "a"}}
+	lookup := func(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error) {
+		return map[string][]float32{"a": {1, 2, 3}}, nil
+	}
+	c, err := EmbedRows(context.Background(), &countingEmbedder{}, "test:m", rows, lookup)
+	if err != nil || c.Reused != 0 || len(rows[0].Embedding) != 2 {
+		t.Fatalf("EmbedRows = %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,300 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	cfgpkg "rag-server/internal/rag/config"
+)
+
+// Tokenizer names for ChunkingCfg.Tokenizer.
+const (
+	// TokenizerCJK splits on whitespace and counts every CJK character as a
+	// token of its own. It is the default.
+	TokenizerCJK = "cjk"
+	// TokenizerBPE applies byte pair encoding with a tiktoken vocabulary.
+	TokenizerBPE = "bpe"
+)
+
+// Token locates a token within the text it was cut from. Start and End are
+// byte offsets.
+type Token struct {
+	Start, End int
+}
+
+// Tokenizer splits text into the tokens that chunk sizes are measured in.
+// Tokens are returned in order and do not overlap; text between them, such
+// as whitespace, belongs to no token.
+type Tokenizer interface {
+	Tokenize(text string) []Token
+}
+
+// NewTokenizer returns the tokenizer selected by cfg. BPE vocabularies are
+// loaded once per file and shared.
+func NewTokenizer(cfg cfgpkg.ChunkingCfg) (Tokenizer, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Tokenizer)) {
+	case "", TokenizerCJK:
+		return CJK{}, nil
+	case TokenizerBPE:
+		if cfg.TokenizerVocab == "" {
+			return nil, fmt.Errorf("the %s tokenizer needs chunking.tokenizer_vocab", TokenizerBPE)
+		}
+		return loadBPECached(cfg.TokenizerVocab)
+	default:
+		return nil, fmt.Errorf("unknown tokenizer %q (want %s or %s)", cfg.Tokenizer, TokenizerCJK, TokenizerBPE)
+	}
+}
+
+// CJK is a Tokenizer for text that mixes space separated words with
+// Chinese, Japanese or Korean. Runs of other non-space characters are one
+// token each, the way strings.Fields splits them, while every CJK
+// character and every full-width punctuation mark is a token by itself.
+type CJK struct{}
+
+// Tokenize implements Tokenizer.
+func (CJK) Tokenize(text string) []Token {
+	var out []Token
+	start := -1
+	for i, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			if start >= 0 {
+				out = append(out, Token{start, i})
+				start = -1
+			}
+		case isWide(r):
+			if start >= 0 {
+				out = append(out, Token{start, i})
+				start = -1
+			}
+			out = append(out, Token{i, i + utf8.RuneLen(r)})
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		out = append(out, Token{start, len(text)})
+	}
+	return out
+}
+
+// isWide reports whether r is written without spaces around it: a CJK
+// ideograph, kana, hangul or CJK and full-width punctuation.
+func isWide(r rune) bool {
+	if r < 0x2E80 {
+		return false
+	}
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// BPE is a byte pair encoding Tokenizer. Text is first split into words the
+// way tiktoken's cl100k_base pattern does, then the bytes of every word are
+// merged pairwise, lowest rank first, while the merged sequence is in the
+// vocabulary. Tokens may end inside a multi-byte character.
+type BPE struct {
+	ranks map[string]int
+}
+
+// LoadBPE reads a vocabulary in the tiktoken format: one token per line,
+// base64 encoded, followed by a space and its rank.
+func LoadBPE(path string) (*BPE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ranks := map[string]int{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		tok, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want a token and a rank", path, n)
+		}
+		b, err := base64.StdEncoding.DecodeString(tok)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		r, err := strconv.Atoi(strings.TrimSpace(rank))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		ranks[string(b)] = r
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("%s: empty vocabulary", path)
+	}
+	return &BPE{ranks: ranks}, nil
+}
+
+var (
+	bpeMu    sync.Mutex
+	bpeCache = map[string]*BPE{}
+)
+
+func loadBPECached(path string) (*BPE, error) {
+	bpeMu.Lock()
+	defer bpeMu.Unlock()
+	if b, ok := bpeCache[path]; ok {
+		return b, nil
+	}
+	b, err := LoadBPE(path)
+	if err != nil {
+		return nil, fmt.Errorf("load tokenizer vocabulary: %w", err)
+	}
+	bpeCache[path] = b
+	return b, nil
+}
+
+// Tokenize implements Tokenizer.
+func (b *BPE) Tokenize(text string) []Token {
+	var out []Token
+	for start := 0; start < len(text); {
+		end := nextWord(text, start)
+		out = b.merge(text, start, end, out)
+		start = end
+	}
+	return out
+}
+
+// merge appends the tokens of the word text[start:end].
+func (b *BPE) merge(text string, start, end int, out []Token) []Token {
+	if _, ok := b.ranks[text[start:end]]; ok {
+		return append(out, Token{start, end})
+	}
+	// bounds[i] is where the i-th part starts; the last entry is end.
+	bounds := make([]int, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		bounds = append(bounds, i)
+	}
+	rank := func(i int) int {
+		if i+2 >= len(bounds) {
+			return -1
+		}
+		if r, ok := b.ranks[text[bounds[i]:bounds[i+2]]]; ok {
+			return r
+		}
+		return -1
+	}
+	// ranks[i] is the rank of merging parts i and i+1, -1 if they cannot be.
+	ranks := make([]int, len(bounds)-1)
+	for i := range ranks {
+		ranks[i] = rank(i)
+	}
+	for {
+		best := -1
+		for i, r := range ranks[:len(ranks)-1] {
+			if r >= 0 && (best < 0 || r < ranks[best]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+		ranks = append(ranks[:best+1], ranks[best+2:]...)
+		ranks[best] = rank(best)
+		if best > 0 {
+			ranks[best-1] = rank(best - 1)
+		}
+	}
+	for i := 0; i+1 < len(bounds); i++ {
+		out = append(out, Token{bounds[i], bounds[i+1]})
+	}
+	return out
+}
+
+// nextWord returns the end of the word starting at i, following the
+// cl100k_base split pattern: contractions, letters with one leading
+// non-letter, up to three digits, punctuation with an optional leading
+// space and trailing newlines, and whitespace, where a run of spaces leaves
+// its last one to the word after it.
+func nextWord(s string, i int) int {
+	r, n := utf8.DecodeRuneInString(s[i:])
+	// next decodes the rune at j, -1 at the end of s. Invalid bytes decode
+	// as utf8.RuneError of size 1.
+	next := func(j int) (rune, int) {
+		if j >= len(s) {
+			return -1, 0
+		}
+		return utf8.DecodeRuneInString(s[j:])
+	}
+	isLetter := func(r rune) bool { return r >= 0 && unicode.IsLetter(r) }
+	isNumber := func(r rune) bool { return r >= 0 && unicode.IsNumber(r) }
+	isSpace := func(r rune) bool { return r >= 0 && unicode.IsSpace(r) }
+	punct := func(r rune) bool { return r >= 0 && !isSpace(r) && !isLetter(r) && !isNumber(r) }
+	// run advances j over at most limit runes matching class.
+	run := func(j, limit int, class func(rune) bool) int {
+		for k := 0; k != limit; k++ {
+			r, size := next(j)
+			if !class(r) {
+				break
+			}
+			j += size
+		}
+		return j
+	}
+
+	if r == '\'' {
+		rest := strings.ToLower(s[i+1 : min(i+3, len(s))])
+		for _, c := range []string{"ll", "re", "ve", "s", "t", "m", "d"} {
+			if strings.HasPrefix(rest, c) {
+				return i + 1 + len(c)
+			}
+		}
+	}
+	switch after, _ := next(i + n); {
+	case isLetter(r):
+		return run(i, -1, isLetter)
+	case r != '\r' && r != '\n' && !isNumber(r) && isLetter(after):
+		return run(i+n, -1, isLetter)
+	case isNumber(r):
+		return run(i, 3, isNumber)
+	case punct(r) || (r == ' ' && punct(after)):
+		j := i
+		if r == ' ' {
+			j += n
+		}
+		j = run(j, -1, punct)
+		return run(j, -1, func(r rune) bool { return r == '\r' || r == '\n' })
+	}
+
+	// Whitespace.
+	j, lastNL := i, -1
+	for {
+		c, size := next(j)
+		if !isSpace(c) {
+			break
+		}
+		j += size
+		if c == '\r' || c == '\n' {
+			lastNL = j
+		}
+	}
+	switch {
+	case lastNL > 0:
+		return lastNL
+	case j < len(s) && j-i > n:
+		// Leave the last space to the word that follows.
+		_, size := utf8.DecodeLastRuneInString(s[i:j])
+		return j - size
+	default:
+		return j
+	}
+}
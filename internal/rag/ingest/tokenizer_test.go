@@ -0,0 +1,141 @@
+package ingest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	cfgpkg "rag-server/internal/rag/config"
+)
+
+func tokenTexts(text string, toks []Token) []string {
+	out := make([]string, len(toks))
+	for i, t := range toks {
+		out[i] = text[t.Start:t.End]
+	}
+	return out
+}
+
+func TestCJKTokenize(t *testing.T) {
+	text := "部署 rag-server，运行make deploy。  Done!"
+	got := tokenTexts(text, CJK{}.Tokenize(text))
+	want := []string{"部", "署", "rag-server", "，", "运", "行", "make", "deploy", "。", "Done!"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tokenize = %q, want %q", got, want)
+	}
+	if toks := (CJK{}).Tokenize(" \n\t"); len(toks) != 0 {
+		t.Fatalf("whitespace yields tokens %v", toks)
+	}
+}
+
+// writeVocab writes a tiktoken vocabulary with every single byte followed
+// by merges, ranked in order.
+func writeVocab(t *testing.T, merges ...string) string {
+	t.Helper()
+	var b strings.Builder
+	rank := 0
+	add := func(tok string) {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(tok)), rank)
+		rank++
+	}
+	for i := 0; i < 256; i++ {
+		add(string([]byte{byte(i)}))
+	}
+	for _, m := range merges {
+		add(m)
+	}
+	path := filepath.Join(t.TempDir(), "vocab.tiktoken")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBPETokenize(t *testing.T) {
+	path := writeVocab(t, "th", "the", "ther", "中"[:2], "中")
+	bpe, err := LoadBPE(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "the other 中文 12345"
+	got := tokenTexts(text, bpe.Tokenize(text))
+	// "ther" is merged through "th" and "the". "中" is merged from its
+	// first two bytes and its last one, while "文" is not in the vocabulary
+	// and stays three bytes. Digits are split into words of three.
+	want := []string{"the", " ", "o", "ther", " ", "中", "\xe6", "\x96", "\x87", " ", "1", "2", "3", "4", "5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tokenize = %q, want %q", got, want)
+	}
+	if strings.Join(got, "") != text {
+		t.Fatalf("tokens %q do not cover the text", got)
+	}
+}
+
+func TestTokenizeInvalidUTF8(t *testing.T) {
+	bpe, err := LoadBPE(writeVocab(t, "ab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"ab\xff", "\xff\xfe ab", "caf\xe9 12\xff3 \xff\n\xff", "!\xff?", "'\xff", " \xff\xff"} {
+		for name, tok := range map[string]Tokenizer{"bpe": bpe, "cjk": CJK{}} {
+			var b strings.Builder
+			prev := 0
+			for _, tk := range tok.Tokenize(text) {
+				if tk.Start < prev || tk.End <= tk.Start || tk.End > len(text) {
+					t.Fatalf("%s: bad token %+v in %q", name, tk, text)
+				}
+				b.WriteString(text[prev:tk.End])
+				prev = tk.End
+			}
+			if b.String()+text[prev:] != text {
+				t.Fatalf("%s: tokens of %q do not cover it", name, text)
+			}
+		}
+	}
+	secs := []Section{{Text: strings.Repeat("caf\xe9 \xffab. ", 40)}}
+	cfg := cfgpkg.ChunkingCfg{MaxTokens: 10, OverlapTokens: 2, Tokenizer: "bpe", TokenizerVocab: writeVocab(t, "ab")}
+	if _, err := BuildChunks(secs, cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextWord(t *testing.T) {
+	text := "Hello, world's  end\n\n  42195 ok"
+	var got []string
+	for i := 0; i < len(text); {
+		j := nextWord(text, i)
+		got = append(got, text[i:j])
+		i = j
+	}
+	want := []string{"Hello", ",", " world", "'s", " ", " end", "\n\n", " ", " ", "421", "95", " ok"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("words = %q, want %q", got, want)
+	}
+}
+
+func TestNewTokenizer(t *testing.T) {
+	if tok, err := NewTokenizer(cfgpkg.ChunkingCfg{}); err != nil || tok != (CJK{}) {
+		t.Fatalf("default tokenizer = %v, %v", tok, err)
+	}
+	if _, err := NewTokenizer(cfgpkg.ChunkingCfg{Tokenizer: "bpe"}); err == nil {
+		t.Fatal("expected an error without a vocabulary")
+	}
+	if _, err := NewTokenizer(cfgpkg.ChunkingCfg{Tokenizer: "bpe", TokenizerVocab: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Fatal("expected an error for a missing vocabulary")
+	}
+	if _, err := NewTokenizer(cfgpkg.ChunkingCfg{Tokenizer: "words"}); err == nil {
+		t.Fatal("expected an error for an unknown tokenizer")
+	}
+	path := writeVocab(t)
+	a, err := NewTokenizer(cfgpkg.ChunkingCfg{Tokenizer: "BPE", TokenizerVocab: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := NewTokenizer(cfgpkg.ChunkingCfg{Tokenizer: "bpe", TokenizerVocab: path}); a != b {
+		t.Fatal("vocabulary loaded twice")
+	}
+}
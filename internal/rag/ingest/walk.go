@@ -3,24 +3,49 @@ package ingest
 import (
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
-// ListMarkdown walks root and returns markdown files respecting include and ignore lists.
-// If maxFiles > 0 the result is limited to at most that many paths.
-func ListMarkdown(root string, includeExts, ignoreDirs []string, maxFiles int) ([]string, error) {
-	var files []string
-	include := make(map[string]struct{})
+// markdownFilter selects files by extension and skips ignored directories.
+type markdownFilter struct {
+	include map[string]struct{}
+	ignores map[string]struct{}
+}
+
+func newMarkdownFilter(includeExts, ignoreDirs []string) markdownFilter {
+	f := markdownFilter{include: map[string]struct{}{}, ignores: map[string]struct{}{}}
 	for _, e := range includeExts {
-		include[strings.ToLower(e)] = struct{}{}
+		f.include[strings.ToLower(e)] = struct{}{}
 	}
-	ignores := make(map[string]struct{})
 	for _, d := range ignoreDirs {
-		ignores[d] = struct{}{}
+		f.ignores[d] = struct{}{}
+	}
+	return f
+}
+
+// match reports whether ListMarkdown would return the file at rel, a
+// slash-separated path relative to the walk root.
+func (f markdownFilter) match(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if _, ok := f.ignores[dir]; ok {
+			return false
+		}
 	}
+	_, ok := f.include[strings.ToLower(path.Ext(rel))]
+	return ok
+}
+
+// ListMarkdown walks root and returns markdown files respecting include and ignore lists.
+// If maxFiles > 0 the result is limited to at most that many paths.
+func ListMarkdown(root string, includeExts, ignoreDirs []string, maxFiles int) ([]string, error) {
+	var files []string
+	filter := newMarkdownFilter(includeExts, ignoreDirs)
+	include, ignores := filter.include, filter.ignores
 
-	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
@@ -33,9 +58,9 @@ func ListMarkdown(root string, includeExts, ignoreDirs []string, maxFiles int) (
 		if maxFiles > 0 && len(files) >= maxFiles {
 			return io.EOF
 		}
-		ext := strings.ToLower(filepath.Ext(path))
+		ext := strings.ToLower(filepath.Ext(p))
 		if _, ok := include[ext]; ok {
-			files = append(files, path)
+			files = append(files, p)
 		}
 		return nil
 	})
@@ -0,0 +1,83 @@
+package rag
+
+import (
+	"math"
+
+	"rag-server/internal/rag/store"
+)
+
+// DefaultMMRLambda weights relevance over novelty when MMR is enabled without
+// an explicit lambda.
+const DefaultMMRLambda = 0.7
+
+// diversify selects up to limit documents from ranked, which must be sorted
+// by descending Score. At most maxPerPath documents are taken from any one
+// file when maxPerPath is positive.
+//
+// With vecs set, documents are picked by Maximal Marginal Relevance:
+//
+//	lambda*relevance(d) - (1-lambda)*max cosine(d, selected)
+//
+// where relevance is Score min-max scaled to [0,1], so reranker and fusion
+// order is respected. Without vecs the ranked order is kept and only the path
+// cap applies.
+func diversify(ranked []*Document, vecs map[string][]float32, lambda float64, limit, maxPerPath int) []*Document {
+	perPath := map[string]int{}
+	allowed := func(d *Document) bool {
+		return maxPerPath <= 0 || perPath[d.Repo+"\x00"+d.Path] < maxPerPath
+	}
+	take := func(d *Document) {
+		perPath[d.Repo+"\x00"+d.Path]++
+	}
+
+	out := make([]*Document, 0, limit)
+	if vecs == nil {
+		for _, d := range ranked {
+			if len(out) == limit {
+				break
+			}
+			if allowed(d) {
+				take(d)
+				out = append(out, d)
+			}
+		}
+		return out
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, d := range ranked {
+		lo = math.Min(lo, d.Score)
+		hi = math.Max(hi, d.Score)
+	}
+	relevance := func(d *Document) float64 {
+		if hi > lo {
+			return (d.Score - lo) / (hi - lo)
+		}
+		return 1
+	}
+
+	used := make([]bool, len(ranked))
+	for len(out) < limit {
+		best, bestScore := -1, math.Inf(-1)
+		for i, d := range ranked {
+			if used[i] || !allowed(d) {
+				continue
+			}
+			redundancy := 0.0
+			for _, s := range out {
+				redundancy = math.Max(redundancy, store.Cosine(vecs[d.DocKey], vecs[s.DocKey]))
+			}
+			score := lambda*relevance(d) - (1-lambda)*redundancy
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		take(ranked[best])
+		out = append(out, ranked[best])
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func keys(docs []*Document) string {
+	out := make([]string, len(docs))
+	for i, d := range docs {
+		out[i] = d.DocKey
+	}
+	return strings.Join(out, ",")
+}
+
+func TestDiversifyMMR(t *testing.T) {
+	// a1 and a2 are near-identical windows of the same section; b is a
+	// slightly less relevant chunk about something else.
+	ranked := []*Document{
+		{DocKey: "a1", Path: "a.md", Score: 1.0},
+		{DocKey: "a2", Path: "a.md", Score: 0.95},
+		{DocKey: "b", Path: "b.md", Score: 0.8},
+		{DocKey: "c", Path: "c.md", Score: 0.0},
+	}
+	vecs := map[string][]float32{
+		"a1": {1, 0, 0},
+		"a2": {0.99, 0.05, 0},
+		"b":  {0, 1, 0},
+		"c":  {0, 0.7, 0.7},
+	}
+
+	if got := keys(diversify(ranked, vecs, 0.5, 2, 0)); got != "a1,b" {
+		t.Fatalf("expected MMR to skip the duplicate window, got %s", got)
+	}
+	if got := keys(diversify(ranked, vecs, 1, 3, 0)); got != "a1,a2,b" {
+		t.Fatalf("lambda=1 should keep relevance order, got %s", got)
+	}
+}
+
+func TestDiversifyMaxPerPath(t *testing.T) {
+	ranked := []*Document{
+		{DocKey: "a1", Path: "a.md", Score: 0.9},
+		{DocKey: "a2", Path: "a.md", Score: 0.8},
+		{DocKey: "a3", Path: "a.md", Score: 0.7},
+		{DocKey: "b1", Path: "b.md", Score: 0.6},
+		{DocKey: "x1", Repo: "other", Path: "a.md", Score: 0.5},
+	}
+	if got := keys(diversify(ranked, nil, 0, 3, 1)); got != "a1,b1,x1" {
+		t.Fatalf("unexpected capped selection %s", got)
+	}
+	if got := keys(diversify(ranked, nil, 0, 4, 2)); got != "a1,a2,b1,x1" {
+		t.Fatalf("unexpected capped selection %s", got)
+	}
+	if got := keys(diversify(ranked, nil, 0, 2, 0)); got != "a1,a2" {
+		t.Fatalf("no cap should keep ranked order, got %s", got)
+	}
+	if got := keys(diversify(ranked, map[string][]float32{}, 0.7, 10, 1)); got != "a1,b1,x1" {
+		t.Fatalf("cap should also apply with MMR, got %s", got)
+	}
+}
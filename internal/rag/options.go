@@ -0,0 +1,236 @@
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+)
+
+// Retrieval defaults used when neither the request nor the configuration set
+// a value.
+const (
+	DefaultLimit         = 5
+	DefaultAlpha         = 0.5
+	DefaultCandidates    = 50
+	DefaultMaxK          = 50
+	DefaultMaxCandidates = 200
+)
+
+// ErrInvalidQuery is returned when query options are out of range.
+var ErrInvalidQuery = errors.New("invalid query options")
+
+// QueryOptions tunes a single retrieval call. Zero values fall back to the
+// retrieval configuration.
+type QueryOptions struct {
+	// Limit is the number of documents returned.
+	Limit int
+	// Alpha blends vector (1) and lexical (0) scores.
+	Alpha *float64
+	// Candidates is the number of documents fetched from each search before
+	// fusion and reranking.
+	Candidates int
+	// Rerank toggles the configured reranker. Nil uses it when configured.
+	Rerank *bool
+	// RepoPrefix and PathPrefix restrict results to matching documents.
+	RepoPrefix string
+	PathPrefix string
+	// Filter restricts results to documents matching the expression.
+	Filter *store.Filter
+	// MMR toggles Maximal Marginal Relevance diversification and MMRLambda
+	// overrides its relevance weight. Nil uses the configuration.
+	MMR       *bool
+	MMRLambda *float64
+	// MaxPerPath caps how many chunks one file may contribute. Zero uses the
+	// configuration.
+	MaxPerPath int
+	// Expand widens each hit with its neighbouring windows or its whole
+	// section, up to ExpandTokens tokens. Empty values use the configuration.
+	Expand       string
+	ExpandTokens int
+	// QueryMode selects a generator-based pre-retrieval strategy: "none",
+	// "rewrite", "multi" or "hyde". Empty uses the configuration.
+	QueryMode string
+	// Trace, when set, is filled with the effective parameters, candidate
+	// counts and stage timings of the query.
+	Trace *Trace
+}
+
+// Trace describes how a query was executed.
+type Trace struct {
+	Alpha             float64      `json:"alpha"`
+	Fusion            string       `json:"fusion"`
+	Limit             int          `json:"k"`
+	Candidates        int          `json:"candidates"`
+	VectorCandidates  int          `json:"vector_candidates"`
+	LexicalCandidates int          `json:"lexical_candidates"`
+	FusedCandidates   int          `json:"fused_candidates"`
+	Reranked          bool         `json:"reranked"`
+	MMR               bool         `json:"mmr"`
+	MMRLambda         float64      `json:"mmr_lambda,omitempty"`
+	MaxPerPath        int          `json:"max_per_path,omitempty"`
+	Expand            string       `json:"expand"`
+	QueryMode         string       `json:"query_mode"`
+	Queries           []string     `json:"queries,omitempty"`
+	QueryModeError    string       `json:"query_mode_error,omitempty"`
+	Timings           StageTimings `json:"timings_ms"`
+}
+
+// StageTimings records the duration of each query stage in milliseconds.
+type StageTimings struct {
+	Plan    float64 `json:"plan"`
+	Embed   float64 `json:"embed"`
+	Vector  float64 `json:"vector"`
+	Lexical float64 `json:"lexical"`
+	Fusion  float64 `json:"fusion"`
+	Rerank  float64 `json:"rerank"`
+	MMR     float64 `json:"mmr"`
+	Expand  float64 `json:"expand"`
+	Total   float64 `json:"total"`
+}
+
+// stage adds the time elapsed since start to field, so stages that run once
+// per query variant report their total. It is a no-op on a nil trace.
+func (t *Trace) stage(field *float64, start time.Time) {
+	if t == nil {
+		return
+	}
+	*field += float64(time.Since(start).Microseconds()) / 1000
+}
+
+// resolvedQuery holds query options after defaults and limits are applied.
+type resolvedQuery struct {
+	limit       int
+	alpha       float64
+	candidates  int
+	rerank      bool
+	fusion      string
+	mmr         bool
+	lambda      float64
+	maxPerPath  int
+	expand      string
+	expandMax   int
+	mode        string
+	paraphrases int
+}
+
+// resolveQuery validates opts against the configured maximums and fills in
+// defaults.
+func resolveQuery(cfg config.RetrievalCfg, opts QueryOptions) (resolvedQuery, error) {
+	maxK := cfg.MaxK
+	if maxK <= 0 {
+		maxK = DefaultMaxK
+	}
+	maxCand := cfg.MaxCandidates
+	if maxCand <= 0 {
+		maxCand = DefaultMaxCandidates
+	}
+
+	q := resolvedQuery{limit: opts.Limit, rerank: opts.Rerank == nil || *opts.Rerank}
+	switch {
+	case q.limit == 0:
+		q.limit = DefaultLimit
+	case q.limit < 0 || q.limit > maxK:
+		return q, fmt.Errorf("%w: k must be between 1 and %d", ErrInvalidQuery, maxK)
+	}
+
+	q.alpha = cfg.Alpha
+	if q.alpha < 0 || q.alpha > 1 {
+		q.alpha = DefaultAlpha
+	}
+	if opts.Alpha != nil {
+		if *opts.Alpha < 0 || *opts.Alpha > 1 {
+			return q, fmt.Errorf("%w: alpha must be between 0 and 1", ErrInvalidQuery)
+		}
+		q.alpha = *opts.Alpha
+	}
+
+	q.candidates = opts.Candidates
+	switch {
+	case q.candidates == 0:
+		q.candidates = cfg.Candidates
+		if q.candidates <= 0 {
+			q.candidates = DefaultCandidates
+		}
+		if q.candidates > maxCand {
+			q.candidates = maxCand
+		}
+	case q.candidates < 0 || q.candidates > maxCand:
+		return q, fmt.Errorf("%w: candidates must be between 1 and %d", ErrInvalidQuery, maxCand)
+	}
+	if q.candidates < q.limit {
+		q.candidates = q.limit
+	}
+	q.mmr = cfg.MMR.Enabled
+	if opts.MMR != nil {
+		q.mmr = *opts.MMR
+	}
+	q.lambda = cfg.MMR.Lambda
+	if q.lambda <= 0 || q.lambda > 1 {
+		q.lambda = DefaultMMRLambda
+	}
+	if opts.MMRLambda != nil {
+		if *opts.MMRLambda < 0 || *opts.MMRLambda > 1 {
+			return q, fmt.Errorf("%w: mmr_lambda must be between 0 and 1", ErrInvalidQuery)
+		}
+		q.lambda = *opts.MMRLambda
+	}
+	q.maxPerPath = cfg.MaxPerPath
+	switch {
+	case opts.MaxPerPath < 0:
+		return q, fmt.Errorf("%w: max_per_path must not be negative", ErrInvalidQuery)
+	case opts.MaxPerPath > 0:
+		q.maxPerPath = opts.MaxPerPath
+	}
+
+	q.expand = strings.ToLower(strings.TrimSpace(cfg.Expand.Mode))
+	if opts.Expand != "" {
+		q.expand = strings.ToLower(opts.Expand)
+	}
+	switch q.expand {
+	case "":
+		q.expand = ExpandNone
+	case ExpandNone, ExpandNeighbors, ExpandSection:
+	default:
+		return q, fmt.Errorf("%w: expand must be none, neighbors or section", ErrInvalidQuery)
+	}
+	q.expandMax = cfg.Expand.MaxTokens
+	switch {
+	case opts.ExpandTokens < 0:
+		return q, fmt.Errorf("%w: expand_tokens must not be negative", ErrInvalidQuery)
+	case opts.ExpandTokens > 0:
+		q.expandMax = opts.ExpandTokens
+	}
+	if q.expandMax <= 0 {
+		q.expandMax = DefaultExpandTokens
+	}
+
+	q.mode = strings.ToLower(strings.TrimSpace(cfg.Query.Mode))
+	if opts.QueryMode != "" {
+		q.mode = strings.ToLower(opts.QueryMode)
+	}
+	switch q.mode {
+	case "":
+		q.mode = QueryModeNone
+	case QueryModeNone, QueryModeRewrite, QueryModeMulti, QueryModeHyDE:
+	default:
+		return q, fmt.Errorf("%w: query_mode must be none, rewrite, multi or hyde", ErrInvalidQuery)
+	}
+	q.paraphrases = cfg.Query.Paraphrases
+	if q.paraphrases <= 0 {
+		q.paraphrases = DefaultParaphrases
+	}
+
+	if err := opts.Filter.Validate(); err != nil {
+		return q, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	fusion, err := fusionStrategy(cfg.Fusion)
+	if err != nil {
+		return q, err
+	}
+	q.fusion = fusion
+	return q, nil
+}
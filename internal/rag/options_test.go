@@ -0,0 +1,85 @@
+package rag
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+)
+
+func TestResolveQueryDefaults(t *testing.T) {
+	q, err := resolveQuery(config.RetrievalCfg{Alpha: 0.7, Candidates: 30}, QueryOptions{})
+	if err != nil {
+		t.Fatalf("resolveQuery: %v", err)
+	}
+	if q.limit != DefaultLimit || q.alpha != 0.7 || q.candidates != 30 || !q.rerank {
+		t.Fatalf("unexpected defaults %+v", q)
+	}
+	if q.mode != QueryModeNone || q.paraphrases != DefaultParaphrases {
+		t.Fatalf("unexpected query mode defaults %+v", q)
+	}
+}
+
+func TestResolveQueryMode(t *testing.T) {
+	cfg := config.RetrievalCfg{Query: config.QueryCfg{Mode: "Multi", Paraphrases: 5}}
+	q, err := resolveQuery(cfg, QueryOptions{})
+	if err != nil {
+		t.Fatalf("resolveQuery: %v", err)
+	}
+	if q.mode != QueryModeMulti || q.paraphrases != 5 {
+		t.Fatalf("unexpected query mode %+v", q)
+	}
+	q, err = resolveQuery(cfg, QueryOptions{QueryMode: "HyDE"})
+	if err != nil {
+		t.Fatalf("resolveQuery: %v", err)
+	}
+	if q.mode != QueryModeHyDE {
+		t.Fatalf("request should override config, got %q", q.mode)
+	}
+}
+
+func TestResolveQueryOverrides(t *testing.T) {
+	alpha := 0.1
+	off := false
+	q, err := resolveQuery(config.RetrievalCfg{}, QueryOptions{Limit: 20, Alpha: &alpha, Candidates: 10, Rerank: &off})
+	if err != nil {
+		t.Fatalf("resolveQuery: %v", err)
+	}
+	if q.limit != 20 || q.alpha != 0.1 || q.rerank {
+		t.Fatalf("unexpected options %+v", q)
+	}
+	if q.candidates != 20 {
+		t.Fatalf("candidates should be raised to the limit, got %d", q.candidates)
+	}
+}
+
+func TestResolveQueryLimits(t *testing.T) {
+	cfg := config.RetrievalCfg{MaxK: 10, MaxCandidates: 40}
+	bad := 1.5
+	cases := []QueryOptions{
+		{Limit: 11},
+		{Limit: -1},
+		{Candidates: 41},
+		{Alpha: &bad},
+		{Filter: &store.Filter{Field: "content", Eq: "x"}},
+		{QueryMode: "fanout"},
+	}
+	for _, opts := range cases {
+		if _, err := resolveQuery(cfg, opts); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected ErrInvalidQuery for %+v, got %v", opts, err)
+		}
+	}
+}
+
+func TestTraceStage(t *testing.T) {
+	var nilTrace *Trace
+	nilTrace.stage(nil, time.Now()) // must not panic
+
+	tr := &Trace{}
+	tr.stage(&tr.Timings.Embed, time.Now().Add(-1500*time.Microsecond))
+	if tr.Timings.Embed < 1.5 {
+		t.Fatalf("unexpected embed timing %v", tr.Timings.Embed)
+	}
+}
@@ -0,0 +1,116 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"rag-server/internal/rag/generate"
+)
+
+// Query modes that rewrite the question with the generator before retrieval.
+const (
+	// QueryModeNone searches with the question as asked.
+	QueryModeNone = "none"
+	// QueryModeRewrite searches with a rewritten, keyword-rich query.
+	QueryModeRewrite = "rewrite"
+	// QueryModeMulti searches with the question and several paraphrases and
+	// fuses the results with reciprocal rank fusion.
+	QueryModeMulti = "multi"
+	// QueryModeHyDE embeds a hypothetical answer for the vector search and
+	// keeps the question for the lexical search.
+	QueryModeHyDE = "hyde"
+)
+
+// DefaultParaphrases is the number of paraphrases generated in multi mode.
+const DefaultParaphrases = 3
+
+const rewritePrompt = `Rewrite the user's question into a search query for a technical knowledge base.
+Expand abbreviations, add important synonyms and keep product names, commands and identifiers
+unchanged. If the question mixes Chinese and English, include the key terms in both languages.
+Reply with the query only.`
+
+const multiPrompt = `Write %d different search queries that could find documents answering the user's
+question. Vary the wording and use synonyms. If the question mixes Chinese and English, write some
+queries in each language. Reply with one query per line and nothing else.`
+
+const hydePrompt = `Write a short passage, as it might appear in technical documentation, that answers
+the user's question. Use the language of the question. Do not mention that the passage is
+hypothetical. Reply with the passage only.`
+
+// queryVariant is one search performed for a question: vector is embedded for
+// the vector search and lexical is used for full text search.
+type queryVariant struct {
+	vector  string
+	lexical string
+}
+
+// listPrefix matches list markers a model may put in front of each query.
+var listPrefix = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)、])\s*`)
+
+// planQueries returns the searches to run for question in mode. Generator
+// failures fall back to the plain question and are recorded in trace.
+func planQueries(ctx context.Context, gen generate.Generator, question, mode string, paraphrases int, trace *Trace) []queryVariant {
+	if trace == nil {
+		trace = &Trace{}
+	}
+	plain := []queryVariant{{vector: question, lexical: question}}
+	if mode == QueryModeNone || gen == nil {
+		return plain
+	}
+	if paraphrases <= 0 {
+		paraphrases = DefaultParaphrases
+	}
+
+	ask := func(system string) (string, bool) {
+		start := time.Now()
+		resp, err := gen.Generate(ctx, generate.Request{Messages: []generate.Message{
+			{Role: "system", Content: system},
+			{Role: "user", Content: question},
+		}})
+		trace.stage(&trace.Timings.Plan, start)
+		if err != nil {
+			trace.QueryModeError = err.Error()
+			return "", false
+		}
+		out := strings.TrimSpace(resp.Content)
+		return out, out != ""
+	}
+
+	var variants []queryVariant
+	switch mode {
+	case QueryModeRewrite:
+		if q, ok := ask(rewritePrompt); ok {
+			variants = []queryVariant{{vector: q, lexical: q}}
+		}
+	case QueryModeHyDE:
+		if passage, ok := ask(hydePrompt); ok {
+			variants = []queryVariant{{vector: passage, lexical: question}}
+		}
+	case QueryModeMulti:
+		if text, ok := ask(fmt.Sprintf(multiPrompt, paraphrases)); ok {
+			variants = plain
+			seen := map[string]bool{question: true}
+			for _, line := range strings.Split(text, "\n") {
+				line = strings.TrimSpace(listPrefix.ReplaceAllString(line, ""))
+				if line == "" || seen[line] {
+					continue
+				}
+				seen[line] = true
+				variants = append(variants, queryVariant{vector: line, lexical: line})
+				if len(variants) == paraphrases+1 {
+					break
+				}
+			}
+		}
+	}
+	if len(variants) == 0 {
+		return plain
+	}
+	for _, v := range variants {
+		trace.Queries = append(trace.Queries, v.vector)
+	}
+	return variants
+}
@@ -0,0 +1,118 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"rag-server/internal/rag/generate"
+)
+
+// stub returns a generator that answers every request with reply and records
+// the system prompt it was given.
+func stub(reply string, err error, prompt *string) generate.Generator {
+	return generate.Func(func(ctx context.Context, req generate.Request) (generate.Response, error) {
+		if prompt != nil {
+			*prompt = req.Messages[0].Content
+		}
+		return generate.Response{Content: reply}, err
+	})
+}
+
+func TestPlanQueries(t *testing.T) {
+	const question = "怎么部署 rag-server?"
+
+	t.Run("none", func(t *testing.T) {
+		called := false
+		gen := generate.Func(func(ctx context.Context, req generate.Request) (generate.Response, error) {
+			called = true
+			return generate.Response{}, nil
+		})
+		got := planQueries(context.Background(), gen, question, QueryModeNone, 0, nil)
+		if called || len(got) != 1 || got[0].vector != question || got[0].lexical != question {
+			t.Fatalf("unexpected plan %+v (generator called: %v)", got, called)
+		}
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		trace := &Trace{}
+		got := planQueries(context.Background(), stub("  rag-server deploy 部署 make gcp-deploy\n", nil, nil), question, QueryModeRewrite, 0, trace)
+		want := "rag-server deploy 部署 make gcp-deploy"
+		if len(got) != 1 || got[0].vector != want || got[0].lexical != want {
+			t.Fatalf("unexpected plan %+v", got)
+		}
+		if len(trace.Queries) != 1 || trace.Queries[0] != want {
+			t.Fatalf("unexpected trace queries %v", trace.Queries)
+		}
+	})
+
+	t.Run("multi", func(t *testing.T) {
+		var prompt string
+		reply := "1. deploy rag-server\n- rag-server 部署步骤\n\n2) " + question + "\n* deploy rag-server\n3、rollout guide\nextra query"
+		got := planQueries(context.Background(), stub(reply, nil, &prompt), question, QueryModeMulti, 3, nil)
+		var vectors []string
+		for _, v := range got {
+			vectors = append(vectors, v.vector)
+		}
+		want := []string{question, "deploy rag-server", "rag-server 部署步骤", "rollout guide"}
+		if strings.Join(vectors, "|") != strings.Join(want, "|") {
+			t.Fatalf("got %q, want %q", vectors, want)
+		}
+		if !strings.HasPrefix(prompt, "Write 3 different search queries") {
+			t.Fatalf("unexpected prompt %q", prompt)
+		}
+	})
+
+	t.Run("hyde", func(t *testing.T) {
+		passage := "Run make gcp-deploy to build and deploy the server."
+		got := planQueries(context.Background(), stub(passage, nil, nil), question, QueryModeHyDE, 0, nil)
+		if len(got) != 1 || got[0].vector != passage || got[0].lexical != question {
+			t.Fatalf("unexpected plan %+v", got)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		trace := &Trace{}
+		got := planQueries(context.Background(), stub("", errors.New("boom"), nil), question, QueryModeMulti, 3, trace)
+		if len(got) != 1 || got[0].vector != question {
+			t.Fatalf("expected plain question, got %+v", got)
+		}
+		if trace.QueryModeError != "boom" || trace.Queries != nil {
+			t.Fatalf("unexpected trace %+v", trace)
+		}
+	})
+
+	t.Run("empty reply", func(t *testing.T) {
+		got := planQueries(context.Background(), stub("  ", nil, nil), question, QueryModeRewrite, 0, nil)
+		if len(got) != 1 || got[0].vector != question {
+			t.Fatalf("expected plain question, got %+v", got)
+		}
+	})
+}
+
+func TestFuseVariants(t *testing.T) {
+	docs := func(keys ...string) []*Document {
+		out := make([]*Document, len(keys))
+		for i, k := range keys {
+			out[i] = &Document{DocKey: k}
+		}
+		return out
+	}
+	lists := [][]*Document{
+		docs("a", "b", "c"),
+		docs("b", "d"),
+		docs("b", "a"),
+	}
+	got := fuseVariants(lists, 60, 3)
+	if keys := strings.Join([]string{got[0].DocKey, got[1].DocKey, got[2].DocKey}, ","); len(got) != 3 || keys != "b,a,d" {
+		t.Fatalf("unexpected order %s", keys)
+	}
+	if got[0].Score != got[0].FusedScore || got[0].Score <= got[1].Score {
+		t.Fatalf("unexpected scores %+v %+v", got[0], got[1])
+	}
+	// The first list's document is kept so its component scores survive.
+	if got[1] != lists[0][0] {
+		t.Fatal("expected document from the first list")
+	}
+}
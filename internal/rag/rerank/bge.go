@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 )
 
 // BGE implements a reranker backed by a bge-reranker service.
@@ -16,12 +15,13 @@ type BGE struct {
 	client   *http.Client
 }
 
-// NewBGE returns a new BGE reranker.
+// NewBGE returns a new BGE reranker. Requests are bounded by the caller's
+// context; New adds a per-endpoint timeout.
 func NewBGE(endpoint, token string) *BGE {
 	return &BGE{
 		endpoint: endpoint,
 		token:    token,
-		client:   &http.Client{Timeout: 30 * time.Second},
+		client:   &http.Client{},
 	}
 }
 
@@ -43,7 +43,7 @@ func (b *BGE) Rerank(ctx context.Context, query string, docs []string) ([]float3
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("rerank failed: %s", resp.Status)
+		return nil, &HTTPError{Code: resp.StatusCode, Status: resp.Status}
 	}
 	var out struct {
 		Scores []float32 `json:"scores"`
@@ -1,8 +1,80 @@
 package rerank
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"rag-server/internal/rag/breaker"
+)
 
 // Reranker scores a list of documents for a given query.
 type Reranker interface {
 	Rerank(ctx context.Context, query string, docs []string) ([]float32, error)
 }
+
+// DefaultTimeout bounds each rerank request when no timeout is configured.
+const DefaultTimeout = 30 * time.Second
+
+// HTTPError represents an HTTP error returned by a rerank service.
+type HTTPError struct {
+	Code   int
+	Status string
+}
+
+// Error implements the error interface.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("rerank failed: %s", e.Status)
+}
+
+// StatusCode returns the HTTP status code associated with the error.
+func (e *HTTPError) StatusCode() int {
+	return e.Code
+}
+
+// New returns a BGE reranker over endpoints. Requests go to the first
+// endpoint whose circuit breaker admits them and fail over to the next one on
+// timeouts, network errors, rate limiting and server errors. Each attempt is
+// bounded by timeout, or DefaultTimeout when it is not positive.
+func New(endpoints []string, token string, timeout time.Duration) Reranker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	f := &failover{timeout: timeout}
+	for _, ep := range endpoints {
+		f.targets = append(f.targets, target{rr: NewBGE(ep, token), br: breaker.Get("reranker " + ep)})
+	}
+	return f
+}
+
+type failover struct {
+	targets []target
+	timeout time.Duration
+}
+
+type target struct {
+	rr Reranker
+	br *breaker.Breaker
+}
+
+func (f *failover) Rerank(ctx context.Context, query string, docs []string) ([]float32, error) {
+	lastErr := fmt.Errorf("no rerank endpoint configured")
+	for _, t := range f.targets {
+		if err := t.br.Allow(); err != nil {
+			lastErr = err
+			continue
+		}
+		actx, cancel := context.WithTimeout(ctx, f.timeout)
+		scores, err := t.rr.Rerank(actx, query, docs)
+		cancel()
+		t.br.Report(err)
+		if err == nil {
+			return scores, nil
+		}
+		if ctx.Err() != nil || !breaker.Failure(err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
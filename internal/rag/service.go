@@ -2,197 +2,426 @@ package rag
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/jackc/pgx/v5"
-	pgvector "github.com/pgvector/pgvector-go"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"rag-server/internal/rag/config"
 	"rag-server/internal/rag/embed"
+	"rag-server/internal/rag/generate"
 	"rag-server/internal/rag/rerank"
 	"rag-server/internal/rag/store"
 )
 
 type Service struct {
 	cfg *config.Config
+
+	poolMu  sync.Mutex
+	pool    *pgxpool.Pool
+	ownPool bool
+
+	genOnce sync.Once
+	gen     generate.Generator
+	// emb replaces the configured embedder when set.
+	emb embed.Embedder
+
+	memOnce sync.Once
+	mem     *store.Memory
 }
 
 func New(cfg *config.Config) *Service {
 	return &Service{cfg: cfg}
 }
 
+// NewWithPool returns a Service that uses pool for the Postgres backend
+// instead of opening its own. The caller keeps ownership of pool.
+func NewWithPool(cfg *config.Config, pool *pgxpool.Pool) *Service {
+	return &Service{cfg: cfg, pool: pool}
+}
+
+// Close releases the connection pool opened by the service, if any.
+func (s *Service) Close() {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	if s.ownPool && s.pool != nil {
+		s.pool.Close()
+		s.pool = nil
+	}
+}
+
 // Upsert stores pre-embedded documents into the vector database.
 func (s *Service) Upsert(ctx context.Context, rows []store.DocRow) (int, error) {
 	if s == nil || s.cfg == nil || len(rows) == 0 {
 		return 0, nil
 	}
-	dsn := s.cfg.Global.VectorDB.DSN()
-	if dsn == "" {
-		return 0, nil
-	}
-	conn, err := pgx.Connect(ctx, dsn)
-	if err != nil {
+	st, err := s.openStore(ctx)
+	if err != nil || st == nil {
 		return 0, err
 	}
-	defer conn.Close(ctx)
 
 	dim := len(rows[0].Embedding)
 	// Allow schema migration so the embedding dimension can be updated
-	if err := store.EnsureSchema(ctx, conn, dim, true); err != nil {
+	if err := st.EnsureSchema(ctx, dim, true); err != nil {
 		return 0, err
 	}
-	return store.UpsertDocuments(ctx, conn, rows)
+	return st.Upsert(ctx, rows)
+}
+
+// CachedEmbeddings returns stored embeddings made by model that can be reused
+// for keys, keyed by content SHA.
+func (s *Service) CachedEmbeddings(ctx context.Context, model string, keys []store.EmbeddingKey) (map[string][]float32, error) {
+	if s == nil || s.cfg == nil || len(keys) == 0 {
+		return nil, nil
+	}
+	st, err := s.openStore(ctx)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	return st.CachedEmbeddings(ctx, model, keys)
+}
+
+// Reconcile deletes the stored rows of one datasource that its latest ingest
+// did not produce. See store.VectorStore.Reconcile.
+func (s *Service) Reconcile(ctx context.Context, spec store.ReconcileSpec) (store.ReconcileResult, error) {
+	if s == nil || s.cfg == nil {
+		return store.ReconcileResult{}, nil
+	}
+	st, err := s.openStore(ctx)
+	if err != nil || st == nil {
+		return store.ReconcileResult{}, err
+	}
+	return st.Reconcile(ctx, spec)
+}
+
+// IngestedCommit returns the state last recorded for a datasource, zero
+// when there is none.
+func (s *Service) IngestedCommit(ctx context.Context, repo, pathPrefix string) (store.IngestState, error) {
+	if s == nil || s.cfg == nil {
+		return store.IngestState{}, nil
+	}
+	st, err := s.openStore(ctx)
+	if err != nil || st == nil {
+		return store.IngestState{}, err
+	}
+	return st.IngestedCommit(ctx, repo, pathPrefix)
+}
+
+// SetIngestedCommit records the state ingested for a datasource.
+func (s *Service) SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state store.IngestState) error {
+	if s == nil || s.cfg == nil {
+		return nil
+	}
+	st, err := s.openStore(ctx)
+	if err != nil || st == nil {
+		return err
+	}
+	return st.SetIngestedCommit(ctx, repo, pathPrefix, state)
+}
+
+// openStore returns the configured vector store. It returns a nil store when
+// the Postgres backend has no DSN configured.
+func (s *Service) openStore(ctx context.Context) (store.VectorStore, error) {
+	vdb := s.cfg.Global.VectorDB
+	switch strings.ToLower(vdb.Backend) {
+	case store.BackendMemory:
+		s.memOnce.Do(func() {
+			if s.mem == nil {
+				s.mem = store.NewMemory()
+			}
+		})
+		return s.mem, nil
+	case "", store.BackendPostgres:
+		s.poolMu.Lock()
+		defer s.poolMu.Unlock()
+		if s.pool == nil {
+			if vdb.DSN() == "" {
+				return nil, nil
+			}
+			pc, err := vdb.PoolConfig()
+			if err != nil {
+				return nil, err
+			}
+			// The pool outlives this request.
+			pool, err := pgxpool.NewWithConfig(context.WithoutCancel(ctx), pc)
+			if err != nil {
+				return nil, err
+			}
+			s.pool, s.ownPool = pool, true
+		}
+		return store.NewPostgres(s.pool), nil
+	default:
+		return nil, fmt.Errorf("unknown vectordb backend %q", vdb.Backend)
+	}
+}
+
+// generator returns the generator used by query modes, building it from the
+// generator configuration on first use. It returns nil when no generator
+// endpoint is configured and the provider is not a local one.
+func (s *Service) generator() generate.Generator {
+	s.genOnce.Do(func() {
+		if s.gen != nil {
+			return
+		}
+		g := s.cfg.Models.Generator
+		endpoints := g.EndpointList()
+		if len(endpoints) == 0 && !generate.Local(g.Provider) {
+			return
+		}
+		// models.generator.timeout takes precedence over the older
+		// api.askai.timeout.
+		timeout := 30 * time.Second
+		if g.Timeout.Duration > 0 {
+			timeout = g.Timeout.Duration
+		} else if s.cfg.API.AskAI.Timeout > 0 {
+			timeout = time.Duration(s.cfg.API.AskAI.Timeout) * time.Second
+		}
+		s.gen = generate.New(g.Provider, endpoints, g.Token, g.Models, timeout, s.cfg.API.AskAI.Retries)
+	})
+	return s.gen
+}
+
+// embedder constructs the configured embedding client. It returns
+// ErrNoEmbedder when the provider needs an endpoint and none is configured.
+func (s *Service) embedder() (embed.Embedder, error) {
+	if s.emb != nil {
+		return s.emb, nil
+	}
+	emb, err := embed.New(s.cfg.ResolveEmbedding())
+	if errors.Is(err, embed.ErrNoEndpoint) {
+		return nil, ErrNoEmbedder
+	}
+	return emb, err
+}
+
+// ErrNoEmbedder is returned when an operation needs an embedding endpoint but
+// none is configured.
+var ErrNoEmbedder = embed.ErrNoEndpoint
+
+// Embed embeds inputs with the configured embedder and returns the vectors and
+// token usage reported by the provider.
+func (s *Service) Embed(ctx context.Context, inputs []string) ([][]float32, int, error) {
+	if s == nil || s.cfg == nil {
+		return nil, 0, ErrNoEmbedder
+	}
+	emb, err := s.embedder()
+	if err != nil {
+		return nil, 0, err
+	}
+	return emb.Embed(ctx, inputs)
 }
 
+// Document is a retrieved chunk together with the scores that ranked it.
+// Score is the final ranking score: the rerank score when reranking ran,
+// otherwise the fused score. VectorRank and LexicalRank are 1-based positions
+// in the respective search results and zero when the chunk was not returned
+// by that search.
 type Document struct {
 	Repo     string         `json:"repo"`
 	Path     string         `json:"path"`
 	ChunkID  int            `json:"chunk_id"`
+	DocKey   string         `json:"doc_key,omitempty"`
 	Content  string         `json:"content"`
 	Metadata map[string]any `json:"metadata"`
+
+	Score        float64  `json:"score"`
+	FusedScore   float64  `json:"fused_score"`
+	VectorScore  float64  `json:"vector_score"`
+	LexicalScore float64  `json:"lexical_score"`
+	RerankScore  *float64 `json:"rerank_score,omitempty"`
+	VectorRank   int      `json:"vector_rank,omitempty"`
+	LexicalRank  int      `json:"lexical_rank,omitempty"`
 }
 
-func (s *Service) Query(ctx context.Context, question string, limit int) ([]Document, error) {
+// Query retrieves the documents most relevant to question using hybrid vector
+// and lexical search, optionally followed by reranking.
+func (s *Service) Query(ctx context.Context, question string, opts QueryOptions) ([]Document, error) {
 	if s == nil || s.cfg == nil {
 		return nil, nil
 	}
-	embCfg := s.cfg.ResolveEmbedding()
-	if embCfg.Endpoint == "" {
-		return nil, nil
-	}
-	var emb embed.Embedder
-	switch embCfg.Provider {
-	case "ollama":
-		emb = embed.NewOllama(embCfg.Endpoint, embCfg.Model, embCfg.Dimension)
-	case "chutes":
-		emb = embed.NewChutes(embCfg.Endpoint, embCfg.APIKey, embCfg.Dimension)
-	default:
-		if embCfg.Model != "" {
-			emb = embed.NewOpenAI(embCfg.Endpoint, embCfg.APIKey, embCfg.Model, embCfg.Dimension)
-		} else {
-			emb = embed.NewBGE(embCfg.Endpoint, embCfg.APIKey, embCfg.Dimension)
-		}
-	}
-	vecs, _, err := emb.Embed(ctx, []string{question})
+	q, err := resolveQuery(s.cfg.Retrieval, opts)
 	if err != nil {
 		return nil, err
 	}
-	if len(vecs) == 0 {
-		return nil, nil
+	// Always record into a trace; it is only returned when the caller asked
+	// for one.
+	trace := opts.Trace
+	if trace == nil {
+		trace = &Trace{}
 	}
-	dsn := s.cfg.Global.VectorDB.DSN()
-	if dsn == "" {
-		return nil, nil
+	trace.Alpha = q.alpha
+	trace.Fusion = q.fusion
+	trace.MMR = q.mmr
+	if q.mmr {
+		trace.MMRLambda = q.lambda
 	}
-	conn, err := pgx.Connect(ctx, dsn)
+	trace.MaxPerPath = q.maxPerPath
+	trace.Expand = q.expand
+	trace.QueryMode = q.mode
+	trace.Limit = q.limit
+	trace.Candidates = q.candidates
+	began := time.Now()
+	emb, err := s.embedder()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close(ctx)
-
-	alpha := s.cfg.Retrieval.Alpha
-	if alpha < 0 || alpha > 1 {
-		alpha = 0.5
-	}
-	cand := s.cfg.Retrieval.Candidates
-	if cand <= 0 {
-		cand = 50
+	variants := planQueries(ctx, s.generator(), question, q.mode, q.paraphrases, trace)
+	texts := make([]string, len(variants))
+	for i, v := range variants {
+		texts[i] = v.vector
 	}
-
-	type scored struct {
-		Document
-		vscore float64
-		tscore float64
-		score  float64
-	}
-	docsMap := map[string]*scored{}
-
-	vrows, err := conn.Query(ctx, `SELECT repo,path,chunk_id,content,metadata, embedding <#> $1 AS dist FROM documents WHERE embedding IS NOT NULL ORDER BY embedding <#> $1 LIMIT $2`,
-		pgvector.NewVector(vecs[0]), cand)
+	start := time.Now()
+	vecs, _, err := emb.Embed(ctx, texts)
 	if err != nil {
 		return nil, err
 	}
-	for vrows.Next() {
-		var d scored
-		var metaBytes []byte
-		var dist float64
-		if err := vrows.Scan(&d.Repo, &d.Path, &d.ChunkID, &d.Content, &metaBytes, &dist); err != nil {
-			vrows.Close()
-			return nil, err
-		}
-		if len(metaBytes) > 0 {
-			_ = json.Unmarshal(metaBytes, &d.Metadata)
-		}
-		d.vscore = -dist
-		key := fmt.Sprintf("%s|%s|%d", d.Repo, d.Path, d.ChunkID)
-		docsMap[key] = &d
+	trace.stage(&trace.Timings.Embed, start)
+	if len(vecs) != len(variants) {
+		return nil, fmt.Errorf("embedding count mismatch: got %d, want %d", len(vecs), len(variants))
 	}
-	vrows.Close()
-
-	trows, err := conn.Query(ctx, `SELECT repo,path,chunk_id,content,metadata, ts_rank_cd(content_tsv, websearch_to_tsquery('zhcn_search', $1)) AS rank FROM documents WHERE content_tsv @@ websearch_to_tsquery('zhcn_search', $1) ORDER BY rank DESC LIMIT $2`,
-		question, cand)
-	if err != nil {
+	st, err := s.openStore(ctx)
+	if err != nil || st == nil {
 		return nil, err
 	}
-	for trows.Next() {
-		var metaBytes []byte
-		var rank float64
-		key := ""
-		d := scored{}
-		if err := trows.Scan(&d.Repo, &d.Path, &d.ChunkID, &d.Content, &metaBytes, &rank); err != nil {
-			trows.Close()
+
+	filter := store.SearchFilter{RepoPrefix: opts.RepoPrefix, PathPrefix: opts.PathPrefix, Where: opts.Filter}
+	lists := make([][]*Document, len(variants))
+	for i, v := range variants {
+		lists[i], err = s.search(ctx, st, vecs[i], v.lexical, q, filter, trace)
+		if err != nil {
 			return nil, err
 		}
-		if len(metaBytes) > 0 {
-			_ = json.Unmarshal(metaBytes, &d.Metadata)
-		}
-		d.tscore = rank
-		key = fmt.Sprintf("%s|%s|%d", d.Repo, d.Path, d.ChunkID)
-		if exist, ok := docsMap[key]; ok {
-			exist.tscore = d.tscore
-		} else {
-			docsMap[key] = &d
-		}
 	}
-	trows.Close()
-
-	candidates := make([]*scored, 0, len(docsMap))
-	for _, d := range docsMap {
-		d.score = alpha*d.vscore + (1-alpha)*d.tscore
-		candidates = append(candidates, d)
-	}
-	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
-	if len(candidates) > cand {
-		candidates = candidates[:cand]
+	candidates := lists[0]
+	if len(lists) > 1 {
+		start = time.Now()
+		candidates = fuseVariants(lists, s.cfg.Retrieval.Fusion.RRFK, q.candidates)
+		trace.stage(&trace.Timings.Fusion, start)
 	}
+	trace.FusedCandidates = len(candidates)
 
 	// optional reranking
 	var rr rerank.Reranker
 	rCfg := s.cfg.Models.Reranker
-	if rCfg.Endpoint != "" {
-		rr = rerank.NewBGE(rCfg.Endpoint, rCfg.Token)
+	if endpoints := rCfg.EndpointList(); q.rerank && len(endpoints) > 0 {
+		rr = rerank.New(endpoints, rCfg.Token, rCfg.Timeout.Duration)
 	}
 	if rr != nil {
+		start = time.Now()
 		docs := make([]string, len(candidates))
 		for i, c := range candidates {
 			docs[i] = c.Content
 		}
 		if scores, err := rr.Rerank(ctx, question, docs); err == nil && len(scores) == len(candidates) {
 			for i := range candidates {
-				candidates[i].score = float64(scores[i])
+				rs := float64(scores[i])
+				candidates[i].RerankScore = &rs
+				candidates[i].Score = rs
 			}
-			sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+			sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+			trace.Reranked = true
+		}
+		trace.stage(&trace.Timings.Rerank, start)
+	}
+
+	var embeddings map[string][]float32
+	if q.mmr {
+		start = time.Now()
+		keys := make([]string, len(candidates))
+		for i, c := range candidates {
+			keys[i] = c.DocKey
+		}
+		embeddings, err = st.Embeddings(ctx, keys)
+		if err != nil {
+			return nil, err
 		}
 	}
+	selected := diversify(candidates, embeddings, q.lambda, q.limit, q.maxPerPath)
+	if q.mmr {
+		trace.stage(&trace.Timings.MMR, start)
+	}
 
-	if limit > len(candidates) {
-		limit = len(candidates)
+	if q.expand != ExpandNone {
+		start = time.Now()
+		selected, err = expand(ctx, selected, q.expand, q.expandMax, st.Section)
+		if err != nil {
+			return nil, err
+		}
+		trace.stage(&trace.Timings.Expand, start)
 	}
-	out := make([]Document, 0, limit)
-	for i := 0; i < limit; i++ {
-		out = append(out, candidates[i].Document)
+
+	out := make([]Document, len(selected))
+	for i, d := range selected {
+		out[i] = *d
 	}
+	trace.stage(&trace.Timings.Total, began)
 	return out, nil
 }
+
+// search runs the vector and lexical searches for one query variant and
+// returns the fused candidates in ranking order.
+func (s *Service) search(ctx context.Context, st store.VectorStore, vec []float32, lexical string, q resolvedQuery, filter store.SearchFilter, trace *Trace) ([]*Document, error) {
+	start := time.Now()
+	vhits, err := st.VectorSearch(ctx, vec, q.candidates, filter)
+	if err != nil {
+		return nil, err
+	}
+	trace.stage(&trace.Timings.Vector, start)
+	start = time.Now()
+	thits, err := st.LexicalSearch(ctx, lexical, q.candidates, filter)
+	if err != nil {
+		return nil, err
+	}
+	trace.stage(&trace.Timings.Lexical, start)
+
+	start = time.Now()
+	docsMap := map[string]*Document{}
+	for i, h := range vhits {
+		d := hitDocument(h)
+		d.VectorScore = h.Score
+		d.VectorRank = i + 1
+		docsMap[d.DocKey] = &d
+	}
+	for i, h := range thits {
+		d, ok := docsMap[h.DocKey]
+		if !ok {
+			nd := hitDocument(h)
+			d = &nd
+			docsMap[d.DocKey] = d
+		}
+		d.LexicalScore = h.Score
+		d.LexicalRank = i + 1
+	}
+
+	candidates := make([]*Document, 0, len(docsMap))
+	for _, d := range docsMap {
+		candidates = append(candidates, d)
+	}
+	fuse(candidates, q.fusion, s.cfg.Retrieval.Fusion.RRFK, q.alpha)
+	for _, d := range candidates {
+		d.Score = d.FusedScore
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	if len(candidates) > q.candidates {
+		candidates = candidates[:q.candidates]
+	}
+	trace.stage(&trace.Timings.Fusion, start)
+	trace.VectorCandidates += len(vhits)
+	trace.LexicalCandidates += len(thits)
+	return candidates, nil
+}
+
+func hitDocument(h store.SearchHit) Document {
+	return Document{
+		Repo:     h.Repo,
+		Path:     h.Path,
+		ChunkID:  h.ChunkID,
+		DocKey:   h.DocKey,
+		Content:  h.Content,
+		Metadata: h.Metadata,
+	}
+}
@@ -0,0 +1,130 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+)
+
+// keywordEmbedder embeds text as counts of a few fixed keywords.
+type keywordEmbedder []string
+
+func (k keywordEmbedder) Embed(_ context.Context, inputs []string) ([][]float32, int, error) {
+	out := make([][]float32, len(inputs))
+	for i, in := range inputs {
+		out[i] = make([]float32, len(k))
+		for j, w := range k {
+			out[i][j] = float32(strings.Count(strings.ToLower(in), w))
+		}
+	}
+	return out, 0, nil
+}
+
+func (k keywordEmbedder) Dimension() int { return len(k) }
+
+func TestServiceMemoryBackend(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Global.VectorDB.Backend = "memory"
+	svc := New(cfg)
+	emb := keywordEmbedder{"deploy", "rollback", "backup"}
+	svc.emb = emb
+
+	ctx := context.Background()
+	texts := []string{
+		"Deploy with make deploy.",
+		"Rollback a bad deploy with make rollback.",
+		"Backup the database nightly.",
+	}
+	vecs, _, _ := emb.Embed(ctx, texts)
+	rows := make([]store.DocRow, len(texts))
+	for i, text := range texts {
+		rows[i] = store.DocRow{Repo: "kb", Path: "ops.md", ChunkID: i, Content: text, Embedding: vecs[i], ContentSHA: text}
+	}
+	rows[2].Path = "backup.md"
+	if n, err := svc.Upsert(ctx, rows); err != nil || n != 3 {
+		t.Fatalf("Upsert = %d, %v", n, err)
+	}
+
+	trace := &Trace{}
+	docs, err := svc.Query(ctx, "how to rollback", QueryOptions{Limit: 2, Trace: trace})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(docs) != 2 || docs[0].ChunkID != 1 {
+		t.Fatalf("unexpected ranking %+v", docs)
+	}
+	if docs[0].VectorRank != 1 || docs[0].LexicalRank != 1 || trace.VectorCandidates == 0 {
+		t.Fatalf("unexpected scores %+v trace %+v", docs[0], trace)
+	}
+
+	docs, err = svc.Query(ctx, "backup", QueryOptions{PathPrefix: "ops"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	for _, d := range docs {
+		if d.Path != "ops.md" {
+			t.Fatalf("path prefix not applied: %+v", d)
+		}
+	}
+
+	// The store outlives a single call.
+	if docs, _ := svc.Query(ctx, "backup", QueryOptions{}); len(docs) == 0 || docs[0].Path != "backup.md" {
+		t.Fatalf("unexpected result %+v", docs)
+	}
+}
+
+func TestServiceUnknownBackend(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Global.VectorDB.Backend = "redis"
+	svc := New(cfg)
+	svc.emb = keywordEmbedder{"a"}
+	if _, err := svc.Query(context.Background(), "q", QueryOptions{}); err == nil {
+		t.Fatal("expected unknown backend error")
+	}
+}
+
+func TestServiceNoEmbedder(t *testing.T) {
+	svc := New(&config.Config{})
+	if _, err := svc.Query(context.Background(), "q", QueryOptions{}); !errors.Is(err, ErrNoEmbedder) {
+		t.Fatalf("expected ErrNoEmbedder, got %v", err)
+	}
+}
+
+func TestServiceLocalProviders(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Global.VectorDB.Backend = "memory"
+	cfg.Models.Embedder.Provider = "local-hash"
+	cfg.Models.Generator.Provider = "echo"
+	cfg.Retrieval.Query.Mode = QueryModeRewrite
+	svc := New(cfg)
+
+	ctx := context.Background()
+	texts := []string{"Deploy the server with make deploy.", "Back up the database with pg_dump."}
+	vecs, _, err := svc.Embed(ctx, texts)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	rows := make([]store.DocRow, len(texts))
+	for i, text := range texts {
+		rows[i] = store.DocRow{Repo: "kb", Path: "ops.md", ChunkID: i, Content: text, Embedding: vecs[i], ContentSHA: text}
+	}
+	if _, err := svc.Upsert(ctx, rows); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	trace := &Trace{}
+	docs, err := svc.Query(ctx, "database backup", QueryOptions{Limit: 1, Trace: trace})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(docs) != 1 || docs[0].ChunkID != 1 {
+		t.Fatalf("unexpected result %+v", docs)
+	}
+	// The echo generator rewrites the question to itself.
+	if trace.QueryModeError != "" || len(trace.Queries) != 1 || trace.Queries[0] != "database backup" {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+}
@@ -0,0 +1,293 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidFilter is returned when a filter expression is malformed.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// maxFilterNodes bounds the size of a filter expression.
+const maxFilterNodes = 64
+
+// Filter is a boolean expression over document fields. Each node sets
+// exactly one operator: the combinators And, Or and Not, or one of the
+// comparisons Eq, In, Prefix and Exists applied to Field.
+//
+// Field is "repo", "path" or "metadata.<key>", where <key> may be a dotted
+// path into nested metadata objects. Metadata comparisons use JSON equality,
+// so {"field":"metadata.size","eq":120} matches the number 120 but not the
+// string "120".
+//
+//	{"and": [
+//	  {"not": {"field": "metadata.type", "eq": "toc"}},
+//	  {"field": "path", "prefix": "docs/operations/"}
+//	]}
+type Filter struct {
+	And    []Filter `json:"and,omitempty"`
+	Or     []Filter `json:"or,omitempty"`
+	Not    *Filter  `json:"not,omitempty"`
+	Field  string   `json:"field,omitempty"`
+	Eq     any      `json:"eq,omitempty"`
+	In     []any    `json:"in,omitempty"`
+	Prefix *string  `json:"prefix,omitempty"`
+	Exists *bool    `json:"exists,omitempty"`
+}
+
+// Validate reports whether f is well formed.
+func (f *Filter) Validate() error {
+	_, _, err := f.Compile(1)
+	return err
+}
+
+// Compile renders f as a SQL boolean expression over the documents table.
+// Placeholders are numbered from start; the matching arguments are returned
+// in order.
+func (f *Filter) Compile(start int) (string, []any, error) {
+	c := filterCompiler{next: start}
+	sql, err := c.compile(f)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, c.args, nil
+}
+
+type filterCompiler struct {
+	next  int
+	args  []any
+	nodes int
+}
+
+func (c *filterCompiler) arg(v any) string {
+	c.args = append(c.args, v)
+	c.next++
+	return fmt.Sprintf("$%d", c.next-1)
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, args...))
+}
+
+func (c *filterCompiler) compile(f *Filter) (string, error) {
+	if f == nil {
+		return "TRUE", nil
+	}
+	c.nodes++
+	if c.nodes > maxFilterNodes {
+		return "", invalid("more than %d nodes", maxFilterNodes)
+	}
+
+	ops := 0
+	for _, set := range []bool{f.And != nil, f.Or != nil, f.Not != nil, f.Eq != nil, f.In != nil, f.Prefix != nil, f.Exists != nil} {
+		if set {
+			ops++
+		}
+	}
+	if ops != 1 {
+		return "", invalid("each node needs exactly one operator")
+	}
+
+	switch {
+	case f.And != nil:
+		return c.join(f, f.And, " AND ", "TRUE")
+	case f.Or != nil:
+		return c.join(f, f.Or, " OR ", "FALSE")
+	case f.Not != nil:
+		if f.Field != "" {
+			return "", invalid("not does not take a field")
+		}
+		inner, err := c.compile(f.Not)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	}
+
+	switch {
+	case f.Field == "repo" || f.Field == "path":
+		return c.column(f)
+	case strings.HasPrefix(f.Field, "metadata."):
+		return c.metadata(f)
+	case f.Field == "":
+		return "", invalid("comparison needs a field")
+	default:
+		return "", invalid("unknown field %q", f.Field)
+	}
+}
+
+func (c *filterCompiler) join(f *Filter, parts []Filter, sep, empty string) (string, error) {
+	if f.Field != "" {
+		return "", invalid("and/or do not take a field")
+	}
+	if len(parts) == 0 {
+		return empty, nil
+	}
+	out := make([]string, len(parts))
+	for i := range parts {
+		sql, err := c.compile(&parts[i])
+		if err != nil {
+			return "", err
+		}
+		out[i] = sql
+	}
+	return "(" + strings.Join(out, sep) + ")", nil
+}
+
+// column compiles a comparison on the repo or path column.
+func (c *filterCompiler) column(f *Filter) (string, error) {
+	col := f.Field
+	switch {
+	case f.Eq != nil:
+		s, ok := f.Eq.(string)
+		if !ok {
+			return "", invalid("%s eq needs a string", col)
+		}
+		return col + " = " + c.arg(s), nil
+	case f.In != nil:
+		vals := make([]string, len(f.In))
+		for i, v := range f.In {
+			s, ok := v.(string)
+			if !ok {
+				return "", invalid("%s in needs strings", col)
+			}
+			vals[i] = s
+		}
+		return col + " = ANY(" + c.arg(vals) + ")", nil
+	case f.Prefix != nil:
+		return "starts_with(" + col + ", " + c.arg(*f.Prefix) + ")", nil
+	default:
+		// repo and path are NOT NULL
+		if *f.Exists {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	}
+}
+
+// metadata compiles a comparison on a metadata key path. Comparisons against
+// missing keys yield FALSE rather than NULL so that negations behave as
+// expected.
+func (c *filterCompiler) metadata(f *Filter) (string, error) {
+	path := strings.Split(strings.TrimPrefix(f.Field, "metadata."), ".")
+	for _, p := range path {
+		if p == "" {
+			return "", invalid("bad metadata key %q", f.Field)
+		}
+	}
+	switch {
+	case f.Eq != nil:
+		v, err := json.Marshal(f.Eq)
+		if err != nil {
+			return "", invalid("%s: %v", f.Field, err)
+		}
+		return "COALESCE(metadata #> " + c.arg(path) + " = " + c.arg(string(v)) + "::jsonb, FALSE)", nil
+	case f.In != nil:
+		vals := make([]string, len(f.In))
+		for i, x := range f.In {
+			v, err := json.Marshal(x)
+			if err != nil {
+				return "", invalid("%s: %v", f.Field, err)
+			}
+			vals[i] = string(v)
+		}
+		return "COALESCE(metadata #> " + c.arg(path) + " = ANY(" + c.arg(vals) + "::jsonb[]), FALSE)", nil
+	case f.Prefix != nil:
+		return "COALESCE(starts_with(metadata #>> " + c.arg(path) + ", " + c.arg(*f.Prefix) + "), FALSE)", nil
+	default:
+		if *f.Exists {
+			return "metadata #> " + c.arg(path) + " IS NOT NULL", nil
+		}
+		return "metadata #> " + c.arg(path) + " IS NULL", nil
+	}
+}
+
+// Match evaluates f against a document in Go with the same semantics as the
+// compiled SQL. f must be valid.
+func (f *Filter) Match(repo, path string, metadata map[string]any) bool {
+	if f == nil {
+		return true
+	}
+	switch {
+	case f.And != nil:
+		for i := range f.And {
+			if !f.And[i].Match(repo, path, metadata) {
+				return false
+			}
+		}
+		return true
+	case f.Or != nil:
+		for i := range f.Or {
+			if f.Or[i].Match(repo, path, metadata) {
+				return true
+			}
+		}
+		return false
+	case f.Not != nil:
+		return !f.Not.Match(repo, path, metadata)
+	}
+
+	var (
+		value any
+		found bool
+	)
+	switch f.Field {
+	case "repo":
+		value, found = repo, true
+	case "path":
+		value, found = path, true
+	default:
+		value, found = lookup(metadata, strings.Split(strings.TrimPrefix(f.Field, "metadata."), "."))
+	}
+	switch {
+	case f.Exists != nil:
+		return found == *f.Exists
+	case !found:
+		return false
+	case f.Eq != nil:
+		return jsonEqual(value, f.Eq)
+	case f.In != nil:
+		for _, v := range f.In {
+			if jsonEqual(value, v) {
+				return true
+			}
+		}
+		return false
+	default:
+		return strings.HasPrefix(jsonText(value), *f.Prefix)
+	}
+}
+
+// lookup follows path through nested metadata objects.
+func lookup(metadata map[string]any, path []string) (any, bool) {
+	var cur any = metadata
+	for _, key := range path {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// jsonEqual compares two values as JSON documents, matching jsonb equality.
+func jsonEqual(a, b any) bool {
+	x, err1 := json.Marshal(a)
+	y, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(x) == string(y)
+}
+
+// jsonText renders v like the ->> and #>> operators: strings unquoted, other
+// values as JSON.
+func jsonText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
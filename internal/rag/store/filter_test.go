@@ -0,0 +1,148 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func parseFilter(t *testing.T, s string) *Filter {
+	t.Helper()
+	var f Filter
+	if err := json.Unmarshal([]byte(s), &f); err != nil {
+		t.Fatalf("unmarshal %s: %v", s, err)
+	}
+	return &f
+}
+
+func TestFilterCompile(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		sql  string
+		args []any
+	}{
+		{
+			name: "exclude toc under a path",
+			in:   `{"and":[{"not":{"field":"metadata.type","eq":"toc"}},{"field":"path","prefix":"docs/operations/"}]}`,
+			sql:  `(NOT (COALESCE(metadata #> $3 = $4::jsonb, FALSE)) AND starts_with(path, $5))`,
+			args: []any{[]string{"type"}, `"toc"`, "docs/operations/"},
+		},
+		{
+			name: "repo in",
+			in:   `{"field":"repo","in":["a","b"]}`,
+			sql:  `repo = ANY($3)`,
+			args: []any{[]string{"a", "b"}},
+		},
+		{
+			name: "nested metadata in with numbers",
+			in:   `{"or":[{"field":"metadata.stats.size","in":[1,2]},{"field":"metadata.summary","exists":true}]}`,
+			sql:  `(COALESCE(metadata #> $3 = ANY($4::jsonb[]), FALSE) OR metadata #> $5 IS NOT NULL)`,
+			args: []any{[]string{"stats", "size"}, []string{"1", "2"}, []string{"summary"}},
+		},
+		{
+			name: "metadata prefix and missing key",
+			in:   `{"and":[{"field":"metadata.heading","prefix":"Install"},{"field":"metadata.draft","exists":false}]}`,
+			sql:  `(COALESCE(starts_with(metadata #>> $3, $4), FALSE) AND metadata #> $5 IS NULL)`,
+			args: []any{[]string{"heading"}, "Install", []string{"draft"}},
+		},
+		{
+			name: "empty combinators",
+			in:   `{"or":[{"and":[]},{"or":[]}]}`,
+			sql:  `(TRUE OR FALSE)`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sql, args, err := parseFilter(t, tc.in).Compile(3)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if sql != tc.sql {
+				t.Fatalf("sql = %s\nwant  %s", sql, tc.sql)
+			}
+			if len(args) != len(tc.args) || (len(args) > 0 && !reflect.DeepEqual(args, tc.args)) {
+				t.Fatalf("args = %#v, want %#v", args, tc.args)
+			}
+		})
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	for _, in := range []string{
+		`{}`,
+		`{"field":"path"}`,
+		`{"field":"path","eq":"a","prefix":"b"}`,
+		`{"field":"content","eq":"x"}`,
+		`{"field":"metadata.","exists":true}`,
+		`{"field":"repo","eq":1}`,
+		`{"field":"repo","in":["a",2]}`,
+		`{"eq":"x"}`,
+		`{"field":"path","and":[]}`,
+		`{"not":{"field":"path"}}`,
+	} {
+		if err := parseFilter(t, in).Validate(); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%s: expected ErrInvalidFilter, got %v", in, err)
+		}
+	}
+}
+
+func TestFilterTooLarge(t *testing.T) {
+	leaf := Filter{Field: "metadata.type", Exists: new(bool)}
+	f := Filter{Or: make([]Filter, maxFilterNodes)}
+	for i := range f.Or {
+		f.Or[i] = leaf
+	}
+	if err := f.Validate(); !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}
+
+func TestSearchFilterClause(t *testing.T) {
+	where := &Filter{Field: "metadata.type", Eq: "heading"}
+	sql, args, err := SearchFilter{PathPrefix: "docs/", Where: where}.clause([]any{"q", 10})
+	if err != nil {
+		t.Fatalf("clause: %v", err)
+	}
+	if sql != ` AND (starts_with(path, $3) AND COALESCE(metadata #> $4 = $5::jsonb, FALSE))` {
+		t.Fatalf("unexpected clause %s", sql)
+	}
+	if len(args) != 5 || args[0] != "q" || args[2] != "docs/" {
+		t.Fatalf("unexpected args %#v", args)
+	}
+
+	sql, args, err = SearchFilter{}.clause([]any{"q", 10})
+	if err != nil || sql != "" || len(args) != 2 {
+		t.Fatalf("empty filter should add nothing: %q %v %v", sql, args, err)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	meta := map[string]any{"type": "toc", "stats": map[string]any{"size": float64(120)}, "draft": nil}
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{`{"field":"repo","eq":"kb"}`, true},
+		{`{"field":"path","prefix":"docs/ops/"}`, true},
+		{`{"field":"path","in":["a.md","b.md"]}`, false},
+		{`{"not":{"field":"metadata.type","eq":"toc"}}`, false},
+		{`{"not":{"field":"metadata.heading","eq":"x"}}`, true},
+		{`{"field":"metadata.stats.size","in":[120,"120"]}`, true},
+		{`{"field":"metadata.stats.size","eq":"120"}`, false},
+		{`{"field":"metadata.stats.size","prefix":"12"}`, true},
+		{`{"field":"metadata.draft","exists":true}`, true},
+		{`{"field":"metadata.type.sub","exists":false}`, true},
+		{`{"or":[{"and":[]},{"or":[]}]}`, true},
+	}
+	for _, tc := range cases {
+		f := parseFilter(t, tc.in)
+		if err := f.Validate(); err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		if got := f.Match("kb", "docs/ops/run.md", meta); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"unicode"
+)
+
+// BM25 parameters used by Memory.LexicalSearch.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Memory is an in-process VectorStore for local development and tests. Vector
+// search is a brute-force cosine scan and lexical search ranks with BM25.
+// Contents are lost when the process exits.
+type Memory struct {
+	mu   sync.RWMutex
+	dim  int
+	docs map[string]*memDoc
+	// cache maps model and content SHA to an embedding.
+	cache map[[2]string][]float32
+	// commits maps repo and path prefix to the last ingest state.
+	commits map[[2]string]IngestState
+}
+
+type memDoc struct {
+	row   DocRow
+	key   string
+	meta  []byte
+	terms map[string]int
+	size  int
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{docs: map[string]*memDoc{}, cache: map[[2]string][]float32{}, commits: map[[2]string]IngestState{}}
+}
+
+// EnsureSchema records the embedding dimension. Changing the dimension of a
+// store that holds documents requires migrate, which drops them.
+func (m *Memory) EnsureSchema(_ context.Context, dim int, migrate bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dim != 0 && m.dim != dim && len(m.docs) > 0 {
+		if !migrate {
+			return fmt.Errorf("embedding dimension %d does not match stored dimension %d", dim, m.dim)
+		}
+		m.docs = map[string]*memDoc{}
+		// The documents are gone, so the next ingest must be a full one.
+		m.commits = map[[2]string]IngestState{}
+	}
+	m.dim = dim
+	return nil
+}
+
+// Upsert inserts rows and updates those whose content, metadata or embedding
+// model changed. Embeddings of rows with an EmbeddingModel are also cached.
+func (m *Memory) Upsert(_ context.Context, rows []DocRow) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, r := range rows {
+		if m.dim != 0 && len(r.Embedding) != 0 && len(r.Embedding) != m.dim {
+			return count, fmt.Errorf("expected %d dimensions, not %d", m.dim, len(r.Embedding))
+		}
+		// Round-trip metadata through JSON so it reads back like jsonb.
+		meta, err := json.Marshal(r.Metadata)
+		if err != nil {
+			return count, err
+		}
+		if r.EmbeddingModel != "" && len(r.Embedding) > 0 {
+			ck := [2]string{r.EmbeddingModel, r.ContentSHA}
+			if _, ok := m.cache[ck]; !ok {
+				m.cache[ck] = append([]float32(nil), r.Embedding...)
+			}
+		}
+		key := DocKey(r.Repo, r.Path, r.ChunkID)
+		if old, ok := m.docs[key]; ok && old.row.ContentSHA == r.ContentSHA && string(old.meta) == string(meta) && old.row.EmbeddingModel == r.EmbeddingModel {
+			continue
+		}
+		r.Metadata = nil
+		_ = json.Unmarshal(meta, &r.Metadata)
+		r.Embedding = append([]float32(nil), r.Embedding...)
+		d := &memDoc{row: r, key: key, meta: meta, terms: map[string]int{}}
+		for _, t := range lexTerms(r.Content) {
+			d.terms[t]++
+			d.size++
+		}
+		m.docs[key] = d
+		count++
+	}
+	return count, nil
+}
+
+// Delete removes every chunk of the given paths in repo.
+func (m *Memory) Delete(_ context.Context, repo string, paths []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	drop := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		drop[p] = true
+	}
+	count := 0
+	for key, d := range m.docs {
+		if d.row.Repo == repo && drop[d.row.Path] {
+			delete(m.docs, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Reconcile deletes the rows of spec.Repo under spec.PathPrefix that are
+// neither in spec.Keep nor under spec.KeepPaths.
+func (m *Memory) Reconcile(_ context.Context, spec ReconcileSpec) (ReconcileResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keep := make(map[string]bool, len(spec.Keep))
+	for _, k := range spec.Keep {
+		keep[k] = true
+	}
+	keepPaths := make(map[string]bool, len(spec.KeepPaths))
+	for _, p := range spec.KeepPaths {
+		keepPaths[p] = true
+	}
+	var res ReconcileResult
+	deleted := map[string]bool{}
+	for key, d := range m.docs {
+		if d.row.Repo != spec.Repo || !spec.InScope(d.row.Path) || keepPaths[d.row.Path] || keep[key] {
+			continue
+		}
+		delete(m.docs, key)
+		deleted[d.row.Path] = true
+		res.Rows++
+	}
+	for _, d := range m.docs {
+		if d.row.Repo == spec.Repo {
+			delete(deleted, d.row.Path)
+		}
+	}
+	res.Paths = len(deleted)
+	return res, nil
+}
+
+// IngestedCommit returns the state last recorded for a datasource.
+func (m *Memory) IngestedCommit(_ context.Context, repo, pathPrefix string) (IngestState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.commits[[2]string{repo, pathPrefix}], nil
+}
+
+// SetIngestedCommit records the state ingested for a datasource.
+func (m *Memory) SetIngestedCommit(_ context.Context, repo, pathPrefix string, state IngestState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commits[[2]string{repo, pathPrefix}] = state
+	return nil
+}
+
+// VectorSearch returns the documents with the highest cosine similarity to
+// vec.
+func (m *Memory) VectorSearch(_ context.Context, vec []float32, limit int, f SearchFilter) ([]SearchHit, error) {
+	return m.search(f, limit, func(d *memDoc) (float64, bool) {
+		if len(d.row.Embedding) == 0 {
+			return 0, false
+		}
+		return Cosine(vec, d.row.Embedding), true
+	})
+}
+
+// LexicalSearch ranks the documents sharing at least one term with query by
+// BM25.
+func (m *Memory) LexicalSearch(_ context.Context, query string, limit int, f SearchFilter) ([]SearchHit, error) {
+	m.mu.RLock()
+	n := len(m.docs)
+	total := 0
+	df := map[string]int{}
+	terms := uniqueTerms(lexTerms(query))
+	for _, d := range m.docs {
+		total += d.size
+		for _, t := range terms {
+			if d.terms[t] > 0 {
+				df[t]++
+			}
+		}
+	}
+	m.mu.RUnlock()
+	if n == 0 || len(df) == 0 {
+		return nil, nil
+	}
+	avg := float64(total) / float64(n)
+	return m.search(f, limit, func(d *memDoc) (float64, bool) {
+		score := 0.0
+		for _, t := range terms {
+			tf := float64(d.terms[t])
+			if tf == 0 {
+				continue
+			}
+			idf := math.Log(1 + (float64(n)-float64(df[t])+0.5)/(float64(df[t])+0.5))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(d.size)/avg))
+		}
+		return score, score > 0
+	})
+}
+
+// search scores every document matching f and returns the best limit hits.
+func (m *Memory) search(f SearchFilter, limit int, score func(*memDoc) (float64, bool)) ([]SearchHit, error) {
+	expr := f.Filter()
+	if err := expr.Validate(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var hits []SearchHit
+	for _, d := range m.docs {
+		if !expr.Match(d.row.Repo, d.row.Path, d.row.Metadata) {
+			continue
+		}
+		s, ok := score(d)
+		if !ok {
+			continue
+		}
+		h := d.hit()
+		h.Score = s
+		hits = append(hits, h)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].DocKey < hits[j].DocKey
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// Embeddings returns the stored embeddings of the given documents keyed by
+// doc_key.
+func (m *Memory) Embeddings(_ context.Context, docKeys []string) (map[string][]float32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string][]float32, len(docKeys))
+	for _, k := range docKeys {
+		if d, ok := m.docs[k]; ok && len(d.row.Embedding) > 0 {
+			out[k] = d.row.Embedding
+		}
+	}
+	return out, nil
+}
+
+// CachedEmbeddings returns the embeddings made by model that can be reused for
+// keys, keyed by content SHA. A chunk's own document is used when its content
+// and model are unchanged, otherwise the cache is consulted.
+func (m *Memory) CachedEmbeddings(_ context.Context, model string, keys []EmbeddingKey) (map[string][]float32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := map[string][]float32{}
+	if model == "" {
+		return out, nil
+	}
+	for _, k := range keys {
+		if d, ok := m.docs[k.DocKey]; ok && d.row.ContentSHA == k.ContentSHA && d.row.EmbeddingModel == model && len(d.row.Embedding) > 0 {
+			out[k.ContentSHA] = d.row.Embedding
+		} else if vec, ok := m.cache[[2]string{model, k.ContentSHA}]; ok {
+			out[k.ContentSHA] = vec
+		}
+	}
+	return out, nil
+}
+
+// Section returns the chunks recorded for one section of a file, ordered by
+// chunk_id.
+func (m *Memory) Section(_ context.Context, repo, path string, section int) ([]SearchHit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var hits []SearchHit
+	for _, d := range m.docs {
+		if d.row.Repo != repo || d.row.Path != path {
+			continue
+		}
+		if s, ok := d.row.Metadata["section"].(float64); !ok || s != float64(section) {
+			continue
+		}
+		hits = append(hits, d.hit())
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].ChunkID < hits[j].ChunkID })
+	return hits, nil
+}
+
+func (d *memDoc) hit() SearchHit {
+	// Hand out a copy so callers may modify the metadata.
+	var meta map[string]any
+	_ = json.Unmarshal(d.meta, &meta)
+	return SearchHit{
+		Repo:     d.row.Repo,
+		Path:     d.row.Path,
+		ChunkID:  d.row.ChunkID,
+		DocKey:   d.key,
+		Content:  d.row.Content,
+		Metadata: meta,
+	}
+}
+
+// Cosine returns the cosine similarity of a and b, or 0 when either is
+// missing or their lengths differ.
+func Cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// lexTerms splits text into lower-cased terms. Letters and digits form words;
+// runs of Han characters yield their single characters and bigrams so that
+// Chinese text without spaces can be matched.
+func lexTerms(text string) []string {
+	var (
+		terms []string
+		word  []rune
+		han   []rune
+	)
+	flushWord := func() {
+		if len(word) > 0 {
+			terms = append(terms, string(word))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		for i, r := range han {
+			terms = append(terms, string(r))
+			if i+1 < len(han) {
+				terms = append(terms, string(han[i:i+2]))
+			}
+		}
+		han = han[:0]
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return terms
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := map[string]bool{}
+	out := terms[:0]
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func memoryFixture(t *testing.T) *Memory {
+	t.Helper()
+	m := NewMemory()
+	ctx := context.Background()
+	if err := m.EnsureSchema(ctx, 2, false); err != nil {
+		t.Fatalf("EnsureSchema: %v", err)
+	}
+	rows := []DocRow{
+		{Repo: "kb", Path: "deploy.md", ChunkID: 0, Content: "Deploy the server with make deploy.", Embedding: []float32{1, 0}, ContentSHA: "a", Metadata: map[string]any{"section": 0}},
+		{Repo: "kb", Path: "deploy.md", ChunkID: 1, Content: "Rollback with make rollback.", Embedding: []float32{0.8, 0.6}, ContentSHA: "b", Metadata: map[string]any{"section": 0}},
+		{Repo: "kb", Path: "zh.md", ChunkID: 0, Content: "如何部署服务器", Embedding: []float32{0, 1}, ContentSHA: "c", Metadata: map[string]any{"section": 1, "type": "toc"}},
+	}
+	if n, err := m.Upsert(ctx, rows); err != nil || n != 3 {
+		t.Fatalf("Upsert = %d, %v", n, err)
+	}
+	return m
+}
+
+func TestMemoryVectorSearch(t *testing.T) {
+	m := memoryFixture(t)
+	hits, err := m.VectorSearch(context.Background(), []float32{1, 0}, 2, SearchFilter{})
+	if err != nil {
+		t.Fatalf("VectorSearch: %v", err)
+	}
+	if len(hits) != 2 || hits[0].DocKey != "kb:deploy.md:0" || hits[1].DocKey != "kb:deploy.md:1" {
+		t.Fatalf("unexpected hits %+v", hits)
+	}
+	if hits[0].Score != 1 || hits[1].Score < 0.79 || hits[1].Score > 0.81 {
+		t.Fatalf("unexpected scores %v %v", hits[0].Score, hits[1].Score)
+	}
+
+	where := &Filter{Field: "metadata.type", Eq: "toc"}
+	hits, err = m.VectorSearch(context.Background(), []float32{1, 0}, 5, SearchFilter{Where: where})
+	if err != nil || len(hits) != 1 || hits[0].Path != "zh.md" {
+		t.Fatalf("filtered search = %+v, %v", hits, err)
+	}
+	if _, err := m.VectorSearch(context.Background(), []float32{1, 0}, 5, SearchFilter{Where: &Filter{}}); err == nil {
+		t.Fatal("expected invalid filter error")
+	}
+}
+
+func TestMemoryLexicalSearch(t *testing.T) {
+	m := memoryFixture(t)
+	hits, err := m.LexicalSearch(context.Background(), "make ROLLBACK", 5, SearchFilter{})
+	if err != nil {
+		t.Fatalf("LexicalSearch: %v", err)
+	}
+	if len(hits) != 2 || hits[0].ChunkID != 1 || hits[0].Score <= hits[1].Score {
+		t.Fatalf("unexpected hits %+v", hits)
+	}
+
+	hits, err = m.LexicalSearch(context.Background(), "部署", 5, SearchFilter{})
+	if err != nil || len(hits) != 1 || hits[0].Path != "zh.md" {
+		t.Fatalf("chinese search = %+v, %v", hits, err)
+	}
+
+	hits, err = m.LexicalSearch(context.Background(), "kubernetes", 5, SearchFilter{})
+	if err != nil || len(hits) != 0 {
+		t.Fatalf("expected no hits, got %+v, %v", hits, err)
+	}
+}
+
+func TestMemoryUpsertDeleteSection(t *testing.T) {
+	m := memoryFixture(t)
+	ctx := context.Background()
+
+	same := DocRow{Repo: "kb", Path: "deploy.md", ChunkID: 0, Content: "Deploy the server with make deploy.", Embedding: []float32{1, 0}, ContentSHA: "a", Metadata: map[string]any{"section": 0}}
+	if n, _ := m.Upsert(ctx, []DocRow{same}); n != 0 {
+		t.Fatalf("unchanged row should not be rewritten, got %d", n)
+	}
+	same.Metadata = map[string]any{"section": 0, "heading": "Deploy"}
+	if n, _ := m.Upsert(ctx, []DocRow{same}); n != 1 {
+		t.Fatalf("changed metadata should be rewritten, got %d", n)
+	}
+	if _, err := m.Upsert(ctx, []DocRow{{Repo: "kb", Path: "x.md", Embedding: []float32{1, 2, 3}}}); err == nil {
+		t.Fatal("expected dimension error")
+	}
+
+	sec, err := m.Section(ctx, "kb", "deploy.md", 0)
+	if err != nil || len(sec) != 2 || sec[0].ChunkID != 0 || sec[1].ChunkID != 1 {
+		t.Fatalf("Section = %+v, %v", sec, err)
+	}
+	vecs, _ := m.Embeddings(ctx, []string{"kb:zh.md:0", "kb:missing.md:0"})
+	if len(vecs) != 1 || vecs["kb:zh.md:0"][1] != 1 {
+		t.Fatalf("Embeddings = %v", vecs)
+	}
+
+	if n, err := m.Delete(ctx, "kb", []string{"deploy.md"}); err != nil || n != 2 {
+		t.Fatalf("Delete = %d, %v", n, err)
+	}
+	if err := m.EnsureSchema(ctx, 3, false); err == nil {
+		t.Fatal("expected dimension change to need migrate")
+	}
+	if err := m.EnsureSchema(ctx, 3, true); err != nil {
+		t.Fatalf("EnsureSchema migrate: %v", err)
+	}
+	if hits, _ := m.VectorSearch(ctx, []float32{0, 1, 0}, 5, SearchFilter{}); len(hits) != 0 {
+		t.Fatalf("migrate should drop documents, got %+v", hits)
+	}
+}
+
+func TestMemoryCachedEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	rows := []DocRow{
+		{Repo: "kb", Path: "a.md", ChunkID: 0, Content: "x", Embedding: []float32{1, 0}, ContentSHA: "a", EmbeddingModel: "m1"},
+		{Repo: "kb", Path: "a.md", ChunkID: 1, Content: "y", Embedding: []float32{0, 1}, ContentSHA: "b"},
+	}
+	if _, err := m.Upsert(ctx, rows); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	keys := []EmbeddingKey{
+		{DocKey: "kb:a.md:0", ContentSHA: "a"},
+		// Same content in another repo is served from the cache.
+		{DocKey: "other:b.md:4", ContentSHA: "a"},
+		// Rows without a model are never reused.
+		{DocKey: "kb:a.md:1", ContentSHA: "b"},
+	}
+	got, err := m.CachedEmbeddings(ctx, "m1", keys)
+	if err != nil {
+		t.Fatalf("CachedEmbeddings: %v", err)
+	}
+	if len(got) != 1 || got["a"][0] != 1 {
+		t.Fatalf("unexpected embeddings %v", got)
+	}
+	if got, _ := m.CachedEmbeddings(ctx, "m2", keys); len(got) != 0 {
+		t.Fatalf("expected no embeddings for another model, got %v", got)
+	}
+
+	// Re-embedding with another model replaces the stored vector.
+	rows[0].EmbeddingModel, rows[0].Embedding = "m2", []float32{0, 1}
+	if n, err := m.Upsert(ctx, rows[:1]); err != nil || n != 1 {
+		t.Fatalf("Upsert = %d, %v", n, err)
+	}
+	got, _ = m.CachedEmbeddings(ctx, "m2", keys[:1])
+	if got["a"][1] != 1 {
+		t.Fatalf("unexpected embeddings %v", got)
+	}
+}
+
+func TestMemoryReconcile(t *testing.T) {
+	m := memoryFixture(t)
+	ctx := context.Background()
+	extra := []DocRow{
+		{Repo: "kb", Path: "guide/a.md", ChunkID: 0, Embedding: []float32{1, 0}, ContentSHA: "d"},
+		{Repo: "kb", Path: "guide/a.md", ChunkID: 1, Embedding: []float32{1, 0}, ContentSHA: "e"},
+		{Repo: "kb", Path: "guide/b.md", ChunkID: 0, Embedding: []float32{1, 0}, ContentSHA: "f"},
+		{Repo: "kb", Path: "guide/c.md", ChunkID: 0, Embedding: []float32{1, 0}, ContentSHA: "g"},
+		{Repo: "other", Path: "guide/b.md", ChunkID: 0, Embedding: []float32{1, 0}, ContentSHA: "h"},
+	}
+	if _, err := m.Upsert(ctx, extra); err != nil {
+		t.Fatal(err)
+	}
+
+	// a.md shrank to one chunk, b.md is gone and c.md failed to ingest.
+	res, err := m.Reconcile(ctx, ReconcileSpec{
+		Repo:       "kb",
+		PathPrefix: "guide/",
+		Keep:       []string{DocKey("kb", "guide/a.md", 0)},
+		KeepPaths:  []string{"guide/c.md"},
+	})
+	if err != nil || res.Rows != 2 || res.Paths != 1 {
+		t.Fatalf("Reconcile = %+v, %v", res, err)
+	}
+	vecs, _ := m.Embeddings(ctx, []string{"kb:guide/a.md:0", "kb:guide/c.md:0", "other:guide/b.md:0", "kb:deploy.md:0"})
+	if len(vecs) != 4 {
+		t.Fatalf("unexpected remaining rows %v", vecs)
+	}
+}
+
+func TestReconcileSpecInScope(t *testing.T) {
+	spec := ReconcileSpec{PathPrefix: "docs"}
+	for path, want := range map[string]bool{"docs/a.md": true, "docs": true, "docsx/a.md": false, "a.md": false} {
+		if got := spec.InScope(path); got != want {
+			t.Errorf("InScope(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if !(ReconcileSpec{}).InScope("a.md") {
+		t.Error("empty prefix should match every path")
+	}
+	spec.Paths = []string{"docs/a.md"}
+	if !spec.InScope("docs/a.md") || spec.InScope("docs/b.md") {
+		t.Error("Paths should limit the scope")
+	}
+}
+
+func TestMemoryIngestedCommit(t *testing.T) {
+	m := memoryFixture(t)
+	ctx := context.Background()
+	if c, err := m.IngestedCommit(ctx, "kb", "docs"); err != nil || c != (IngestState{}) {
+		t.Fatalf("IngestedCommit = %+v, %v", c, err)
+	}
+	want := IngestState{Commit: "abc", Config: "f00"}
+	if err := m.SetIngestedCommit(ctx, "kb", "docs", want); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := m.IngestedCommit(ctx, "kb", "docs"); c != want {
+		t.Fatalf("IngestedCommit = %+v", c)
+	}
+	// Dropping the documents forgets what was ingested.
+	if err := m.EnsureSchema(ctx, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := m.IngestedCommit(ctx, "kb", "docs"); c != (IngestState{}) {
+		t.Fatalf("IngestedCommit after migrate = %+v", c)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	if c := Cosine([]float32{1, 0}, []float32{2, 0}); math.Abs(c-1) > 1e-9 {
+		t.Fatalf("Cosine = %v", c)
+	}
+	if c := Cosine([]float32{1, 0}, nil); c != 0 {
+		t.Fatalf("missing vector should give 0, got %v", c)
+	}
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+// SearchFilter restricts search results to documents whose repo and path start
+// with the given prefixes and that match Where. Empty fields match everything.
+type SearchFilter struct {
+	RepoPrefix string
+	PathPrefix string
+	Where      *Filter
+}
+
+// Filter combines the prefixes and Where into a single expression.
+func (f SearchFilter) Filter() *Filter {
+	var all []Filter
+	if f.RepoPrefix != "" {
+		p := f.RepoPrefix
+		all = append(all, Filter{Field: "repo", Prefix: &p})
+	}
+	if f.PathPrefix != "" {
+		p := f.PathPrefix
+		all = append(all, Filter{Field: "path", Prefix: &p})
+	}
+	if f.Where != nil {
+		all = append(all, *f.Where)
+	}
+	switch len(all) {
+	case 0:
+		return nil
+	case 1:
+		return &all[0]
+	}
+	return &Filter{And: all}
+}
+
+// clause compiles the filter into an "AND ..." suffix for a WHERE clause whose
+// first len(args) placeholders are taken.
+func (f SearchFilter) clause(args []any) (string, []any, error) {
+	expr := f.Filter()
+	if expr == nil {
+		return "", args, nil
+	}
+	sql, extra, err := expr.Compile(len(args) + 1)
+	if err != nil {
+		return "", nil, err
+	}
+	return " AND " + sql, append(args, extra...), nil
+}
+
+// SearchHit is a document returned by a search together with its raw score.
+// Vector hits are scored by similarity (inner product in Postgres, cosine in
+// memory), lexical hits by ts_rank_cd in Postgres and BM25 in memory.
+type SearchHit struct {
+	Repo     string
+	Path     string
+	ChunkID  int
+	DocKey   string
+	Content  string
+	Metadata map[string]any
+	Score    float64
+}
+
+// VectorSearch returns the documents closest to vec.
+func (p *Postgres) VectorSearch(ctx context.Context, vec []float32, limit int, f SearchFilter) ([]SearchHit, error) {
+	where, args, err := f.clause([]any{pgvector.NewVector(vec), limit})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.conn.Query(ctx, `SELECT repo,path,chunk_id,doc_key,content,metadata, -(embedding <#> $1) AS score
+        FROM documents
+        WHERE embedding IS NOT NULL`+where+`
+        ORDER BY embedding <#> $1
+        LIMIT $2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanHits(rows)
+}
+
+// LexicalSearch returns the documents best matching query using full text
+// search.
+func (p *Postgres) LexicalSearch(ctx context.Context, query string, limit int, f SearchFilter) ([]SearchHit, error) {
+	where, args, err := f.clause([]any{query, limit})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.conn.Query(ctx, `SELECT repo,path,chunk_id,doc_key,content,metadata, ts_rank_cd(content_tsv, websearch_to_tsquery('zhcn_search', $1)) AS score
+        FROM documents
+        WHERE content_tsv @@ websearch_to_tsquery('zhcn_search', $1)`+where+`
+        ORDER BY score DESC
+        LIMIT $2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanHits(rows)
+}
+
+func scanHits(rows pgx.Rows) ([]SearchHit, error) {
+	defer rows.Close()
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		var meta []byte
+		if err := rows.Scan(&h.Repo, &h.Path, &h.ChunkID, &h.DocKey, &h.Content, &meta, &h.Score); err != nil {
+			return nil, err
+		}
+		if len(meta) > 0 {
+			_ = json.Unmarshal(meta, &h.Metadata)
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// Embeddings returns the stored embeddings of the given documents keyed by
+// doc_key. Documents without an embedding are omitted.
+func (p *Postgres) Embeddings(ctx context.Context, docKeys []string) (map[string][]float32, error) {
+	out := make(map[string][]float32, len(docKeys))
+	if len(docKeys) == 0 {
+		return out, nil
+	}
+	rows, err := p.conn.Query(ctx, `SELECT doc_key, embedding FROM documents WHERE doc_key = ANY($1) AND embedding IS NOT NULL`, docKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var vec pgvector.Vector
+		if err := rows.Scan(&key, &vec); err != nil {
+			return nil, err
+		}
+		out[key] = vec.Slice()
+	}
+	return out, rows.Err()
+}
+
+// Section returns the chunks recorded for one section of a file, ordered by
+// chunk_id. Chunks are matched on the "section" metadata key written by
+// ingest.BuildChunks.
+func (p *Postgres) Section(ctx context.Context, repo, path string, section int) ([]SearchHit, error) {
+	rows, err := p.conn.Query(ctx, `SELECT repo,path,chunk_id,doc_key,content,metadata, 0::float8 AS score
+        FROM documents
+        WHERE repo = $1 AND path = $2 AND metadata -> 'section' = to_jsonb($3::int)
+        ORDER BY chunk_id`,
+		repo, path, section)
+	if err != nil {
+		return nil, err
+	}
+	return scanHits(rows)
+}
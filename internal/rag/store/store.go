@@ -3,9 +3,13 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	pgvector "github.com/pgvector/pgvector-go"
 )
 
@@ -18,12 +22,128 @@ type DocRow struct {
 	Embedding  []float32      `json:"embedding"`
 	Metadata   map[string]any `json:"metadata"`
 	ContentSHA string         `json:"content_sha"`
+	// EmbeddingModel identifies the model that produced Embedding. Stored
+	// embeddings are only reused for the same model.
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+}
+
+// DocKey returns the doc_key of a chunk.
+func DocKey(repo, path string, chunkID int) string {
+	return fmt.Sprintf("%s:%s:%d", repo, path, chunkID)
+}
+
+// EmbeddingKey identifies a chunk whose embedding may be reused.
+type EmbeddingKey struct {
+	DocKey     string `json:"doc_key"`
+	ContentSHA string `json:"content_sha"`
+}
+
+// ReconcileSpec describes the rows an ingest run produced for one
+// datasource.
+type ReconcileSpec struct {
+	Repo string `json:"repo"`
+	// PathPrefix limits reconciliation to the paths under this directory.
+	// Empty means the whole repo.
+	PathPrefix string `json:"path_prefix,omitempty"`
+	// Keep lists the doc keys written by the run.
+	Keep []string `json:"keep"`
+	// KeepPaths lists paths whose rows are all kept, such as files that
+	// failed to ingest this time.
+	KeepPaths []string `json:"keep_paths,omitempty"`
+	// Paths, when not empty, limits reconciliation to these paths. An
+	// incremental run passes the files it re-ingested and those deleted
+	// upstream.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// IngestState is what was last ingested for a datasource.
+type IngestState struct {
+	Commit string `json:"commit"`
+	// Config fingerprints the chunking and embedding settings the commit was
+	// ingested with.
+	Config string `json:"config"`
+}
+
+// ReconcileResult reports what Reconcile deleted.
+type ReconcileResult struct {
+	Rows int `json:"rows"`
+	// Paths counts paths left without any row, that is files deleted or
+	// renamed upstream.
+	Paths int `json:"paths"`
+}
+
+// InScope reports whether path lies under the prefix of spec and, when
+// spec.Paths is set, is one of them.
+func (spec ReconcileSpec) InScope(path string) bool {
+	if len(spec.Paths) > 0 && !slices.Contains(spec.Paths, path) {
+		return false
+	}
+	prefix := strings.TrimSuffix(spec.PathPrefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// VectorStore stores document chunks and serves the searches used by
+// retrieval.
+type VectorStore interface {
+	// EnsureSchema prepares the store for embeddings of dimension dim.
+	EnsureSchema(ctx context.Context, dim int, migrate bool) error
+	// Upsert inserts rows and updates those whose content or metadata
+	// changed. It returns the number of affected rows.
+	Upsert(ctx context.Context, rows []DocRow) (int, error)
+	// Delete removes every chunk of the given paths in repo.
+	Delete(ctx context.Context, repo string, paths []string) (int, error)
+	// Reconcile deletes, in one transaction, the rows of spec.Repo in the
+	// scope of spec that are neither in spec.Keep nor under spec.KeepPaths.
+	Reconcile(ctx context.Context, spec ReconcileSpec) (ReconcileResult, error)
+	// IngestedCommit returns the state last recorded for the datasource
+	// with the given repo and path prefix, zero when there is none.
+	IngestedCommit(ctx context.Context, repo, pathPrefix string) (IngestState, error)
+	// SetIngestedCommit records the state ingested for a datasource.
+	SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state IngestState) error
+	// VectorSearch returns the limit documents most similar to vec.
+	VectorSearch(ctx context.Context, vec []float32, limit int, f SearchFilter) ([]SearchHit, error)
+	// LexicalSearch returns the limit documents best matching query.
+	LexicalSearch(ctx context.Context, query string, limit int, f SearchFilter) ([]SearchHit, error)
+	// Embeddings returns the stored embeddings of docKeys by doc_key.
+	Embeddings(ctx context.Context, docKeys []string) (map[string][]float32, error)
+	// Section returns the chunks of one markdown section ordered by chunk_id.
+	Section(ctx context.Context, repo, path string, section int) ([]SearchHit, error)
+	// CachedEmbeddings returns the embeddings made by model that can be
+	// reused for keys, keyed by content SHA.
+	CachedEmbeddings(ctx context.Context, model string, keys []EmbeddingKey) (map[string][]float32, error)
+}
+
+// Vector store backends selectable with vectordb.backend.
+const (
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+// DB is the subset of pgx used by Postgres. It is satisfied by *pgx.Conn and
+// *pgxpool.Pool; only a pool is safe for concurrent use.
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Postgres is the pgvector backed VectorStore. Lexical search uses the
+// zhparser text search configuration.
+type Postgres struct {
+	conn DB
+}
+
+// NewPostgres returns a VectorStore that uses db.
+func NewPostgres(db DB) *Postgres {
+	return &Postgres{conn: db}
 }
 
 // EnsureSchema creates the documents table and minimal indexes required for
 // hybrid search. It ensures extensions and text search configuration needed by
 // the RAG service.
-func EnsureSchema(ctx context.Context, conn *pgx.Conn, dim int, _ bool) error {
+func (p *Postgres) EnsureSchema(ctx context.Context, dim int, _ bool) error {
+	conn := p.conn
 	if _, err := conn.Exec(ctx, `CREATE EXTENSION IF NOT EXISTS vector`); err != nil {
 		return err
 	}
@@ -59,6 +179,37 @@ END$$;`); err != nil {
 	if _, err := conn.Exec(ctx, create); err != nil {
 		return err
 	}
+	if _, err := conn.Exec(ctx, `ALTER TABLE documents ADD COLUMN IF NOT EXISTS embedding_model TEXT`); err != nil {
+		return err
+	}
+	// embedding_cache holds every embedding by the SHA of the embedded text,
+	// so identical chunks in other paths or repos are not embedded again.
+	// REAL[] keeps it independent of the documents vector dimension.
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS embedding_cache (
+        model TEXT NOT NULL,
+        content_sha TEXT NOT NULL,
+        embedding REAL[] NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        PRIMARY KEY (model, content_sha)
+    )`); err != nil {
+		return err
+	}
+	// ingest_state remembers the last commit ingested for each datasource,
+	// identified by repo and path prefix, and the fingerprint of the
+	// configuration it was ingested with.
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS ingest_state (
+        repo TEXT NOT NULL,
+        path_prefix TEXT NOT NULL,
+        commit_hash TEXT NOT NULL,
+        config_hash TEXT NOT NULL DEFAULT '',
+        updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        PRIMARY KEY (repo, path_prefix)
+    )`); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, `ALTER TABLE ingest_state ADD COLUMN IF NOT EXISTS config_hash TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
 	if _, err := conn.Exec(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS documents_doc_key_uk ON documents (doc_key)`); err != nil {
 		return err
 	}
@@ -74,26 +225,45 @@ END$$;`); err != nil {
 	return nil
 }
 
-// UpsertDocuments upserts rows and returns affected row count.
-func UpsertDocuments(ctx context.Context, conn *pgx.Conn, rows []DocRow) (int, error) {
+// Upsert inserts rows and updates those whose content, metadata or embedding
+// model changed. It returns the number of affected rows. Embeddings of rows
+// with an EmbeddingModel are also added to the embedding cache.
+func (p *Postgres) Upsert(ctx context.Context, rows []DocRow) (int, error) {
 	if len(rows) == 0 {
 		return 0, nil
 	}
 	batch := &pgx.Batch{}
 	for _, r := range rows {
 		meta, _ := json.Marshal(r.Metadata)
-		batch.Queue(`INSERT INTO documents (repo,path,chunk_id,content,embedding,metadata,content_sha)
-            VALUES ($1,$2,$3,$4,$5,$6,$7)
+		var model *string
+		if r.EmbeddingModel != "" {
+			model = &r.EmbeddingModel
+		}
+		batch.Queue(`INSERT INTO documents (repo,path,chunk_id,content,embedding,metadata,content_sha,embedding_model)
+            VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
             ON CONFLICT (doc_key) DO UPDATE
             SET content=EXCLUDED.content,
                 embedding=EXCLUDED.embedding,
                 metadata=EXCLUDED.metadata,
                 content_sha=EXCLUDED.content_sha,
+                embedding_model=EXCLUDED.embedding_model,
                 updated_at=now()
-            WHERE documents.content_sha<>EXCLUDED.content_sha`,
-			r.Repo, r.Path, r.ChunkID, r.Content, pgvector.NewVector(r.Embedding), meta, r.ContentSHA)
+            WHERE documents.content_sha<>EXCLUDED.content_sha
+               OR documents.metadata IS DISTINCT FROM EXCLUDED.metadata
+               OR documents.embedding_model IS DISTINCT FROM EXCLUDED.embedding_model`,
+			r.Repo, r.Path, r.ChunkID, r.Content, pgvector.NewVector(r.Embedding), meta, r.ContentSHA, model)
+	}
+	cached := 0
+	for _, r := range rows {
+		if r.EmbeddingModel == "" || len(r.Embedding) == 0 {
+			continue
+		}
+		batch.Queue(`INSERT INTO embedding_cache (model, content_sha, embedding) VALUES ($1,$2,$3)
+            ON CONFLICT (model, content_sha) DO NOTHING`,
+			r.EmbeddingModel, r.ContentSHA, r.Embedding)
+		cached++
 	}
-	br := conn.SendBatch(ctx, batch)
+	br := p.conn.SendBatch(ctx, batch)
 	count := 0
 	for range rows {
 		ct, err := br.Exec()
@@ -103,5 +273,219 @@ func UpsertDocuments(ctx context.Context, conn *pgx.Conn, rows []DocRow) (int, e
 		}
 		count += int(ct.RowsAffected())
 	}
+	for i := 0; i < cached; i++ {
+		if _, err := br.Exec(); err != nil {
+			br.Close()
+			return count, err
+		}
+	}
 	return count, br.Close()
 }
+
+// Delete removes every chunk of the given paths in repo and returns the
+// number of deleted rows.
+func (p *Postgres) Delete(ctx context.Context, repo string, paths []string) (int, error) {
+	if len(paths) == 0 {
+		return 0, nil
+	}
+	ct, err := p.conn.Exec(ctx, `DELETE FROM documents WHERE repo = $1 AND path = ANY($2)`, repo, paths)
+	if err != nil {
+		return 0, err
+	}
+	return int(ct.RowsAffected()), nil
+}
+
+// Reconcile deletes the rows of spec.Repo under spec.PathPrefix that are
+// neither in spec.Keep nor under spec.KeepPaths. The kept doc keys are copied
+// into a temporary table so that large repositories need no huge parameter
+// list; everything runs in one transaction.
+func (p *Postgres) Reconcile(ctx context.Context, spec ReconcileSpec) (ReconcileResult, error) {
+	var res ReconcileResult
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `CREATE TEMP TABLE reconcile_keep (doc_key TEXT PRIMARY KEY) ON COMMIT DROP`); err != nil {
+		return res, err
+	}
+	seen := make(map[string]bool, len(spec.Keep))
+	keep := make([][]any, 0, len(spec.Keep))
+	for _, k := range spec.Keep {
+		if !seen[k] {
+			seen[k] = true
+			keep = append(keep, []any{k})
+		}
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"reconcile_keep"}, []string{"doc_key"}, pgx.CopyFromRows(keep)); err != nil {
+		return res, err
+	}
+
+	prefix := strings.TrimSuffix(spec.PathPrefix, "/")
+	keepPaths := spec.KeepPaths
+	if keepPaths == nil {
+		keepPaths = []string{}
+	}
+	paths := spec.Paths
+	if paths == nil {
+		paths = []string{}
+	}
+	rows, err := tx.Query(ctx, `DELETE FROM documents d
+        WHERE d.repo = $1
+          AND ($2 = '' OR d.path = $2 OR d.path LIKE $3 ESCAPE '\')
+          AND (cardinality($5::text[]) = 0 OR d.path = ANY($5))
+          AND NOT (d.path = ANY($4))
+          AND NOT EXISTS (SELECT 1 FROM reconcile_keep k WHERE k.doc_key = d.doc_key)
+        RETURNING d.path`, spec.Repo, prefix, likeEscape(prefix)+"/%", keepPaths, paths)
+	if err != nil {
+		return res, err
+	}
+	deleted := map[string]bool{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return res, err
+		}
+		deleted[path] = true
+		res.Rows++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+
+	if len(deleted) > 0 {
+		paths := make([]string, 0, len(deleted))
+		for path := range deleted {
+			paths = append(paths, path)
+		}
+		rows, err := tx.Query(ctx, `SELECT DISTINCT path FROM documents WHERE repo = $1 AND path = ANY($2)`, spec.Repo, paths)
+		if err != nil {
+			return res, err
+		}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				rows.Close()
+				return res, err
+			}
+			delete(deleted, path)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return res, err
+		}
+		res.Paths = len(deleted)
+	}
+	return res, tx.Commit(ctx)
+}
+
+// IngestedCommit returns the state last recorded for the datasource with the
+// given repo and path prefix. It returns a zero state when there is none,
+// including before EnsureSchema created the ingest_state table.
+func (p *Postgres) IngestedCommit(ctx context.Context, repo, pathPrefix string) (IngestState, error) {
+	var st IngestState
+	rows, err := p.conn.Query(ctx, `SELECT commit_hash, config_hash FROM ingest_state WHERE repo = $1 AND path_prefix = $2`, repo, pathPrefix)
+	if err != nil {
+		if undefinedTable(err) {
+			return st, nil
+		}
+		return st, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&st.Commit, &st.Config); err != nil {
+			return IngestState{}, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if undefinedTable(err) {
+			return IngestState{}, nil
+		}
+		return IngestState{}, err
+	}
+	return st, nil
+}
+
+// undefinedTable reports whether err is Postgres' undefined_table error.
+func undefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42P01"
+}
+
+// SetIngestedCommit records the state ingested for a datasource.
+func (p *Postgres) SetIngestedCommit(ctx context.Context, repo, pathPrefix string, state IngestState) error {
+	_, err := p.conn.Exec(ctx, `INSERT INTO ingest_state (repo, path_prefix, commit_hash, config_hash) VALUES ($1,$2,$3,$4)
+        ON CONFLICT (repo, path_prefix) DO UPDATE SET commit_hash = EXCLUDED.commit_hash, config_hash = EXCLUDED.config_hash, updated_at = now()`,
+		repo, pathPrefix, state.Commit, state.Config)
+	return err
+}
+
+// likeEscape escapes the LIKE wildcards in s.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// CachedEmbeddings returns the embeddings made by model that can be reused for
+// keys, keyed by content SHA. A chunk's own row is used when its content and
+// model are unchanged; other chunks are looked up in the embedding cache by
+// content SHA.
+func (p *Postgres) CachedEmbeddings(ctx context.Context, model string, keys []EmbeddingKey) (map[string][]float32, error) {
+	out := map[string][]float32{}
+	if model == "" || len(keys) == 0 {
+		return out, nil
+	}
+	want := make(map[string]string, len(keys))
+	docKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		want[k.DocKey] = k.ContentSHA
+		docKeys = append(docKeys, k.DocKey)
+	}
+	rows, err := p.conn.Query(ctx, `SELECT doc_key, content_sha, embedding FROM documents
+        WHERE doc_key = ANY($1) AND embedding_model = $2 AND embedding IS NOT NULL`, docKeys, model)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key, sha string
+		var vec pgvector.Vector
+		if err := rows.Scan(&key, &sha, &vec); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if want[key] == sha {
+			out[sha] = vec.Slice()
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var shas []string
+	for _, k := range keys {
+		if _, ok := out[k.ContentSHA]; !ok {
+			shas = append(shas, k.ContentSHA)
+		}
+	}
+	if len(shas) == 0 {
+		return out, nil
+	}
+	rows, err = p.conn.Query(ctx, `SELECT content_sha, embedding FROM embedding_cache
+        WHERE model = $1 AND content_sha = ANY($2)`, model, shas)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sha string
+		var vec []float32
+		if err := rows.Scan(&sha, &vec); err != nil {
+			return nil, err
+		}
+		out[sha] = vec
+	}
+	return out, rows.Err()
+}
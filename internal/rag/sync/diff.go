@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// ErrCommitNotFound is returned by Diff when a commit or its tree is not in
+// the local clone, typically because a shallow fetch never contained it.
+var ErrCommitNotFound = errors.New("commit not found in clone")
+
+// Changes lists the files that differ between two commits. Paths are
+// relative to the repository root and use forward slashes. A renamed file
+// shows up as deleted under its old path and added under its new one.
+type Changes struct {
+	// Modified lists files added or modified.
+	Modified []string
+	// Deleted lists files removed.
+	Deleted []string
+}
+
+// Diff compares the trees of the commits from and to in the repository at
+// workdir.
+func Diff(ctx context.Context, workdir, from, to string) (Changes, error) {
+	var out Changes
+	r, err := git.PlainOpen(workdir)
+	if err != nil {
+		return out, err
+	}
+	fromTree, err := commitTree(r, from)
+	if err != nil {
+		return out, err
+	}
+	toTree, err := commitTree(r, to)
+	if err != nil {
+		return out, err
+	}
+	changes, err := object.DiffTreeWithOptions(ctx, fromTree, toTree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return out, fmt.Errorf("diff %s..%s: %w", from, to, ErrCommitNotFound)
+		}
+		return out, err
+	}
+	for _, ch := range changes {
+		action, err := ch.Action()
+		if err != nil {
+			return out, err
+		}
+		switch action {
+		case merkletrie.Delete:
+			out.Deleted = append(out.Deleted, ch.From.Name)
+		case merkletrie.Insert:
+			out.Modified = append(out.Modified, ch.To.Name)
+		case merkletrie.Modify:
+			if ch.From.Name != ch.To.Name {
+				out.Deleted = append(out.Deleted, ch.From.Name)
+			}
+			out.Modified = append(out.Modified, ch.To.Name)
+		}
+	}
+	return out, nil
+}
+
+// commitTree returns the tree of the commit with the given hash.
+func commitTree(r *git.Repository, hash string) (*object.Tree, error) {
+	c, err := r.CommitObject(plumbing.NewHash(hash))
+	if err == nil {
+		var tree *object.Tree
+		if tree, err = c.Tree(); err == nil {
+			return tree, nil
+		}
+	}
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, fmt.Errorf("commit %s: %w", hash, ErrCommitNotFound)
+	}
+	return nil, err
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// commitFiles writes files (nil content deletes) into the worktree of r and
+// commits them, returning the commit hash.
+func commitFiles(t *testing.T, r *git.Repository, dir string, files map[string]*string) string {
+	t.Helper()
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if content == nil {
+			if _, err := w.Remove(name); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(*content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := w.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.String()
+}
+
+func text(s string) *string { return &s }
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, r, dir, map[string]*string{
+		"docs/a.md":   text("# A\n"),
+		"docs/b.md":   text("# B\n\nlong enough to be recognised after the rename\n"),
+		"docs/c.md":   text("# C\n"),
+		"README.md":   text("# Readme\n"),
+		"docs/img.md": text("# Img\n"),
+	})
+	second := commitFiles(t, r, dir, map[string]*string{
+		"docs/a.md":   text("# A\n\nchanged\n"),
+		"docs/c.md":   nil,
+		"docs/new.md": text("# New\n"),
+	})
+	// Rename b.md without changing it.
+	if err := os.Rename(filepath.Join(dir, "docs/b.md"), filepath.Join(dir, "docs/b2.md")); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := r.Worktree()
+	if _, err := w.Remove("docs/b.md"); err != nil {
+		t.Fatal(err)
+	}
+	third := commitFiles(t, r, dir, map[string]*string{"docs/b2.md": text("# B\n\nlong enough to be recognised after the rename\n")})
+
+	ch, err := Diff(context.Background(), dir, first, third)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	sort.Strings(ch.Modified)
+	sort.Strings(ch.Deleted)
+	if want := []string{"docs/a.md", "docs/b2.md", "docs/new.md"}; !reflect.DeepEqual(ch.Modified, want) {
+		t.Fatalf("Modified = %v, want %v", ch.Modified, want)
+	}
+	if want := []string{"docs/b.md", "docs/c.md"}; !reflect.DeepEqual(ch.Deleted, want) {
+		t.Fatalf("Deleted = %v, want %v", ch.Deleted, want)
+	}
+
+	if ch, err := Diff(context.Background(), dir, second, second); err != nil || len(ch.Modified)+len(ch.Deleted) != 0 {
+		t.Fatalf("Diff of a commit with itself = %+v, %v", ch, err)
+	}
+	if _, err := Diff(context.Background(), dir, "0123456789012345678901234567890123456789", third); !errors.Is(err, ErrCommitNotFound) {
+		t.Fatalf("expected ErrCommitNotFound, got %v", err)
+	}
+}
@@ -2,81 +2,296 @@ package sync
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
+	"path"
+	"strings"
 
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+
+	cfgpkg "rag-server/internal/rag/config"
 )
 
-// SyncRepo ensures the repository at workdir matches the remote url. It
-// performs a shallow clone when the directory does not exist, otherwise a
-// fetch and reset. The returned string is the current HEAD commit hash.
-func SyncRepo(ctx context.Context, url, workdir string) (string, error) {
-	attempt := func() (string, error) {
-		if _, err := os.Stat(workdir); os.IsNotExist(err) {
-			// shallow clone
-			_, err := git.PlainCloneContext(ctx, workdir, false, &git.CloneOptions{
-				URL:   url,
-				Depth: 1,
-			})
-			if err != nil {
-				return "", err
-			}
-		} else {
-			r, err := git.PlainOpen(workdir)
-			if err != nil {
-				return "", err
-			}
-			// fetch
-			if err := r.FetchContext(ctx, &git.FetchOptions{Depth: 1}); err != nil && err != git.NoErrAlreadyUpToDate {
-				return "", err
-			}
-			// reset to origin/HEAD
-			head, err := r.ResolveRevision(plumbing.Revision("origin/HEAD"))
-			if err != nil {
-				// fallback to master/main
-				head, err = r.ResolveRevision(plumbing.Revision("origin/main"))
-				if err != nil {
-					head, err = r.ResolveRevision(plumbing.Revision("origin/master"))
-					if err != nil {
-						return "", err
-					}
-				}
-			}
-			w, err := r.Worktree()
-			if err != nil {
-				return "", err
-			}
-			if err := w.Reset(&git.ResetOptions{Mode: git.HardReset, Commit: *head}); err != nil {
-				return "", err
+// Options control how SyncRepo fetches and checks out a repository.
+type Options struct {
+	// Ref is the branch, tag or commit to check out. Names are looked up as
+	// branches first, then as tags; full reference names such as
+	// refs/heads/docs are used as they are. Empty means the remote's
+	// default branch.
+	Ref string
+	// Auth authenticates against the remote, see OptionsFor.
+	Auth transport.AuthMethod
+	// SparseDirs, when set, limits the checkout to these directories.
+	SparseDirs []string
+}
+
+// OptionsFor returns the sync options configured for ds. Credentials are
+// read from the environment and the SSH key from disk; a variable that is
+// not set or a key that cannot be loaded is an error. SSH host keys are
+// always checked against a known_hosts file.
+func OptionsFor(ds cfgpkg.DataSource) (Options, error) {
+	opt := Options{Ref: ds.Ref}
+	if ds.Sparse {
+		if dir := strings.Trim(path.Clean("/"+ds.Path), "/"); dir != "" {
+			opt.SparseDirs = []string{dir}
+		}
+	}
+	if ds.TokenEnv != "" && ds.SSHKey != "" {
+		return opt, fmt.Errorf("datasource %s: token_env and ssh_key are mutually exclusive", ds.Name)
+	}
+	switch {
+	case ds.TokenEnv != "":
+		token, err := lookupEnv(ds.Name, ds.TokenEnv)
+		if err != nil {
+			return opt, err
+		}
+		user := ds.Username
+		if user == "" {
+			user = "token"
+		}
+		opt.Auth = &http.BasicAuth{Username: user, Password: token}
+	case ds.SSHKey != "":
+		var passphrase string
+		if ds.SSHKeyPassphraseEnv != "" {
+			var err error
+			if passphrase, err = lookupEnv(ds.Name, ds.SSHKeyPassphraseEnv); err != nil {
+				return opt, err
 			}
 		}
-
-		r, err := git.PlainOpen(workdir)
+		user := ds.Username
+		if user == "" {
+			user = "git"
+		}
+		keys, err := ssh.NewPublicKeysFromFile(user, ds.SSHKey, passphrase)
 		if err != nil {
+			return opt, fmt.Errorf("datasource %s: load ssh key: %w", ds.Name, err)
+		}
+		var files []string
+		if ds.KnownHosts != "" {
+			files = append(files, ds.KnownHosts)
+		}
+		// Without an explicit callback go-git would also fall back to the
+		// default known_hosts files; setting it here makes a missing file
+		// fail now rather than on the first fetch.
+		if keys.HostKeyCallback, err = ssh.NewKnownHostsCallback(files...); err != nil {
+			return opt, fmt.Errorf("datasource %s: load known_hosts: %w", ds.Name, err)
+		}
+		opt.Auth = keys
+	}
+	return opt, nil
+}
+
+func lookupEnv(name, key string) (string, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return "", fmt.Errorf("datasource %s: environment variable %s is not set", name, key)
+	}
+	return v, nil
+}
+
+// SyncRepo ensures the repository at workdir matches opt.Ref of the remote
+// url. Branches and tags are fetched shallowly; a commit is taken from the
+// local clone when present and otherwise fetched with the full history of
+// every branch and tag. The commit is checked out with a detached HEAD,
+// sparsely when opt.SparseDirs is set. A workdir that is not a readable
+// clone of url is removed and cloned again; other errors, such as failed
+// authentication, an unreachable remote or an unknown ref, are returned and
+// leave the workdir as it was. The returned string is the commit hash
+// checked out.
+func SyncRepo(ctx context.Context, url, workdir string, opt Options) (string, error) {
+	r, err := openRepo(workdir, url)
+	if errors.Is(err, errBadClone) {
+		if err := os.RemoveAll(workdir); err != nil {
 			return "", err
 		}
-		ref, err := r.Head()
+		r, err = openRepo(workdir, url)
+	}
+	if err != nil {
+		return "", err
+	}
+	hash, err := fetchRef(ctx, r, opt)
+	if err != nil {
+		return "", err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+	if err := w.Checkout(&git.CheckoutOptions{
+		Hash:                      hash,
+		Force:                     true,
+		SparseCheckoutDirectories: opt.SparseDirs,
+	}); err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// errBadClone is returned by openRepo for a workdir that cannot be used as
+// a clone of the remote.
+var errBadClone = errors.New("unusable clone")
+
+// openRepo opens the repository at workdir, or initialises one with url as
+// its origin when workdir does not exist. It fails with errBadClone when
+// workdir is not a repository, is corrupt or is a clone of another remote.
+func openRepo(workdir, url string) (*git.Repository, error) {
+	if _, err := os.Stat(workdir); os.IsNotExist(err) {
+		r, err := git.PlainInit(workdir, false)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return ref.Hash().String(), nil
+		if _, err := r.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}}); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	r, err := git.PlainOpen(workdir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errBadClone, workdir, err)
+	}
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errBadClone, workdir, err)
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != url {
+		return nil, fmt.Errorf("%w: %s is a clone of %v, not %s", errBadClone, workdir, urls, url)
+	}
+	if _, err := r.Head(); err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		// HEAD exists but cannot be read.
+		return nil, fmt.Errorf("%w: %s: %w", errBadClone, workdir, err)
+	}
+	return r, nil
+}
+
+// fetchRef fetches opt.Ref from origin and returns the commit it points to.
+func fetchRef(ctx context.Context, r *git.Repository, opt Options) (plumbing.Hash, error) {
+	if isCommitHash(opt.Ref) {
+		// Commits do not move, so one already fetched needs no fetch.
+		if h, err := r.ResolveRevision(plumbing.Revision(opt.Ref)); err == nil {
+			return *h, nil
+		}
+	}
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: opt.Auth})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	name, err := remoteRef(refs, opt.Ref)
+	if err != nil {
+		return plumbing.ZeroHash, err
 	}
 
-	if hash, err := attempt(); err == nil {
-		return hash, nil
+	if name == "" {
+		// A commit: fetch everything, without depth, so that it is found
+		// wherever it sits in the history.
+		err = r.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: []config.RefSpec{
+				"+refs/heads/*:refs/remotes/origin/*",
+				"+refs/tags/*:refs/tags/*",
+			},
+			Auth:  opt.Auth,
+			Tags:  git.NoTags,
+			Force: true,
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return plumbing.ZeroHash, err
+		}
+		h, err := r.ResolveRevision(plumbing.Revision(opt.Ref))
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("ref %q: %w", opt.Ref, err)
+		}
+		return *h, nil
 	}
-	_ = os.RemoveAll(workdir)
-	return attempt()
+
+	local := name
+	if name.IsBranch() {
+		local = plumbing.NewRemoteReferenceName(git.DefaultRemoteName, name.Short())
+	}
+	err = r.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec("+" + name.String() + ":" + local.String())},
+		Depth:    1,
+		Auth:     opt.Auth,
+		Tags:     git.NoTags,
+		Force:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return plumbing.ZeroHash, err
+	}
+	ref, err := r.Reference(local, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	// An annotated tag points at a tag object rather than at the commit.
+	if tag, err := r.TagObject(ref.Hash()); err == nil {
+		c, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("tag %s: %w", name.Short(), err)
+		}
+		return c.Hash, nil
+	}
+	return ref.Hash(), nil
 }
 
-// WithAuth returns CloneOptions with basic auth if username/token provided in URL.
-// This is a helper for future extension; currently unused.
-func WithAuth(url, token string) *git.CloneOptions {
-	opts := &git.CloneOptions{URL: url, Depth: 1}
-	if token != "" {
-		opts.Auth = &http.BasicAuth{Username: "token", Password: token}
-	}
-	return opts
+// remoteRef picks the reference advertised by the remote that ref names. It
+// returns an empty name when ref is a commit hash that names no reference.
+func remoteRef(refs []*plumbing.Reference, ref string) (plumbing.ReferenceName, error) {
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, r := range refs {
+		byName[r.Name()] = r
+	}
+	if ref == "" {
+		head, ok := byName[plumbing.HEAD]
+		if !ok {
+			return "", errors.New("remote advertises no HEAD")
+		}
+		if head.Type() == plumbing.SymbolicReference {
+			return head.Target(), nil
+		}
+		// Without the symref capability HEAD is a plain hash; use the
+		// branch it matches, preferring the usual default names.
+		for _, name := range []plumbing.ReferenceName{plumbing.Main, plumbing.Master} {
+			if r, ok := byName[name]; ok && r.Hash() == head.Hash() {
+				return name, nil
+			}
+		}
+		for _, r := range refs {
+			if r.Name().IsBranch() && r.Hash() == head.Hash() {
+				return r.Name(), nil
+			}
+		}
+		return "", errors.New("remote HEAD matches no branch")
+	}
+	candidates := []plumbing.ReferenceName{plumbing.NewBranchReferenceName(ref), plumbing.NewTagReferenceName(ref)}
+	if strings.HasPrefix(ref, "refs/") {
+		candidates = []plumbing.ReferenceName{plumbing.ReferenceName(ref)}
+	}
+	for _, name := range candidates {
+		if _, ok := byName[name]; ok {
+			return name, nil
+		}
+	}
+	if isCommitHash(ref) {
+		return "", nil
+	}
+	return "", fmt.Errorf("ref %q not found on remote", ref)
+}
+
+// isCommitHash reports whether ref looks like a full or abbreviated commit
+// hash.
+func isCommitHash(ref string) bool {
+	if len(ref) < 7 || len(ref) > 40 {
+		return false
+	}
+	_, err := hex.DecodeString(ref + strings.Repeat("0", len(ref)%2))
+	return err == nil
 }
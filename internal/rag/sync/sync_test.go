@@ -0,0 +1,358 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+
+	cfgpkg "rag-server/internal/rag/config"
+)
+
+// remoteRepo is a bare repository fed from a separate work repository.
+type remoteRepo struct {
+	t    *testing.T
+	bare string
+	dir  string
+	work *git.Repository
+}
+
+func newRemoteRepo(t *testing.T) *remoteRepo {
+	t.Helper()
+	root := t.TempDir()
+	bare, dir := filepath.Join(root, "remote.git"), filepath.Join(root, "work")
+	initOpts := func(isBare bool) *git.PlainInitOptions {
+		return &git.PlainInitOptions{InitOptions: git.InitOptions{DefaultBranch: plumbing.Main}, Bare: isBare}
+	}
+	if _, err := git.PlainInitWithOptions(bare, initOpts(true)); err != nil {
+		t.Fatal(err)
+	}
+	work, err := git.PlainInitWithOptions(dir, initOpts(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := work.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{bare}}); err != nil {
+		t.Fatal(err)
+	}
+	return &remoteRepo{t: t, bare: bare, dir: dir, work: work}
+}
+
+// commit commits files on the current branch and pushes every branch and
+// tag.
+func (rr *remoteRepo) commit(files map[string]*string) string {
+	rr.t.Helper()
+	h := commitFiles(rr.t, rr.work, rr.dir, files)
+	rr.push()
+	return h
+}
+
+func (rr *remoteRepo) push() {
+	rr.t.Helper()
+	err := rr.work.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		rr.t.Fatal(err)
+	}
+}
+
+func (rr *remoteRepo) checkout(branch string, create bool) {
+	rr.t.Helper()
+	w, err := rr.work.Worktree()
+	if err != nil {
+		rr.t.Fatal(err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create}); err != nil {
+		rr.t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSyncRepoDefaultBranch(t *testing.T) {
+	ctx := context.Background()
+	rr := newRemoteRepo(t)
+	first := rr.commit(map[string]*string{"docs/a.md": text("# A\n")})
+	workdir := filepath.Join(t.TempDir(), "clone")
+
+	head, err := SyncRepo(ctx, rr.bare, workdir, Options{})
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	if head != first {
+		t.Fatalf("head = %s, want %s", head, first)
+	}
+	if got := readFile(t, filepath.Join(workdir, "docs/a.md")); got != "# A\n" {
+		t.Fatalf("docs/a.md = %q", got)
+	}
+
+	second := rr.commit(map[string]*string{"docs/a.md": text("# A\n\nchanged\n"), "docs/b.md": text("# B\n")})
+	if head, err = SyncRepo(ctx, rr.bare, workdir, Options{}); err != nil || head != second {
+		t.Fatalf("SyncRepo after a commit = %s, %v, want %s", head, err, second)
+	}
+	if got := readFile(t, filepath.Join(workdir, "docs/a.md")); got != "# A\n\nchanged\n" {
+		t.Fatalf("docs/a.md = %q after the update", got)
+	}
+	// The commit synced before stays in the clone for incremental ingestion.
+	ch, err := Diff(ctx, workdir, first, second)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if len(ch.Modified) != 2 {
+		t.Fatalf("Diff = %+v", ch)
+	}
+}
+
+func TestSyncRepoRefs(t *testing.T) {
+	ctx := context.Background()
+	rr := newRemoteRepo(t)
+	first := rr.commit(map[string]*string{"version.md": text("one\n")})
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	if _, err := rr.work.CreateTag("v1", plumbing.NewHash(first), nil); err != nil {
+		t.Fatal(err)
+	}
+	second := rr.commit(map[string]*string{"version.md": text("two\n")})
+	if _, err := rr.work.CreateTag("v2", plumbing.NewHash(second), &git.CreateTagOptions{Tagger: sig, Message: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	third := rr.commit(map[string]*string{"version.md": text("three\n")})
+	rr.checkout("docs", true)
+	docs := rr.commit(map[string]*string{"version.md": text("docs\n")})
+
+	// One workdir is switched between refs, the way a changed config would.
+	workdir := filepath.Join(t.TempDir(), "clone")
+	cases := []struct {
+		ref, want, content string
+	}{
+		{"", third, "three\n"},
+		{"docs", docs, "docs\n"},
+		{"refs/heads/main", third, "three\n"},
+		{"v1", first, "one\n"},
+		{"v2", second, "two\n"},
+		{first, first, "one\n"},
+		{second[:12], second, "two\n"},
+	}
+	for _, tc := range cases {
+		head, err := SyncRepo(ctx, rr.bare, workdir, Options{Ref: tc.ref})
+		if err != nil {
+			t.Fatalf("ref %q: %v", tc.ref, err)
+		}
+		if head != tc.want {
+			t.Fatalf("ref %q: head = %s, want %s", tc.ref, head, tc.want)
+		}
+		if got := readFile(t, filepath.Join(workdir, "version.md")); got != tc.content {
+			t.Fatalf("ref %q: version.md = %q, want %q", tc.ref, got, tc.content)
+		}
+	}
+
+	if _, err := SyncRepo(ctx, rr.bare, filepath.Join(t.TempDir(), "clone"), Options{Ref: "missing"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected a missing ref error, got %v", err)
+	}
+}
+
+func TestSyncRepoSparse(t *testing.T) {
+	rr := newRemoteRepo(t)
+	head := rr.commit(map[string]*string{
+		"docs/a.md":       text("# A\n"),
+		"docs/guide/b.md": text("# B\n"),
+		"README.md":       text("# Readme\n"),
+		"src/main.go":     text("package main\n"),
+	})
+	workdir := filepath.Join(t.TempDir(), "clone")
+	got, err := SyncRepo(context.Background(), rr.bare, workdir, Options{SparseDirs: []string{"docs"}})
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	if got != head {
+		t.Fatalf("head = %s, want %s", got, head)
+	}
+	for _, name := range []string{"docs/a.md", "docs/guide/b.md"} {
+		if _, err := os.Stat(filepath.Join(workdir, name)); err != nil {
+			t.Fatalf("%s not checked out: %v", name, err)
+		}
+	}
+	for _, name := range []string{"README.md", "src/main.go"} {
+		if _, err := os.Stat(filepath.Join(workdir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s checked out outside the sparse directories", name)
+		}
+	}
+}
+
+func TestSyncRepoReplacesOtherClone(t *testing.T) {
+	ctx := context.Background()
+	a, b := newRemoteRepo(t), newRemoteRepo(t)
+	a.commit(map[string]*string{"a.md": text("a\n")})
+	headB := b.commit(map[string]*string{"b.md": text("b\n")})
+	workdir := filepath.Join(t.TempDir(), "clone")
+	if _, err := SyncRepo(ctx, a.bare, workdir, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := SyncRepo(ctx, b.bare, workdir, Options{})
+	if err != nil || head != headB {
+		t.Fatalf("SyncRepo of another remote = %s, %v, want %s", head, err, headB)
+	}
+	if _, err := os.Stat(filepath.Join(workdir, "a.md")); !os.IsNotExist(err) {
+		t.Fatal("files of the previous remote were kept")
+	}
+}
+
+func TestSyncRepoReplacesBrokenClone(t *testing.T) {
+	ctx := context.Background()
+	rr := newRemoteRepo(t)
+	head := rr.commit(map[string]*string{"a.md": text("a\n")})
+	for name, breakIt := range map[string]func(dir string) error{
+		"not a repository": func(dir string) error { return os.RemoveAll(filepath.Join(dir, ".git")) },
+		"corrupt HEAD": func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("garbage"), 0o644)
+		},
+	} {
+		workdir := filepath.Join(t.TempDir(), "clone")
+		if _, err := SyncRepo(ctx, rr.bare, workdir, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := breakIt(workdir); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := SyncRepo(ctx, rr.bare, workdir, Options{}); err != nil || got != head {
+			t.Fatalf("%s: SyncRepo = %s, %v, want %s", name, got, err, head)
+		}
+	}
+}
+
+func TestSyncRepoKeepsCloneOnError(t *testing.T) {
+	ctx := context.Background()
+	rr := newRemoteRepo(t)
+	rr.commit(map[string]*string{"a.md": text("a\n")})
+	workdir := filepath.Join(t.TempDir(), "clone")
+	if _, err := SyncRepo(ctx, rr.bare, workdir, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(workdir, ".git", "kept")
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SyncRepo(ctx, rr.bare, workdir, Options{Ref: "no-such-branch"}); err == nil {
+		t.Fatal("expected an error for an unknown ref")
+	}
+	if err := os.RemoveAll(rr.bare); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SyncRepo(ctx, rr.bare, workdir, Options{}); err == nil {
+		t.Fatal("expected an error for an unreachable remote")
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("clone was removed: %v", err)
+	}
+}
+
+func TestOptionsFor(t *testing.T) {
+	opt, err := OptionsFor(cfgpkg.DataSource{Name: "kb", Ref: "v1", Path: "/docs/", Sparse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Ref != "v1" || !reflect.DeepEqual(opt.SparseDirs, []string{"docs"}) || opt.Auth != nil {
+		t.Fatalf("unexpected options %+v", opt)
+	}
+	if opt, _ := OptionsFor(cfgpkg.DataSource{Path: "/", Sparse: true}); opt.SparseDirs != nil {
+		t.Fatalf("sparse checkout of the root = %v", opt.SparseDirs)
+	}
+
+	t.Setenv("RAG_TEST_GIT_TOKEN", "")
+	if _, err := OptionsFor(cfgpkg.DataSource{Name: "kb", TokenEnv: "RAG_TEST_GIT_TOKEN"}); err == nil || !strings.Contains(err.Error(), "RAG_TEST_GIT_TOKEN") {
+		t.Fatalf("expected an unset variable error, got %v", err)
+	}
+	t.Setenv("RAG_TEST_GIT_TOKEN", "secret")
+	opt, err = OptionsFor(cfgpkg.DataSource{Name: "kb", TokenEnv: "RAG_TEST_GIT_TOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth, ok := opt.Auth.(*http.BasicAuth); !ok || auth.Username != "token" || auth.Password != "secret" {
+		t.Fatalf("unexpected auth %#v", opt.Auth)
+	}
+	if _, err := OptionsFor(cfgpkg.DataSource{Name: "kb", TokenEnv: "RAG_TEST_GIT_TOKEN", SSHKey: "id"}); err == nil {
+		t.Fatal("expected token_env and ssh_key to conflict")
+	}
+}
+
+func TestOptionsForSSH(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hostPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewPublicKey(hostPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := "git.example.com " + string(ssh.MarshalAuthorizedKey(hostKey))
+	if err := os.WriteFile(knownHosts, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := cfgpkg.DataSource{Name: "kb", SSHKey: keyPath, KnownHosts: filepath.Join(dir, "missing")}
+	if _, err := OptionsFor(ds); err == nil || !strings.Contains(err.Error(), "known_hosts") {
+		t.Fatalf("expected a known_hosts error, got %v", err)
+	}
+	ds.KnownHosts = knownHosts
+	opt, err := OptionsFor(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, ok := opt.Auth.(*gitssh.PublicKeys)
+	if !ok || keys.User != "git" {
+		t.Fatalf("unexpected auth %#v", opt.Auth)
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	if err := keys.HostKeyCallback("git.example.com:22", addr, hostKey); err != nil {
+		t.Fatalf("known host rejected: %v", err)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(otherPub)
+	if err := keys.HostKeyCallback("git.example.com:22", addr, otherKey); err == nil {
+		t.Fatal("changed host key accepted")
+	}
+	if err := keys.HostKeyCallback("other.example.com:22", addr, hostKey); err == nil {
+		t.Fatal("unknown host accepted")
+	}
+
+	t.Setenv("RAG_TEST_SSH_PASSPHRASE", "")
+	ds.SSHKeyPassphraseEnv = "RAG_TEST_SSH_PASSPHRASE"
+	if _, err := OptionsFor(ds); err == nil {
+		t.Fatal("expected an unset passphrase variable error")
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"rag-server/internal/model"
+)
+
+// ErrConversationNotFound is returned when a conversation does not exist or
+// belongs to a different user.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+const conversationTitleLen = 80
+
+// ConversationWithMessages bundles a conversation with its messages.
+type ConversationWithMessages struct {
+	model.Conversation
+	Messages []model.ConversationMessage `json:"messages"`
+}
+
+// CreateConversation starts a new conversation for userID. The title is
+// derived from the first question.
+func CreateConversation(ctx context.Context, userID, question string) (model.Conversation, error) {
+	if db == nil {
+		return model.Conversation{}, ErrServiceDBNotInitialized
+	}
+	id, err := newConversationID()
+	if err != nil {
+		return model.Conversation{}, err
+	}
+	conv := model.Conversation{
+		ID:     id,
+		UserID: userID,
+		Title:  conversationTitle(question),
+	}
+	if err := db.WithContext(ctx).Create(&conv).Error; err != nil {
+		return model.Conversation{}, err
+	}
+	return conv, nil
+}
+
+// GetConversation returns the conversation identified by id when it belongs to
+// userID.
+func GetConversation(ctx context.Context, userID, id string) (model.Conversation, error) {
+	if db == nil {
+		return model.Conversation{}, ErrServiceDBNotInitialized
+	}
+	var conv model.Conversation
+	err := db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&conv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Conversation{}, ErrConversationNotFound
+	}
+	return conv, err
+}
+
+// ListConversations returns the conversations of userID, most recent first.
+func ListConversations(ctx context.Context, userID string) ([]model.Conversation, error) {
+	if db == nil {
+		return nil, ErrServiceDBNotInitialized
+	}
+	convs := []model.Conversation{}
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).Order("updated_at DESC").Find(&convs).Error; err != nil {
+		return nil, err
+	}
+	return convs, nil
+}
+
+// GetConversationWithMessages returns a conversation and all of its messages in
+// chronological order.
+func GetConversationWithMessages(ctx context.Context, userID, id string) (ConversationWithMessages, error) {
+	conv, err := GetConversation(ctx, userID, id)
+	if err != nil {
+		return ConversationWithMessages{}, err
+	}
+	msgs := []model.ConversationMessage{}
+	if err := db.WithContext(ctx).Where("conversation_id = ?", id).Order("id ASC").Find(&msgs).Error; err != nil {
+		return ConversationWithMessages{}, err
+	}
+	return ConversationWithMessages{Conversation: conv, Messages: msgs}, nil
+}
+
+// DeleteConversation removes a conversation of userID together with its
+// messages.
+func DeleteConversation(ctx context.Context, userID, id string) error {
+	if db == nil {
+		return ErrServiceDBNotInitialized
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&model.Conversation{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrConversationNotFound
+		}
+		return tx.Where("conversation_id = ?", id).Delete(&model.ConversationMessage{}).Error
+	})
+}
+
+// RecentMessages returns up to limit of the latest messages of a conversation
+// in chronological order.
+func RecentMessages(ctx context.Context, conversationID string, limit int) ([]model.ConversationMessage, error) {
+	if db == nil {
+		return nil, ErrServiceDBNotInitialized
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	var msgs []model.ConversationMessage
+	if err := db.WithContext(ctx).Where("conversation_id = ?", conversationID).Order("id DESC").Limit(limit).Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// AppendMessages stores messages in a conversation and bumps its update time.
+func AppendMessages(ctx context.Context, conversationID string, msgs ...model.ConversationMessage) error {
+	if db == nil {
+		return ErrServiceDBNotInitialized
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range msgs {
+			msgs[i].ConversationID = conversationID
+		}
+		if err := tx.Create(&msgs).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Conversation{}).Where("id = ?", conversationID).Update("updated_at", time.Now()).Error
+	})
+}
+
+func conversationTitle(question string) string {
+	title := strings.Join(strings.Fields(question), " ")
+	if utf8.RuneCountInString(title) <= conversationTitleLen {
+		return title
+	}
+	runes := []rune(title)
+	return string(runes[:conversationTitleLen]) + "…"
+}
+
+// newConversationID returns a random RFC 4122 version 4 UUID.
+func newConversationID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,137 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"rag-server/api"
+	rconfig "rag-server/internal/rag/config"
+	"rag-server/internal/rag/embed"
+	"rag-server/internal/rag/ingest"
+	"rag-server/internal/rag/store"
+)
+
+const offlineConfig = `
+global:
+  vectordb:
+    backend: memory
+models:
+  embedder:
+    provider: local-hash
+  generator:
+    provider: echo
+embedding:
+  dimension: 128
+`
+
+const offlineDoc = `# Operations
+
+## Deploy
+
+Run make deploy to build the image and roll it out.
+
+## Backup
+
+Back up the database every night with pg_dump and keep seven days.
+`
+
+// TestOfflineIngestQueryAskAI runs ingest, retrieval and AskAI with the
+// local-hash embedder, the echo generator and the memory backend, so it needs
+// no model endpoint or database.
+func TestOfflineIngestQueryAskAI(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "server.yaml")
+	docPath := filepath.Join(dir, "ops.md")
+	if err := os.WriteFile(cfgPath, []byte(offlineConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(docPath, []byte(offlineDoc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldAPI, oldRAG := api.ConfigPath, rconfig.ServerConfigPath
+	api.ConfigPath, rconfig.ServerConfigPath = cfgPath, cfgPath
+	defer func() { api.ConfigPath, rconfig.ServerConfigPath = oldAPI, oldRAG }()
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "offline")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.RegisterRoutes(nil, "")(r)
+	post := func(path string, body any, out any) {
+		t.Helper()
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Service-Token", "offline")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	// Ingest the way rag-cli does: chunk and embed locally, upsert through
+	// the API.
+	cfg, err := rconfig.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secs, err := ingest.ParseMarkdown(docPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := ingest.BuildChunks(secs, cfg.ResolveChunking())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]store.DocRow, len(chunks))
+	for i, ch := range chunks {
+		rows[i] = store.DocRow{Repo: "kb", Path: "ops.md", ChunkID: ch.ChunkID, Content: ch.Text, Metadata: ch.Meta, ContentSHA: ch.SHA256}
+	}
+	emb, err := embed.New(cfg.ResolveEmbedding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ingest.EmbedRows(context.Background(), emb, embed.ModelID(cfg.ResolveEmbedding()), rows, nil); err != nil {
+		t.Fatal(err)
+	}
+	var upserted struct {
+		Rows int `json:"rows"`
+	}
+	post("/api/rag/upsert", map[string]any{"docs": rows}, &upserted)
+	if upserted.Rows != len(rows) {
+		t.Fatalf("upserted %d of %d rows", upserted.Rows, len(rows))
+	}
+
+	var queried struct {
+		Chunks []struct {
+			Content string `json:"content"`
+		} `json:"chunks"`
+	}
+	post("/api/rag/query", map[string]any{"question": "database backup with pg_dump", "k": 1}, &queried)
+	if len(queried.Chunks) != 1 || !strings.Contains(queried.Chunks[0].Content, "pg_dump") {
+		t.Fatalf("unexpected chunks %+v", queried.Chunks)
+	}
+
+	var asked struct {
+		Answer    string `json:"answer"`
+		Citations []struct {
+			Path string `json:"path"`
+		} `json:"citations"`
+	}
+	post("/api/askai", map[string]any{"question": "How do I deploy?"}, &asked)
+	if !strings.Contains(asked.Answer, "make deploy") || len(asked.Citations) == 0 {
+		t.Fatalf("unexpected answer %q citations %+v", asked.Answer, asked.Citations)
+	}
+}
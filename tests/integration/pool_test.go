@@ -0,0 +1,120 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rag-server/internal/cache"
+	rconfig "rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+)
+
+// TestPoolConcurrentQueriesAndCache hammers one shared pool with vector
+// searches and cache reads and writes from many goroutines and checks that
+// every caller gets its own results back. It needs DATABASE_URL pointing at a
+// Postgres with the vector, zhparser and hstore extensions available.
+func TestPoolConcurrentQueriesAndCache(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("Skipping load test: DATABASE_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	pc, err := rconfig.VectorDB{PGURL: dsn, Pool: rconfig.PoolCfg{MaxConns: 8}}.PoolConfig()
+	if err != nil {
+		t.Fatalf("pool config: %v", err)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, pc)
+	if err != nil {
+		t.Fatalf("open pool: %v", err)
+	}
+	defer pool.Close()
+
+	vs := store.NewPostgres(pool)
+	if err := vs.EnsureSchema(ctx, 8, false); err != nil {
+		t.Skipf("Skipping load test: schema unavailable: %v", err)
+	}
+	// Reuse whatever dimension an existing documents table was created with.
+	var dim int
+	if err := pool.QueryRow(ctx, `SELECT atttypmod FROM pg_attribute WHERE attrelid = 'documents'::regclass AND attname = 'embedding'`).Scan(&dim); err != nil || dim <= 0 {
+		t.Fatalf("embedding dimension: %d, %v", dim, err)
+	}
+
+	const docs = 16
+	repo := fmt.Sprintf("pool-load-%d", time.Now().UnixNano())
+	rows := make([]store.DocRow, docs)
+	paths := make([]string, docs)
+	for i := range rows {
+		vec := make([]float32, dim)
+		vec[i%dim] = 1
+		paths[i] = fmt.Sprintf("doc-%d.md", i)
+		rows[i] = store.DocRow{Repo: repo, Path: paths[i], Content: fmt.Sprintf("load test document %d", i), Embedding: vec, ContentSHA: fmt.Sprint(i)}
+	}
+	if _, err := vs.Upsert(ctx, rows); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	defer func() { _, _ = vs.Delete(context.Background(), repo, paths) }()
+
+	kv := cache.NewPostgresStore(pool, cache.Options{Table: "cache_kv_load_test"})
+	if err := kv.EnsureSchema(ctx); err != nil {
+		t.Skipf("Skipping load test: cache schema unavailable: %v", err)
+	}
+	defer func() { _, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS cache_kv_load_test") }()
+
+	const (
+		workers    = 32
+		iterations = 25
+	)
+	filter := store.SearchFilter{RepoPrefix: repo}
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				want := (w + i) % docs
+				hits, err := vs.VectorSearch(ctx, rows[want].Embedding, 1, filter)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(hits) != 1 || hits[0].Path != paths[want] {
+					errs <- fmt.Errorf("worker %d: query for %s returned %+v", w, paths[want], hits)
+					return
+				}
+
+				key := fmt.Sprintf("%s:%d:%d", repo, w, i)
+				value := fmt.Sprintf("value-%d-%d", w, i)
+				if err := kv.Set(ctx, key, value, time.Minute); err != nil {
+					errs <- err
+					return
+				}
+				got, ok, err := kv.Get(ctx, key)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !ok || got != value {
+					errs <- fmt.Errorf("worker %d: cache %s = %q, %v; want %q", w, key, got, ok, value)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if limit := pool.Stat().MaxConns(); pool.Stat().TotalConns() > limit {
+		t.Fatalf("pool grew beyond max_conns %d", limit)
+	}
+}
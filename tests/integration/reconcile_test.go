@@ -0,0 +1,106 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	rconfig "rag-server/internal/rag/config"
+	"rag-server/internal/rag/store"
+)
+
+// TestPostgresReconcile checks that Reconcile removes stale chunks of one
+// datasource and leaves kept paths and other prefixes alone. It needs
+// DATABASE_URL like TestPoolConcurrentQueriesAndCache.
+func TestPostgresReconcile(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("Skipping reconcile test: DATABASE_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	pc, err := rconfig.VectorDB{PGURL: dsn}.PoolConfig()
+	if err != nil {
+		t.Fatalf("pool config: %v", err)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, pc)
+	if err != nil {
+		t.Fatalf("open pool: %v", err)
+	}
+	defer pool.Close()
+
+	vs := store.NewPostgres(pool)
+	if err := vs.EnsureSchema(ctx, 8, false); err != nil {
+		t.Skipf("Skipping reconcile test: schema unavailable: %v", err)
+	}
+	var dim int
+	if err := pool.QueryRow(ctx, `SELECT atttypmod FROM pg_attribute WHERE attrelid = 'documents'::regclass AND attname = 'embedding'`).Scan(&dim); err != nil || dim <= 0 {
+		t.Fatalf("embedding dimension: %d, %v", dim, err)
+	}
+
+	repo := fmt.Sprintf("reconcile-%d", time.Now().UnixNano())
+	defer pool.Exec(context.Background(), `DELETE FROM documents WHERE repo = $1`, repo)
+	defer pool.Exec(context.Background(), `DELETE FROM ingest_state WHERE repo = $1`, repo)
+	var rows []store.DocRow
+	for _, d := range []struct {
+		path  string
+		chunk int
+	}{{"docs/a.md", 0}, {"docs/a.md", 1}, {"docs/b.md", 0}, {"docs/c.md", 0}, {"docs_x/a.md", 0}, {"README.md", 0}} {
+		vec := make([]float32, dim)
+		vec[0] = 1
+		rows = append(rows, store.DocRow{Repo: repo, Path: d.path, ChunkID: d.chunk, Content: d.path, Embedding: vec, ContentSHA: fmt.Sprint(d.path, d.chunk)})
+	}
+	if _, err := vs.Upsert(ctx, rows); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+
+	res, err := vs.Reconcile(ctx, store.ReconcileSpec{
+		Repo:       repo,
+		PathPrefix: "docs",
+		Keep:       []string{store.DocKey(repo, "docs/a.md", 0)},
+		KeepPaths:  []string{"docs/c.md"},
+	})
+	if err != nil || res.Rows != 2 || res.Paths != 1 {
+		t.Fatalf("Reconcile = %+v, %v", res, err)
+	}
+	var left []string
+	r, err := pool.Query(ctx, `SELECT doc_key FROM documents WHERE repo = $1 ORDER BY doc_key`, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r.Next() {
+		var k string
+		if err := r.Scan(&k); err != nil {
+			t.Fatal(err)
+		}
+		left = append(left, k)
+	}
+	r.Close()
+	want := []string{repo + ":README.md:0", repo + ":docs/a.md:0", repo + ":docs/c.md:0", repo + ":docs_x/a.md:0"}
+	if fmt.Sprint(left) != fmt.Sprint(want) {
+		t.Fatalf("remaining rows %v, want %v", left, want)
+	}
+
+	// Paths limits an incremental run to the files it touched.
+	res, err = vs.Reconcile(ctx, store.ReconcileSpec{Repo: repo, Paths: []string{"docs/c.md", "README.md"}})
+	if err != nil || res.Rows != 2 || res.Paths != 2 {
+		t.Fatalf("Reconcile with paths = %+v, %v", res, err)
+	}
+
+	if c, err := vs.IngestedCommit(ctx, repo, "docs"); err != nil || c != (store.IngestState{}) {
+		t.Fatalf("IngestedCommit = %+v, %v", c, err)
+	}
+	for _, commit := range []string{"abc", "def"} {
+		if err := vs.SetIngestedCommit(ctx, repo, "docs", store.IngestState{Commit: commit, Config: "cfg-" + commit}); err != nil {
+			t.Fatalf("SetIngestedCommit: %v", err)
+		}
+	}
+	if c, err := vs.IngestedCommit(ctx, repo, "docs"); err != nil || c != (store.IngestState{Commit: "def", Config: "cfg-def"}) {
+		t.Fatalf("IngestedCommit = %+v, %v", c, err)
+	}
+}